@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/lists"
+	"fast-ingest/internal/storage"
+
+	"github.com/joho/godotenv"
+)
+
+// Deletes or anonymizes every event of a user, like POST /admin/erasures but synchronously.
+func main() {
+	userID := flag.String("user", "", "user_id to erase")
+	mode := flag.String("mode", storage.ErasureDelete, "delete or anonymize")
+	metadataKeys := flag.String("metadata-keys", "", "comma separated metadata keys to scrub on anonymize, in addition to the configured ones")
+	requestedBy := flag.String("requested-by", "", "who requested the erasure, recorded in the audit log")
+	flag.Parse()
+
+	if *userID == "" {
+		log.Fatal("-user is required")
+	}
+	if *mode != storage.ErasureDelete && *mode != storage.ErasureAnonymize {
+		log.Fatalf("Invalid -mode %q (expected delete or anonymize)", *mode)
+	}
+
+	// Load environment variables from .env.dev file
+	err := godotenv.Load(".env.dev")
+	if err != nil {
+		log.Println("Error loading .env file")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	store, err := storage.NewPostgres(ctx)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer store.Close()
+
+	erasureDTO := api.ErasureRequestDTO{
+		UserID:       *userID,
+		Mode:         *mode,
+		RequestedBy:  *requestedBy,
+		MetadataKeys: lists.Split(*metadataKeys),
+	}
+
+	result, err := store.EraseUser(ctx, erasureDTO)
+	if err != nil {
+		log.Fatalf("Erasure failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(result)
+}
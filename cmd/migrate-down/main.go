@@ -30,6 +30,18 @@ func main() {
 	}
 	defer conn.Close(ctx)
 
+	_, err = conn.Exec(ctx, `DROP TABLE IF EXISTS erasure_audit_log`)
+	if err != nil {
+		log.Fatalf("Failed to drop erasure audit log: %v", err)
+	}
+	log.Println("Dropped table: erasure_audit_log")
+
+	_, err = conn.Exec(ctx, `DROP TABLE IF EXISTS events_hourly_rollup, events_daily_rollup, rollup_state`)
+	if err != nil {
+		log.Fatalf("Failed to drop rollup tables: %v", err)
+	}
+	log.Println("Dropped tables: events_hourly_rollup, events_daily_rollup, rollup_state")
+
 	_, err = conn.Exec(ctx, `DROP TABLE IF EXISTS events`)
 	if err != nil {
 		log.Fatalf("Failed to drop events table: %v", err)
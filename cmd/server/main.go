@@ -4,14 +4,24 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // Embed the timezone database, the runtime image doesn't ship one
 
 	"fast-ingest/internal/api"
+	"fast-ingest/internal/consumer"
+	"fast-ingest/internal/grpcapi"
+	"fast-ingest/internal/ingest"
+	"fast-ingest/internal/otlp"
+	"fast-ingest/internal/redact"
+	"fast-ingest/internal/sampling"
+	"fast-ingest/internal/sink"
 	"fast-ingest/internal/storage"
+	"fast-ingest/internal/transform"
 	"fast-ingest/internal/worker"
 
 	"github.com/joho/godotenv"
@@ -38,8 +48,85 @@ func main() {
 
 	// Set up the router
 	server := api.NewServer(store, 20000) // Queue size of 20,000 for event processing
+	server.TierLimits = store.TierLimits()
+	server.Jobs = api.NewJobs(ctx)
 	r := api.NewRouter(server)
 
+	// Inactivity gap used to reconstruct sessions, defaults to 30 minutes
+	server.SessionGap, err = api.SessionGapFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid SESSION_GAP value: %v", err)
+	}
+
+	// Directory async exports are written to
+	if exportDir := os.Getenv("EXPORT_DIR"); exportDir != "" {
+		server.ExportDir = exportDir
+	}
+
+	// Bearer token of the admin API, the admin API is disabled when not set
+	server.AdminToken = os.Getenv("ADMIN_TOKEN")
+
+	// Transformation rules applied at ingest, see TRANSFORM_RULES_FILE
+	server.Transformer, err = transform.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid transformation rules: %v", err)
+	}
+
+	// Sampling rules applied at ingest, see SAMPLING_RULES_FILE
+	server.Sampler, err = sampling.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid sampling rules: %v", err)
+	}
+
+	// Enrichment processors applied at ingest, see ENRICHMENT_CONFIG_FILE
+	server.Pipeline, err = ingest.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid enrichment config: %v", err)
+	}
+	defer server.Pipeline.Close()
+
+	// PII redaction rules applied at ingest, see REDACTION_RULES_FILE
+	server.Redactor, err = redact.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid redaction rules: %v", err)
+	}
+
+	// Allowed origins and URL signing of the pixel and beacon endpoints, see BEACON_SIGNING_SECRET
+	server.Beacon = api.BeaconConfigFromEnv()
+
+	// Log record attributes mapped onto events by the OTLP logs receiver, see OTLP_EVENT_ATTRIBUTE
+	server.OTLP = otlp.ConfigFromEnv()
+
+	// API keys, allowed origins and idle timeout of WebSocket ingest, see WS_API_KEYS
+	server.WebSocket, err = api.WebSocketConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid WebSocket config: %v", err)
+	}
+
+	// Downstream destinations stored events are published to, see SINKS_CONFIG_FILE
+	sinks, err := sink.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid sinks config: %v", err)
+	}
+	defer sinks.Close()
+
+	// Stored batches are published to the live tail and the configured destinations
+	storedSinks := []storage.Sink{server.Tail}
+	if sinks != nil {
+		server.Sinks = sinks
+		storedSinks = append(storedSinks, sinks)
+	}
+
+	// Optional Kafka consumer, an alternative ingest source, see KAFKA_BROKERS
+	kafkaConsumer, err := consumer.FromEnv(server)
+	if err != nil {
+		log.Fatalf("Invalid Kafka config: %v", err)
+	}
+	if kafkaConsumer != nil {
+		server.Consumer = kafkaConsumer
+		kafkaConsumer.Sinks = storedSinks
+	}
+
 	// Get the port from environment variables, default to 8080 if not set
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -60,17 +147,52 @@ func main() {
 		}
 	}()
 
+	// The gRPC ingest service listens next to HTTP and shares its queue, defaults to 9090
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = "9090"
+	}
+	grpcServer := grpcapi.NewGRPCServer(server)
+	go func() {
+		lis, err := net.Listen("tcp", fmt.Sprintf(":%v", grpcPort))
+		if err != nil {
+			log.Fatalf("Could not listen on :%s: %v\n", grpcPort, err)
+		}
+		log.Printf("Starting the gRPC server on :%s\n", grpcPort)
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Fatalf("gRPC server failed: %v\n", err)
+		}
+	}()
+
 	// Initialize the worker for processing events from the queue
 	w := &worker.Writer{
 		Store:         store,
 		In:            server.Queue,
 		BatchSize:     500,
 		FlushInterval: 100 * time.Millisecond,
+		Sinks:         storedSinks,
+	}
+
+	// Deliver to the configured destinations in the background
+	if sinks != nil {
+		go sinks.Run(ctx)
 	}
 
 	// Start the writer in a separate goroutine
 	go w.Run(ctx)
 
+	// The Kafka consumer writes and commits its own batches, next to the writer
+	if kafkaConsumer != nil {
+		go kafkaConsumer.Run(ctx)
+	}
+
+	// Rebuild daily rollups in the background, they serve metrics older than the raw retention window
+	c := &worker.Compactor{
+		Store:    store,
+		Interval: 10 * time.Minute,
+	}
+	go c.Run(ctx)
+
 	// Listen for the interrupt signal
 	<-ctx.Done()
 
@@ -81,8 +203,23 @@ func main() {
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	// End live tails first, open streams would hold up the shutdown
+	server.Tail.Close()
+
 	// Trigger graceful shutdown
 	if err := httpServer.Shutdown(shutdownCtx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+
+	// Let open gRPC streams finish within the same timeout
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
+	select {
+	case <-grpcStopped:
+	case <-shutdownCtx.Done():
+		grpcServer.Stop()
+	}
 }
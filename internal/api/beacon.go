@@ -0,0 +1,316 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/ingest"
+	"fast-ingest/internal/lists"
+	"fast-ingest/internal/model"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pixelGIF is a transparent 1x1 GIF.
+var pixelGIF = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// Outcomes of a pixel request, reported in the X-Ingest-Status header since the image is always served.
+const (
+	pixelAccepted     = "accepted"
+	pixelDropped      = "dropped"
+	pixelInvalid      = "invalid"
+	pixelUnauthorized = "unauthorized"
+	pixelRejected     = "rejected"
+	pixelQueueFull    = "queue_full"
+	pixelForbidden    = "origin_not_allowed"
+)
+
+// beaconFields are the event fields a beacon URL can pin as query parameters.
+var beaconFields = []string{"event_name", "channel", "campaign_id", "user_id"}
+
+// BeaconConfig configures GET /p.gif and POST /beacon.
+type BeaconConfig struct {
+	// AllowedOrigins are the origins browsers may send from, eg: https://app.example.com. Any origin is allowed while empty.
+	AllowedOrigins []string
+
+	// SigningSecret makes a valid sig query parameter mandatory, so URLs can't be forged. Signatures are off while empty.
+	SigningSecret []byte
+}
+
+// BeaconConfigFromEnv reads BEACON_ALLOWED_ORIGINS, comma separated, and BEACON_SIGNING_SECRET.
+func BeaconConfigFromEnv() BeaconConfig {
+	return BeaconConfig{
+		AllowedOrigins: lists.Split(os.Getenv("BEACON_ALLOWED_ORIGINS")),
+		SigningSecret:  []byte(os.Getenv("BEACON_SIGNING_SECRET")),
+	}
+}
+
+// SignQuery returns the sig parameter of a query: the hex HMAC-SHA256 of every other parameter, URL encoded and sorted by key.
+func SignQuery(secret []byte, query url.Values) string {
+	return hex.EncodeToString(querySignature(secret, query))
+}
+
+func querySignature(secret []byte, query url.Values) []byte {
+	unsigned := make(url.Values, len(query))
+	for key, values := range query {
+		if key != "sig" {
+			unsigned[key] = values
+		}
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned.Encode()))
+	return mac.Sum(nil)
+}
+
+// verified reports whether query carries a valid signature that hasn't expired, or signatures are off.
+func (c BeaconConfig) verified(query url.Values) bool {
+	if len(c.SigningSecret) == 0 {
+		return true
+	}
+
+	sig, err := hex.DecodeString(query.Get("sig"))
+	if err != nil || !hmac.Equal(sig, querySignature(c.SigningSecret, query)) {
+		return false
+	}
+
+	// exp is optional, it is covered by the signature when set
+	if exp := query.Get("exp"); exp != "" {
+		unix, err := strconv.ParseInt(exp, 10, 64)
+		if err != nil || time.Now().Unix() > unix {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowBeaconOrigin sets the CORS headers and refuses browsers sending from origins not in the allow list.
+// Pixels fetched from such origins are still served, without their event. Requests without Origin,
+// such as images in emails, are let through.
+func (s *Server) AllowBeaconOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		switch {
+		case origin == "":
+		case len(s.Beacon.AllowedOrigins) == 0:
+			// Without an allow list any origin may send, but never with the credentials of its users
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case slices.Contains(s.Beacon.AllowedOrigins, origin):
+			// sendBeacon sends credentials, so the origin is echoed rather than *
+			w.Header().Add("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		case r.Method == http.MethodGet:
+			w.Header().Add("Vary", "Origin")
+			writePixel(w, pixelForbidden)
+			return
+		default:
+			w.Header().Add("Vary", "Origin")
+			WriteError(w, http.StatusForbidden, "origin not allowed", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandlePreflight handles OPTIONS /p.gif and /beacon
+// Answers CORS preflights, eg: for beacons sent as application/json.
+func (s *Server) HandlePreflight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandlePixel handles GET /p.gif
+// Queues the event described by the query and always serves a 1x1 GIF, so email clients never show a broken image.
+// The event is either base64 encoded JSON in d, or plain parameters: event_name, channel, campaign_id, user_id,
+// timestamp (defaults to now), comma separated tags and metadata as meta.<key>.
+func (s *Server) HandlePixel(w http.ResponseWriter, r *http.Request) {
+	writePixel(w, s.ingestPixel(r))
+}
+
+// writePixel serves the GIF with the outcome of the request in X-Ingest-Status.
+func writePixel(w http.ResponseWriter, status string) {
+	w.Header().Set("X-Ingest-Status", status)
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-store, max-age=0")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(pixelGIF)
+}
+
+func (s *Server) ingestPixel(r *http.Request) string {
+	query := r.URL.Query()
+	if !s.Beacon.verified(query) {
+		return pixelUnauthorized
+	}
+
+	e, err := pixelEvent(query)
+	if err != nil {
+		return pixelInvalid
+	}
+
+	keep, err := s.PrepareEvent(ingest.NewRequest(r), &e)
+	if errors.Is(err, ErrMissingFields) {
+		return pixelInvalid
+	}
+	if err != nil {
+		return pixelRejected
+	}
+	if !keep {
+		return pixelDropped
+	}
+	if !s.Enqueue(e) {
+		return pixelQueueFull
+	}
+	return pixelAccepted
+}
+
+// pixelEvent reads the event of a pixel request.
+func pixelEvent(query url.Values) (model.Event, error) {
+	var e model.Event
+	if d := query.Get("d"); d != "" {
+		data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(d, "="))
+		if err != nil {
+			return e, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields() // Strict decoding to catch unexpected fields
+		err = dec.Decode(&e)
+		return e, err
+	}
+
+	e.EventName = query.Get("event_name")
+	e.Channel = query.Get("channel")
+	e.CampaignID = query.Get("campaign_id")
+	e.UserID = query.Get("user_id")
+
+	// Pixels are built when the email is sent, the open happens when they are fetched
+	e.Timestamp = time.Now().Unix()
+	if ts := query.Get("timestamp"); ts != "" {
+		unix, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return e, err
+		}
+		e.Timestamp = unix
+	}
+
+	if tags := query.Get("tags"); tags != "" {
+		e.Tags = lists.Split(tags)
+	}
+	for key, values := range query {
+		if name, ok := strings.CutPrefix(key, "meta."); ok && name != "" {
+			if e.Metadata == nil {
+				e.Metadata = make(map[string]any)
+			}
+			e.Metadata[name] = values[0]
+		}
+	}
+	return e, nil
+}
+
+// HandleBeacon handles POST /beacon
+// Takes a JSON event or array of events sent as text/plain by navigator.sendBeacon, which can't set headers.
+// event_name, channel, campaign_id and user_id query parameters, typically signed, override the fields of every event.
+func (s *Server) HandleBeacon(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64*1024) // Browsers cap beacons to 64KB
+
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != "text/plain" && mediaType != "application/json") {
+			WriteError(w, http.StatusUnsupportedMediaType, "unsupported Content-Type (expected text/plain or application/json)", nil)
+			return
+		}
+	}
+
+	query := r.URL.Query()
+	if !s.Beacon.verified(query) {
+		WriteError(w, http.StatusUnauthorized, "invalid or expired signature", nil)
+		return
+	}
+
+	events, err := beaconEvents(r.Body)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid JSON payload", nil)
+		return
+	}
+
+	for i := range events {
+		for _, field := range beaconFields {
+			if value := query.Get(field); value != "" {
+				*eventField(&events[i], field) = value
+			}
+		}
+	}
+
+	dropped, batchErr := s.ingestBatch(ingest.NewRequest(r), events)
+	if batchErr != nil {
+		if batchErr.Status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		WriteError(w, batchErr.Status, batchErr.Msg, nil)
+		return
+	}
+
+	WriteSuccess(w, http.StatusAccepted, api.EventsBulkResponseDTO{
+		Accepted: len(events),
+		Dropped:  dropped,
+	})
+}
+
+// beaconEvents decodes a single event or an array of events.
+func beaconEvents(body io.Reader) ([]model.Event, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errors.New("empty body")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields() // Strict decoding to catch unexpected fields
+
+	if data[0] == '[' {
+		var events []model.Event
+		err := dec.Decode(&events)
+		return events, err
+	}
+
+	var e model.Event
+	if err := dec.Decode(&e); err != nil {
+		return nil, err
+	}
+	return []model.Event{e}, nil
+}
+
+func eventField(e *model.Event, field string) *string {
+	switch field {
+	case "event_name":
+		return &e.EventName
+	case "channel":
+		return &e.Channel
+	case "campaign_id":
+		return &e.CampaignID
+	}
+	return &e.UserID
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPixel(t *testing.T) {
+	server := NewServer(nil, 10)
+	router := NewRouter(server)
+
+	encoded, _ := json.Marshal(newEvent("user_2"))
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"plain parameters", "event_name=email_open&channel=email&campaign_id=cmp_1&user_id=user_1&tags=a,b&meta.template=welcome", pixelAccepted},
+		{"base64 event", "d=" + base64.RawURLEncoding.EncodeToString(encoded), pixelAccepted},
+		{"missing user", "event_name=email_open&channel=email", pixelInvalid},
+		{"invalid base64", "d=%%%", pixelInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/p.gif?"+tt.query, nil))
+
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/gif" || !bytes.Equal(rec.Body.Bytes(), pixelGIF) {
+				t.Fatalf("expected the pixel, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+			}
+			if got := rec.Header().Get("X-Ingest-Status"); got != tt.want {
+				t.Errorf("got status %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	e := <-server.Queue
+	if e.EventName != "email_open" || e.CampaignID != "cmp_1" || len(e.Tags) != 2 || e.Metadata["template"] != "welcome" || e.Timestamp == 0 {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if e = <-server.Queue; e.UserID != "user_2" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestPixelSignature(t *testing.T) {
+	server := NewServer(nil, 10)
+	server.Beacon.SigningSecret = []byte("secret")
+	router := NewRouter(server)
+
+	query := url.Values{
+		"event_name":  {"email_open"},
+		"channel":     {"email"},
+		"campaign_id": {"cmp_1"},
+		"user_id":     {"user_1"},
+		"exp":         {strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)},
+	}
+	query.Set("sig", SignQuery(server.Beacon.SigningSecret, query))
+
+	forged := url.Values{}
+	for key, values := range query {
+		forged[key] = values
+	}
+	forged.Set("campaign_id", "cmp_2")
+
+	expired := url.Values{}
+	for key, values := range query {
+		expired[key] = values
+	}
+	expired.Set("exp", strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	expired.Set("sig", SignQuery(server.Beacon.SigningSecret, expired))
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  string
+	}{
+		{"signed", query, pixelAccepted},
+		{"forged campaign", forged, pixelUnauthorized},
+		{"expired", expired, pixelUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/p.gif?"+tt.query.Encode(), nil))
+			if got := rec.Header().Get("X-Ingest-Status"); got != tt.want {
+				t.Errorf("got status %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBeacon(t *testing.T) {
+	server := NewServer(nil, 10)
+	router := NewRouter(server)
+
+	single, _ := json.Marshal(newEvent("user_1"))
+	batch, _ := json.Marshal([]any{newEvent("user_2"), newEvent("user_3")})
+
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		body        []byte
+		want        int
+	}{
+		{"single event", "/beacon", "text/plain;charset=UTF-8", single, http.StatusAccepted},
+		{"batch", "/beacon", "application/json", batch, http.StatusAccepted},
+		{"pinned campaign", "/beacon?campaign_id=cmp_pinned", "text/plain", single, http.StatusAccepted},
+		{"invalid JSON", "/beacon", "text/plain", []byte("{"), http.StatusBadRequest},
+		{"unsupported type", "/beacon", "application/x-www-form-urlencoded", single, http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tt.path, bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, r)
+
+			if rec.Code != tt.want {
+				t.Errorf("got %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	if len(server.Queue) != 4 {
+		t.Fatalf("expected 4 queued events, got %d", len(server.Queue))
+	}
+	for range 3 {
+		<-server.Queue
+	}
+	if e := <-server.Queue; e.CampaignID != "cmp_pinned" {
+		t.Errorf("expected the campaign of the URL, got %q", e.CampaignID)
+	}
+}
+
+func TestBeaconOrigins(t *testing.T) {
+	server := NewServer(nil, 10)
+	server.Beacon.AllowedOrigins = []string{"https://app.example.com"}
+	router := NewRouter(server)
+
+	body, _ := json.Marshal(newEvent("user_1"))
+	tests := []struct {
+		name   string
+		method string
+		origin string
+		want   int
+	}{
+		{"allowed", "POST", "https://app.example.com", http.StatusAccepted},
+		{"not allowed", "POST", "https://evil.example.com", http.StatusForbidden},
+		{"no origin", "POST", "", http.StatusAccepted},
+		{"preflight", "OPTIONS", "https://app.example.com", http.StatusNoContent},
+		{"preflight not allowed", "OPTIONS", "https://evil.example.com", http.StatusForbidden},
+		{"pixel not allowed", "GET", "https://evil.example.com", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/beacon"
+			if tt.method == "GET" {
+				target = "/p.gif?event_name=email_open&channel=email&user_id=user_1"
+			}
+			r := httptest.NewRequest(tt.method, target, strings.NewReader(string(body)))
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, r)
+
+			if rec.Code != tt.want {
+				t.Fatalf("got %d, want %d", rec.Code, tt.want)
+			}
+			if tt.method == "GET" {
+				if rec.Header().Get("X-Ingest-Status") != pixelForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+					t.Errorf("expected the pixel without its event, got %v", rec.Header())
+				}
+				return
+			}
+			if rec.Code < 400 && tt.origin != "" && rec.Header().Get("Access-Control-Allow-Origin") != tt.origin {
+				t.Errorf("expected the origin to be allowed, got %q", rec.Header().Get("Access-Control-Allow-Origin"))
+			}
+		})
+	}
+	if len(server.Queue) != 2 {
+		t.Errorf("expected 2 queued events, got %d", len(server.Queue))
+	}
+
+	t.Run("any origin without an allow list", func(t *testing.T) {
+		server.Beacon.AllowedOrigins = nil
+		r := httptest.NewRequest("POST", "/beacon", strings.NewReader(string(body)))
+		r.Header.Set("Origin", "https://evil.example.com")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, r)
+
+		if rec.Code != http.StatusAccepted || rec.Header().Get("Access-Control-Allow-Origin") != "*" || rec.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf("expected * without credentials, got %d %v", rec.Code, rec.Header())
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fast-ingest/internal/ingestpb"
+	"fast-ingest/internal/model"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Payload formats accepted by the ingest endpoints, picked by Content-Type.
+const (
+	payloadJSON     = "JSON"
+	payloadProtobuf = "protobuf"
+	payloadMsgPack  = "msgpack"
+)
+
+// errUnsupportedPayload is returned for a Content-Type no decoder handles.
+var errUnsupportedPayload = errors.New("unsupported Content-Type (expected application/json, application/x-protobuf or application/msgpack)")
+
+// payloadFormat maps the Content-Type of r onto a payload format. JSON is the default.
+func payloadFormat(r *http.Request) (string, error) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		return payloadJSON, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", errUnsupportedPayload
+	}
+	switch mediaType {
+	case "application/json":
+		return payloadJSON, nil
+	case "application/x-protobuf", "application/protobuf":
+		return payloadProtobuf, nil
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		return payloadMsgPack, nil
+	}
+	return "", errUnsupportedPayload
+}
+
+// decodeEvent decodes a single event: a JSON or MessagePack object, or an ingestpb.Event.
+func decodeEvent(format string, body io.Reader) (model.Event, error) {
+	var e model.Event
+	switch format {
+	case payloadProtobuf:
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return model.Event{}, err
+		}
+		var event ingestpb.Event
+		if err := proto.Unmarshal(data, &event); err != nil {
+			return model.Event{}, err
+		}
+		return event.ToModel(), nil
+
+	case payloadMsgPack:
+		if err := newMsgPackDecoder(body).Decode(&e); err != nil {
+			return model.Event{}, err
+		}
+		e.Metadata = normalizeMsgPackMap(e.Metadata)
+		return e, nil
+	}
+
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields() // Strict decoding to catch unexpected fields
+	err := dec.Decode(&e)
+	return e, err
+}
+
+// decodeEvents decodes a batch of events: a JSON or MessagePack array, or an ingestpb.IngestBatchRequest.
+func decodeEvents(format string, body io.Reader) ([]model.Event, error) {
+	var events []model.Event
+	switch format {
+	case payloadProtobuf:
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		var batch ingestpb.IngestBatchRequest
+		if err := proto.Unmarshal(data, &batch); err != nil {
+			return nil, err
+		}
+		events = make([]model.Event, 0, len(batch.GetEvents()))
+		for _, event := range batch.GetEvents() {
+			events = append(events, event.ToModel())
+		}
+		return events, nil
+
+	case payloadMsgPack:
+		if err := newMsgPackDecoder(body).Decode(&events); err != nil {
+			return nil, err
+		}
+		for i := range events {
+			events[i].Metadata = normalizeMsgPackMap(events[i].Metadata)
+		}
+		return events, nil
+	}
+
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields() // Strict decoding to catch unexpected fields
+	err := dec.Decode(&events)
+	return events, err
+}
+
+// newMsgPackDecoder decodes MessagePack as strictly as the JSON decoder, using the json tags of model.Event.
+func newMsgPackDecoder(body io.Reader) *msgpack.Decoder {
+	dec := msgpack.NewDecoder(body)
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(true)
+	dec.UseLooseInterfaceDecoding(true)
+	return dec
+}
+
+// normalizeMsgPackMap converts MessagePack integers in metadata to float64, so rules and storage see the same
+// values as for a JSON payload.
+func normalizeMsgPackMap(m map[string]any) map[string]any {
+	for key, value := range m {
+		m[key] = normalizeMsgPackValue(value)
+	}
+	return m
+}
+
+func normalizeMsgPackValue(value any) any {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case map[string]any:
+		return normalizeMsgPackMap(v)
+	case []any:
+		for i := range v {
+			v[i] = normalizeMsgPackValue(v[i])
+		}
+		return v
+	}
+	return value
+}
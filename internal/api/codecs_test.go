@@ -0,0 +1,197 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"fast-ingest/internal/ingestpb"
+	"fast-ingest/internal/model"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// codecEvent returns an event setting every field the codecs map, nested metadata included.
+func codecEvent(userID string) model.Event {
+	return model.Event{
+		EventName:  "purchase",
+		Channel:    "web",
+		CampaignID: "cmp_1",
+		UserID:     userID,
+		Timestamp:  1769904000,
+		Tags:       []string{"promo"},
+		Metadata:   map[string]any{"plan": "pro", "seats": float64(3), "cart": map[string]any{"items": []any{float64(1), "sku_2"}}},
+	}
+}
+
+func encodeEvents(t testing.TB, format string, events []model.Event) []byte {
+	t.Helper()
+
+	switch format {
+	case payloadProtobuf:
+		batch := &ingestpb.IngestBatchRequest{}
+		for _, e := range events {
+			metadata, err := structpb.NewStruct(e.Metadata)
+			if err != nil {
+				t.Fatal(err)
+			}
+			batch.Events = append(batch.Events, &ingestpb.Event{
+				EventName:  e.EventName,
+				Channel:    e.Channel,
+				CampaignId: e.CampaignID,
+				UserId:     e.UserID,
+				Timestamp:  e.Timestamp,
+				Tags:       e.Tags,
+				Metadata:   metadata,
+			})
+		}
+		data, err := proto.Marshal(batch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+
+	case payloadMsgPack:
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		if err := enc.Encode(events); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	data, err := json.Marshal(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestPayloadFormat(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        string
+		wantErr     bool
+	}{
+		{"", payloadJSON, false},
+		{"application/json; charset=utf-8", payloadJSON, false},
+		{"application/x-protobuf", payloadProtobuf, false},
+		{"application/msgpack", payloadMsgPack, false},
+		{"application/x-msgpack", payloadMsgPack, false},
+		{"text/plain", "", true},
+		{"not a media type;", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/events", nil)
+			r.Header.Set("Content-Type", tt.contentType)
+
+			got, err := payloadFormat(r)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("got %q, %v, want %q (error: %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeEvents(t *testing.T) {
+	want := []model.Event{codecEvent("user_1"), codecEvent("user_2")}
+
+	for _, format := range []string{payloadJSON, payloadProtobuf, payloadMsgPack} {
+		t.Run(format, func(t *testing.T) {
+			got, err := decodeEvents(format, bytes.NewReader(encodeEvents(t, format, want)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeEvent(t *testing.T) {
+	want := codecEvent("user_1")
+
+	for _, format := range []string{payloadJSON, payloadProtobuf, payloadMsgPack} {
+		t.Run(format, func(t *testing.T) {
+			data := encodeEvents(t, format, []model.Event{want})
+			if format == payloadProtobuf {
+				var batch ingestpb.IngestBatchRequest
+				if err := proto.Unmarshal(data, &batch); err != nil {
+					t.Fatal(err)
+				}
+				var err error
+				if data, err = proto.Marshal(batch.Events[0]); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				// Unwrap the single event of the encoded array
+				var events []map[string]any
+				if format == payloadJSON {
+					_ = json.Unmarshal(data, &events)
+					data, _ = json.Marshal(events[0])
+				} else {
+					_ = msgpack.Unmarshal(data, &events)
+					data, _ = msgpack.Marshal(events[0])
+				}
+			}
+
+			got, err := decodeEvent(format, bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestDecodeMsgPackRejectsUnknownFields(t *testing.T) {
+	data, err := msgpack.Marshal(map[string]any{"event_name": "purchase", "unexpected": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := decodeEvent(payloadMsgPack, bytes.NewReader(data)); err == nil {
+		t.Error("expected unknown field to be rejected")
+	}
+}
+
+func TestDecodeInvalidPayload(t *testing.T) {
+	for _, format := range []string{payloadJSON, payloadProtobuf, payloadMsgPack} {
+		t.Run(format, func(t *testing.T) {
+			if _, err := decodeEvents(format, bytes.NewReader([]byte{0xff, 0xff, 0xff})); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func BenchmarkDecodeEvents(b *testing.B) {
+	events := make([]model.Event, 1000)
+	for i := range events {
+		events[i] = codecEvent(fmt.Sprintf("user_%d", i))
+	}
+
+	for _, format := range []string{payloadJSON, payloadProtobuf, payloadMsgPack} {
+		data := encodeEvents(b, format, events)
+		b.Run(format, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := decodeEvents(format, bytes.NewReader(data)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
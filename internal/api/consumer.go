@@ -0,0 +1,29 @@
+package api
+
+import (
+	"context"
+	"fast-ingest/internal/model"
+	"net/http"
+)
+
+// ConsumerLagReporter reports the consumer group lag of an ingest consumer, such as the Kafka consumer.
+type ConsumerLagReporter interface {
+	Lag(ctx context.Context) (model.ConsumerLag, error)
+}
+
+// HandleGetConsumerLag handles GET /admin/consumer/lag
+// Returns how many messages of each partition the Kafka consumer group hasn't committed yet.
+func (s *Server) HandleGetConsumerLag(w http.ResponseWriter, r *http.Request) {
+	if s.Consumer == nil {
+		WriteError(w, http.StatusServiceUnavailable, "kafka consumer disabled", nil)
+		return
+	}
+
+	lag, err := s.Consumer.Lag(r.Context())
+	if err != nil {
+		WriteError(w, http.StatusBadGateway, "failed to fetch consumer lag", err.Error())
+		return
+	}
+
+	WriteSuccess(w, http.StatusOK, lag)
+}
@@ -0,0 +1,16 @@
+package api
+
+type ErasureRequestDTO struct {
+	UserID string `json:"user_id"`
+
+	// Mode is delete or anonymize.
+	Mode string `json:"mode"`
+
+	// MetadataKeys are scrubbed on anonymize in addition to the configured ones.
+	MetadataKeys []string `json:"metadata_keys"`
+
+	RequestedBy string `json:"requested_by"`
+
+	// JobID links the audit log entry to the job that ran the erasure, empty for the CLI.
+	JobID string `json:"-"`
+}
@@ -1,9 +1,78 @@
 package api
 
+import "fast-ingest/internal/model"
+
 type EventResponseDTO struct {
 	Instant string `json:"instant"`
+
+	// Dropped is set when a transformation rule dropped the event or it was sampled out.
+	Dropped bool `json:"dropped,omitempty"`
 }
 
 type EventsBulkResponseDTO struct {
 	Accepted int `json:"accepted"`
+
+	// Dropped counts the accepted events that transformation rules dropped or sampling left out.
+	Dropped int `json:"dropped,omitempty"`
+}
+
+type TransformDryRunResponseDTO struct {
+	// Event is the event as it would be queued, or as it was when a rule dropped it.
+	Event model.Event `json:"event"`
+
+	// Dropped is the name of the rule that would drop the event.
+	Dropped string `json:"dropped,omitempty"`
+
+	// Applied are the names of the rules that matched, in order.
+	Applied []string `json:"applied"`
+}
+
+// EventFilterDTO holds the event filters shared by the search and the export of events.
+type EventFilterDTO struct {
+	Channel    string `json:"channel"`
+	CampaignID string `json:"campaign_id"`
+
+	// Tags must all be present on an event.
+	Tags []string `json:"tags"`
+	// Metadata holds key/value pairs compared against the text value of each metadata key.
+	Metadata map[string]string `json:"metadata"`
+}
+
+type EventSearchRequestDTO struct {
+	EventName string `json:"event_name"`
+	UserID    string `json:"user_id"`
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+	EventFilterDTO
+
+	// Fields to return, every field when empty.
+	Fields   []string `json:"fields"`
+	PageSize int      `json:"page_size"`
+	Cursor   string   `json:"cursor"`
+}
+
+type EventSearchResponseDTO struct {
+	Events     []model.StoredEvent `json:"events"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+type EventLookupResponseDTO struct {
+	Event model.StoredEvent `json:"event"`
+}
+
+type ExportRequestDTO struct {
+	// EventNames narrows the export down, every event is exported when empty.
+	EventNames []string `json:"event_names"`
+	From       int64    `json:"from"`
+	To         int64    `json:"to"`
+	EventFilterDTO
+	Format string `json:"format"`
+	Gzip   bool   `json:"gzip"`
+}
+
+type ExportResultDTO struct {
+	Format string `json:"format"`
+	Rows   int64  `json:"rows"`
+	Bytes  int64  `json:"bytes"`
+	File   string `json:"file"`
 }
@@ -1,14 +1,60 @@
 package api
 
-import "fast-ingest/internal/model"
+import (
+	"time"
+
+	"fast-ingest/internal/model"
+)
 
 type MetricsRequestDTO struct {
-	EventName string `json:"event_name"`
-	From      int64  `json:"from"`
-	To        int64  `json:"to"`
-	GroupBy   string `json:"group_by"`
+	EventNames []string `json:"event_names"`
+	From       int64    `json:"from"`
+	To         int64    `json:"to"`
+	GroupBy    string   `json:"group_by"`
+	Timezone   string   `json:"tz"`
+	GapFill    bool     `json:"gap_fill"`
+
+	// Limit keeps the top groups of a dimension breakdown ranked by OrderBy (count or unique_users)
+	// and collapses the rest into an __other__ row.
+	Limit   int    `json:"limit"`
+	OrderBy string `json:"order_by"`
+
+	// TopDimensions, when set, replaces the top groups picked by Limit: the breakdown keeps these values and
+	// collapses the rest into the __other__ row, so the events of a formula are broken down alike.
+	TopDimensions []string `json:"-"`
+
+	// Compare is previous_period, previous_year or custom (with CompareFrom and CompareTo).
+	Compare     string `json:"compare"`
+	CompareFrom int64  `json:"compare_from"`
+	CompareTo   int64  `json:"compare_to"`
+
+	// Formulas are evaluated per bucket over the metrics of EventNames.
+	Formulas []FormulaDTO `json:"formulas"`
+
+	// PageSize and Cursor page through the breakdown, Cursor is the opaque next_cursor of the previous page.
+	// They aren't part of the query a cursor is issued for.
+	PageSize int    `json:"-"`
+	Cursor   string `json:"-"`
+}
+
+type FormulaDTO struct {
+	Name       string `json:"name"`
+	Expression string `json:"expression"`
 }
 
 type MetricsResponseDTO struct {
 	Metrics model.Metrics `json:"metrics"`
 }
+
+type SessionMetricsRequestDTO struct {
+	EventName  string        `json:"event_name"`
+	From       int64         `json:"from"`
+	To         int64         `json:"to"`
+	GroupBy    string        `json:"group_by"`
+	SessionGap time.Duration `json:"session_gap"`
+	Timezone   string        `json:"tz"`
+}
+
+type SessionMetricsResponseDTO struct {
+	Sessions model.SessionMetrics `json:"sessions"`
+}
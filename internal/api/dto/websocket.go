@@ -0,0 +1,39 @@
+package api
+
+import "fast-ingest/internal/model"
+
+type WebSocketMessageDTO struct {
+	// Seq is chosen by the client and echoed in the reply to the message.
+	Seq int64 `json:"seq"`
+
+	// Exactly one of Event and Events is set.
+	Event  *model.Event  `json:"event,omitempty"`
+	Events []model.Event `json:"events,omitempty"`
+}
+
+type WebSocketAckDTO struct {
+	Type     string `json:"type"`
+	Seq      int64  `json:"seq"`
+	Accepted int    `json:"accepted"`
+
+	// Dropped counts the accepted events that transformation rules dropped or sampling left out.
+	Dropped int `json:"dropped,omitempty"`
+}
+
+type WebSocketErrorDTO struct {
+	Type  string `json:"type"`
+	Seq   int64  `json:"seq"`
+	Error string `json:"error"`
+
+	// RetryAfterMs is set when the queue was full, the message can be sent again after it.
+	RetryAfterMs int64 `json:"retry_after_ms,omitempty"`
+}
+
+type WebSocketBackpressureDTO struct {
+	Type string `json:"type"`
+
+	// Paused asks the client to stop sending until a message with Paused false arrives.
+	Paused        bool `json:"paused"`
+	QueueLength   int  `json:"queue_length"`
+	QueueCapacity int  `json:"queue_capacity"`
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/storage"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// erasureJobKind identifies erasure jobs in the job registry.
+const erasureJobKind = "erasure"
+
+// RequireAdmin only lets requests through that carry the admin token as a bearer token.
+// The admin API is disabled while no token is configured.
+func (s *Server) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.AdminToken == "" {
+			WriteError(w, http.StatusServiceUnavailable, "admin API disabled", nil)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+			WriteError(w, http.StatusUnauthorized, "unauthorized", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandleEraseUser handles POST /admin/erasures
+// Starts a job deleting or anonymizing every event of a user.
+func (s *Server) HandleEraseUser(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024) // Limit request body to 1MB
+
+	var erasureDTO api.ErasureRequestDTO
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&erasureDTO); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid JSON payload", nil)
+		return
+	}
+
+	if erasureDTO.UserID == "" {
+		WriteError(w, http.StatusBadRequest, "user_id is required", nil)
+		return
+	}
+	if erasureDTO.Mode != storage.ErasureDelete && erasureDTO.Mode != storage.ErasureAnonymize {
+		WriteError(w, http.StatusBadRequest, "invalid mode (expected delete or anonymize)", nil)
+		return
+	}
+	if erasureDTO.Mode == storage.ErasureDelete && len(erasureDTO.MetadataKeys) > 0 {
+		WriteError(w, http.StatusBadRequest, "metadata_keys only apply to anonymize", nil)
+		return
+	}
+
+	job := s.Jobs.Start(erasureJobKind, func(ctx context.Context, id string) (any, error) {
+		erasureDTO.JobID = id
+		return s.Store.EraseUser(ctx, erasureDTO)
+	})
+	WriteSuccess(w, http.StatusAccepted, job)
+}
+
+// HandleGetErasure handles GET /admin/erasures/{id}
+// Returns the status of an erasure job.
+func (s *Server) HandleGetErasure(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.Jobs.Get(erasureJobKind, chi.URLParam(r, "id"))
+	if !ok {
+		WriteError(w, http.StatusNotFound, "erasure not found", nil)
+		return
+	}
+
+	WriteSuccess(w, http.StatusOK, job)
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/lists"
+	"fast-ingest/internal/storage"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Page sizes of GET /events.
+const (
+	defaultEventsPageSize = 100
+	maxEventsPageSize     = 1000
+)
+
+// HandleSearchEvents handles GET /events
+// Returns stored events matching the filters, newest first, one page at a time.
+func (s *Server) HandleSearchEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	searchDTO := api.EventSearchRequestDTO{
+		EventName: query.Get("event_name"),
+		UserID:    query.Get("user_id"),
+		Cursor:    query.Get("cursor"),
+		PageSize:  defaultEventsPageSize,
+	}
+
+	var ok bool
+	if searchDTO.EventFilterDTO, ok = parseEventFilters(w, r); !ok {
+		return
+	}
+
+	var err error
+	for _, param := range []struct {
+		name   string
+		target *int64
+	}{
+		{"from", &searchDTO.From},
+		{"to", &searchDTO.To},
+	} {
+		if value := query.Get(param.name); value != "" {
+			if *param.target, err = strconv.ParseInt(value, 10, 64); err != nil {
+				WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s timestamp", param.name), nil)
+				return
+			}
+		}
+	}
+
+	// Without a user or a start time the search would scan the whole table
+	if searchDTO.UserID == "" && searchDTO.From == 0 {
+		WriteError(w, http.StatusBadRequest, "user_id or from query parameter is required", nil)
+		return
+	}
+
+	if searchDTO.To != 0 && storage.NormalizeTimestamp(searchDTO.From).After(storage.NormalizeTimestamp(searchDTO.To)) {
+		WriteError(w, http.StatusBadRequest, "from must be before to", nil)
+		return
+	}
+
+	// fields=event_name,user_id,timestamp trims the response down to those fields
+	for _, field := range lists.Split(query["fields"]...) {
+		if !storage.IsEventField(field) {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("unknown field %q (expected one of %s)", field, strings.Join(storage.EventFields, ", ")), nil)
+			return
+		}
+		searchDTO.Fields = append(searchDTO.Fields, field)
+	}
+
+	if pageSizeStr := query.Get("page_size"); pageSizeStr != "" {
+		searchDTO.PageSize, err = strconv.Atoi(pageSizeStr)
+		if err != nil || searchDTO.PageSize < 1 || searchDTO.PageSize > maxEventsPageSize {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid page_size (must be between 1 and %d)", maxEventsPageSize), nil)
+			return
+		}
+	}
+
+	page, err := s.Store.SearchEvents(r.Context(), searchDTO)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		WriteError(w, http.StatusBadRequest, "invalid cursor", nil)
+		return
+	}
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to search events", nil)
+		return
+	}
+
+	WriteSuccess(w, http.StatusOK, api.EventSearchResponseDTO{
+		Events:     page.Events,
+		NextCursor: page.NextCursor,
+	})
+}
+
+// HandleGetEvent handles GET /events/{dedupe_key}
+// Returns a single stored event.
+func (s *Server) HandleGetEvent(w http.ResponseWriter, r *http.Request) {
+	event, err := s.Store.GetEvent(r.Context(), chi.URLParam(r, "dedupe_key"))
+	if errors.Is(err, storage.ErrEventNotFound) {
+		WriteError(w, http.StatusNotFound, "event not found", nil)
+		return
+	}
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to retrieve event", nil)
+		return
+	}
+
+	WriteSuccess(w, http.StatusOK, api.EventLookupResponseDTO{
+		Event: event,
+	})
+}
+
+// parseEventFilters parses the channel, campaign_id, tag and metadata.<key> filters of GET /events and GET /exports/events.
+func parseEventFilters(w http.ResponseWriter, r *http.Request) (api.EventFilterDTO, bool) {
+	query := r.URL.Query()
+
+	filters := api.EventFilterDTO{
+		Channel:    query.Get("channel"),
+		CampaignID: query.Get("campaign_id"),
+		Tags:       lists.Split(query["tag"]...),
+	}
+
+	// metadata.<key>=value matches the text value of a metadata key, eg: metadata.plan=pro
+	for param, values := range query {
+		key, ok := strings.CutPrefix(param, "metadata.")
+		if !ok {
+			continue
+		}
+		if key == "" || len(values) != 1 {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s filter", param), nil)
+			return api.EventFilterDTO{}, false
+		}
+		if filters.Metadata == nil {
+			filters.Metadata = make(map[string]string)
+		}
+		filters.Metadata[key] = values[0]
+	}
+
+	return filters, true
+}
@@ -0,0 +1,241 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/export"
+	"fast-ingest/internal/jobs"
+	"fast-ingest/internal/model"
+	"fast-ingest/internal/storage"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// exportJobKind identifies export jobs in the job registry.
+	exportJobKind = "export"
+
+	// maxSyncExportRange is the longest range streamed in the response, larger ranges must use async=true.
+	maxSyncExportRange = 7 * 24 * time.Hour
+
+	// exportBufferSize is the write buffer between the encoder and the response or file.
+	exportBufferSize = 64 * 1024
+)
+
+// HandleExportEvents handles GET /exports/events
+// Streams raw events of a range as NDJSON, CSV or Parquet, or starts an export job with async=true.
+func (s *Server) HandleExportEvents(w http.ResponseWriter, r *http.Request) {
+	// Exports read raw events, like sessions they are limited to the raw window
+	from, to, ok := parseMetricsRange(w, r, s.TierLimits.Raw)
+	if !ok {
+		return
+	}
+
+	exportDTO := api.ExportRequestDTO{
+		EventNames: parseEventNames(r),
+		From:       from,
+		To:         to,
+	}
+	if exportDTO.EventFilterDTO, ok = parseEventFilters(w, r); !ok {
+		return
+	}
+
+	// format takes precedence over the Accept header
+	exportDTO.Format = r.URL.Query().Get("format")
+	if exportDTO.Format == "" {
+		exportDTO.Format, ok = export.FormatFromAccept(r.Header.Get("Accept"))
+		if !ok {
+			WriteError(w, http.StatusNotAcceptable, "unsupported Accept header (expected application/x-ndjson, text/csv or application/vnd.apache.parquet)", nil)
+			return
+		}
+	}
+	if !export.IsFormat(exportDTO.Format) {
+		WriteError(w, http.StatusBadRequest, "invalid format (expected ndjson, csv or parquet)", nil)
+		return
+	}
+
+	// gzip=true, or a client accepting gzip, compresses text formats
+	if gzipStr := r.URL.Query().Get("gzip"); gzipStr != "" {
+		var err error
+		exportDTO.Gzip, err = strconv.ParseBool(gzipStr)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid gzip value", nil)
+			return
+		}
+	} else {
+		exportDTO.Gzip = strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+	}
+	exportDTO.Gzip = exportDTO.Gzip && export.Compressible(exportDTO.Format)
+
+	async := false
+	if asyncStr := r.URL.Query().Get("async"); asyncStr != "" {
+		var err error
+		async, err = strconv.ParseBool(asyncStr)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid async value", nil)
+			return
+		}
+	}
+
+	if async {
+		job := s.Jobs.Start(exportJobKind, func(ctx context.Context, id string) (any, error) {
+			return s.exportToFile(ctx, id, exportDTO)
+		})
+		WriteSuccess(w, http.StatusAccepted, job)
+		return
+	}
+
+	if storage.NormalizeTimestamp(to).Sub(storage.NormalizeTimestamp(from)) > maxSyncExportRange {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("range too large to stream (max %d days), use async=true", int(maxSyncExportRange.Hours()/24)), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(exportDTO.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFileName(exportDTO, "events")))
+	if exportDTO.Gzip {
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := s.writeExport(r.Context(), w, exportDTO); err != nil {
+		// The status is already sent, abort the connection so the client sees a truncated export rather than a complete one
+		log.Printf("Export failed: %v", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// HandleGetExport handles GET /exports/{id}
+// Returns the status of an export job.
+func (s *Server) HandleGetExport(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.Jobs.Get(exportJobKind, chi.URLParam(r, "id"))
+	if !ok {
+		WriteError(w, http.StatusNotFound, "export not found", nil)
+		return
+	}
+
+	WriteSuccess(w, http.StatusOK, job)
+}
+
+// HandleDownloadExport handles GET /exports/{id}/download
+// Serves the file written by a finished export job.
+func (s *Server) HandleDownloadExport(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.Jobs.Get(exportJobKind, chi.URLParam(r, "id"))
+	if !ok {
+		WriteError(w, http.StatusNotFound, "export not found", nil)
+		return
+	}
+
+	result, ok := job.Result.(api.ExportResultDTO)
+	if job.Status != jobs.StatusDone || !ok {
+		WriteError(w, http.StatusConflict, fmt.Sprintf("export is %s", job.Status), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(result.Format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(result.File)))
+	http.ServeFile(w, r, result.File)
+}
+
+// NewJobs returns the job registry of a server, running jobs with ctx. Export files go away with their jobs.
+func NewJobs(ctx context.Context) *jobs.Registry {
+	registry := jobs.NewRegistry(ctx)
+	registry.OnPrune(exportJobKind, removeExport)
+	return registry
+}
+
+// removeExport deletes the file of an export job dropped from the registry.
+func removeExport(job jobs.Job) {
+	result, ok := job.Result.(api.ExportResultDTO)
+	if !ok {
+		return
+	}
+	if err := os.Remove(result.File); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Failed to remove export %s: %v", result.File, err)
+	}
+}
+
+// exportToFile writes an export into the export directory, through a temporary file so a partial export is never served.
+func (s *Server) exportToFile(ctx context.Context, id string, exportDTO api.ExportRequestDTO) (api.ExportResultDTO, error) {
+	if err := os.MkdirAll(s.ExportDir, 0o755); err != nil {
+		return api.ExportResultDTO{}, err
+	}
+
+	path := filepath.Join(s.ExportDir, exportFileName(exportDTO, id))
+	f, err := os.CreateTemp(s.ExportDir, id+".*.tmp")
+	if err != nil {
+		return api.ExportResultDTO{}, err
+	}
+	defer os.Remove(f.Name())
+
+	rows, err := s.writeExport(ctx, f, exportDTO)
+	if err != nil {
+		f.Close()
+		return api.ExportResultDTO{}, err
+	}
+	if err := f.Close(); err != nil {
+		return api.ExportResultDTO{}, err
+	}
+
+	info, err := os.Stat(f.Name())
+	if err != nil {
+		return api.ExportResultDTO{}, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return api.ExportResultDTO{}, err
+	}
+
+	return api.ExportResultDTO{
+		Format: exportDTO.Format,
+		Rows:   rows,
+		Bytes:  info.Size(),
+		File:   path,
+	}, nil
+}
+
+// writeExport encodes the events of the export into out and returns how many were written.
+func (s *Server) writeExport(ctx context.Context, out io.Writer, exportDTO api.ExportRequestDTO) (int64, error) {
+	buf := bufio.NewWriterSize(out, exportBufferSize)
+
+	var dst io.Writer = buf
+	var gz *gzip.Writer
+	if exportDTO.Gzip {
+		gz = gzip.NewWriter(buf)
+		dst = gz
+	}
+
+	enc := export.NewWriter(exportDTO.Format, dst)
+	var rows int64
+	err := s.Store.ExportEvents(ctx, exportDTO, func(e model.StoredEvent) error {
+		rows++
+		return enc.Write(e)
+	})
+
+	err = errors.Join(err, enc.Close())
+	if gz != nil {
+		err = errors.Join(err, gz.Close())
+	}
+	err = errors.Join(err, buf.Flush())
+
+	return rows, err
+}
+
+// exportFileName names an export after its range and format, eg: events-1769904000-1769990400.csv.gz
+func exportFileName(exportDTO api.ExportRequestDTO, prefix string) string {
+	name := fmt.Sprintf("%s-%d-%d.%s", prefix, exportDTO.From, exportDTO.To, exportDTO.Format)
+	if exportDTO.Gzip {
+		name += ".gz"
+	}
+	return name
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/jobs"
+	"fast-ingest/internal/model"
+	"fast-ingest/internal/storage"
+)
+
+// exportStore records the export request and exports no event.
+type exportStore struct {
+	storage.Store
+	got api.ExportRequestDTO
+}
+
+func (s *exportStore) ExportEvents(_ context.Context, exportDTO api.ExportRequestDTO, _ func(model.StoredEvent) error) error {
+	s.got = exportDTO
+	return nil
+}
+
+func TestExportEventsFilters(t *testing.T) {
+	store := &exportStore{}
+	router := NewRouter(NewServer(store, 10))
+
+	from := time.Now().Add(-24 * time.Hour).Unix()
+	target := fmt.Sprintf("/exports/events?from=%d&channel=email&campaign_id=spring&tag=vip,beta&metadata.plan=pro", from)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body.String())
+	}
+	got := store.got.EventFilterDTO
+	if got.Channel != "email" || got.CampaignID != "spring" || !slices.Equal(got.Tags, []string{"vip", "beta"}) || got.Metadata["plan"] != "pro" {
+		t.Errorf("unexpected filters %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", fmt.Sprintf("/exports/events?from=%d&metadata.=pro", from), nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an empty metadata key to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestRemoveExport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.csv")
+	if err := os.WriteFile(path, []byte("ts\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	removeExport(jobs.Job{Kind: exportJobKind, Result: api.ExportResultDTO{File: path}})
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the export file to be removed, got %v", err)
+	}
+
+	// A failed job has no file
+	removeExport(jobs.Job{Kind: exportJobKind})
+}
@@ -1,13 +1,27 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/formula"
+	"fast-ingest/internal/ingest"
+	"fast-ingest/internal/jobs"
+	"fast-ingest/internal/lists"
 	"fast-ingest/internal/model"
+	"fast-ingest/internal/otlp"
+	"fast-ingest/internal/redact"
+	"fast-ingest/internal/sampling"
 	"fast-ingest/internal/storage"
+	"fast-ingest/internal/tail"
+	"fast-ingest/internal/transform"
 	"fmt"
 	"net/http"
+	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -43,12 +57,88 @@ func WriteSuccess(w http.ResponseWriter, status int, data any) {
 type Server struct {
 	Store storage.Store
 	Queue chan model.Event
+
+	// SessionGap is the default inactivity gap used to split a user's events into sessions.
+	SessionGap time.Duration
+
+	// TierLimits caps how far back metrics can be queried at each resolution.
+	TierLimits storage.TierLimits
+
+	// Jobs runs and tracks background work such as async exports.
+	Jobs *jobs.Registry
+
+	// ExportDir is where async exports are written.
+	ExportDir string
+
+	// AdminToken is the bearer token of the admin API, which is disabled while empty.
+	AdminToken string
+
+	// Transformer rewrites events before they are queued, nil disables transformations.
+	Transformer *transform.Transformer
+
+	// Sampler keeps a share of high-volume events, nil keeps every event.
+	Sampler *sampling.Sampler
+
+	// Pipeline enriches events before they are queued, nil disables enrichment.
+	Pipeline *ingest.Pipeline
+
+	// Redactor scrubs PII from events before they are queued, nil disables redaction.
+	Redactor *redact.Redactor
+
+	// Beacon configures the allowed origins and URL signing of the pixel and beacon endpoints.
+	Beacon BeaconConfig
+
+	// Consumer reports the lag of the Kafka consumer, nil while it is disabled.
+	Consumer ConsumerLagReporter
+
+	// Sinks reports the delivery status of the destinations stored events are published to, nil while there are none.
+	Sinks SinkStatusReporter
+
+	// Tail broadcasts stored events to the live tail, the writer must publish to it.
+	Tail *tail.Hub
+
+	// OTLP names the log record attributes mapped onto events by the OTLP logs receiver.
+	OTLP otlp.Config
+
+	// WebSocket configures authentication, allowed origins and idle timeout of WebSocket ingest.
+	WebSocket WebSocketConfig
+}
+
+// DefaultSessionGap is the inactivity gap used when SESSION_GAP is not set.
+const DefaultSessionGap = 30 * time.Minute
+
+// SessionGapFromEnv reads SESSION_GAP, eg: 15m, and defaults to DefaultSessionGap.
+func SessionGapFromEnv() (time.Duration, error) {
+	gapStr := os.Getenv("SESSION_GAP")
+	if gapStr == "" {
+		return DefaultSessionGap, nil
+	}
+	return parseSessionGap(gapStr)
+}
+
+// parseSessionGap parses an inactivity gap, it must be between 1m and 24h.
+func parseSessionGap(s string) (time.Duration, error) {
+	gap, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if gap < time.Minute || gap > 24*time.Hour {
+		return 0, fmt.Errorf("session gap %s is not between 1m and 24h", gap)
+	}
+	return gap, nil
 }
 
 func NewServer(store storage.Store, queueSize int) *Server {
 	return &Server{
-		Store: store,
-		Queue: make(chan model.Event, queueSize),
+		Store:      store,
+		Queue:      make(chan model.Event, queueSize),
+		SessionGap: DefaultSessionGap,
+		TierLimits: storage.DefaultTierLimits,
+		Jobs:       NewJobs(context.Background()),
+		ExportDir:  "exports",
+		OTLP:       otlp.DefaultConfig,
+		WebSocket:  DefaultWebSocketConfig,
+		Tail:       tail.NewHub(tail.DefaultBufferSize, tail.DefaultMaxSubscribers),
 	}
 }
 
@@ -75,18 +165,34 @@ func (s *Server) HandleHealthCheck(w http.ResponseWriter, r *http.Request) {
 func (s *Server) HandleIngestEvent(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, 20*1024*1024) // Limit request body to 20MB
 
-	var e model.Event
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields() // Strict decoding to catch unexpected fields
+	format, err := payloadFormat(r)
+	if err != nil {
+		WriteError(w, http.StatusUnsupportedMediaType, err.Error(), nil)
+		return
+	}
 
-	if err := dec.Decode(&e); err != nil {
-		WriteError(w, http.StatusBadRequest, "invalid JSON payload", nil)
+	e, err := decodeEvent(format, r.Body)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s payload", format), nil)
 		return
 	}
 
-	// Validate required fields
-	if e.EventName == "" || e.Channel == "" || e.UserID == "" || e.Timestamp == 0 {
-		WriteError(w, http.StatusBadRequest, "missing required fields", nil)
+	// Required fields are validated once transformed
+	keep, err := s.PrepareEvent(ingest.NewRequest(r), &e)
+	if errors.Is(err, ErrMissingFields) {
+		WriteError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		WriteError(w, http.StatusUnprocessableEntity, err.Error(), nil)
+		return
+	}
+	if !keep {
+		// Dropped by a transformation rule or sampled out, on purpose, so the client must not retry
+		WriteSuccess(w, http.StatusAccepted, api.EventResponseDTO{
+			Instant: time.Now().UTC().Format(time.RFC3339),
+			Dropped: true,
+		})
 		return
 	}
 
@@ -107,67 +213,228 @@ func (s *Server) HandleIngestEvent(w http.ResponseWriter, r *http.Request) {
 func (s *Server) HandleBulkIngestEvents(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, 20*1024*1024) // Limit request body to 20MB
 
-	var events []model.Event
-	dec := json.NewDecoder(r.Body)
-	dec.DisallowUnknownFields() // Strict decoding to catch unexpected fields
+	format, err := payloadFormat(r)
+	if err != nil {
+		WriteError(w, http.StatusUnsupportedMediaType, err.Error(), nil)
+		return
+	}
+
+	events, err := decodeEvents(format, r.Body)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s payload", format), nil)
+		return
+	}
+
+	dropped, batchErr := s.ingestBatch(ingest.NewRequest(r), events)
+	if batchErr != nil {
+		if batchErr.Status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		WriteError(w, batchErr.Status, batchErr.Msg, nil)
+		return
+	}
+
+	WriteSuccess(w, http.StatusAccepted, api.EventsBulkResponseDTO{
+		Accepted: len(events),
+		Dropped:  dropped,
+	})
+}
+
+// HandleGetMetrics handles GET /metrics
+// Returns aggregated metric data over a time range.
+func (s *Server) HandleGetMetrics(w http.ResponseWriter, r *http.Request) {
+	// Older ranges are served from daily rollups, so metrics can reach back to the daily limit
+	from, to, ok := parseMetricsRange(w, r, s.TierLimits.Daily)
+	if !ok {
+		return
+	}
+
+	var metricsDTO api.MetricsRequestDTO
+
+	// Get query parameters
+	metricsDTO.EventNames = parseEventNames(r)
+	metricsDTO.GroupBy = r.URL.Query().Get("group_by")
+	metricsDTO.From = from
+	metricsDTO.To = to
+
+	metricsDTO.Timezone, ok = parseTimezone(w, r)
+	if !ok {
+		return
+	}
 
-	if err := dec.Decode(&events); err != nil {
-		WriteError(w, http.StatusBadRequest, "invalid JSON payload", nil)
+	// Rollups are bucketed in UTC, so other timezones can only be served from raw events
+	if metricsDTO.Timezone != "UTC" && storage.NormalizeTimestamp(from).Before(time.Now().Add(-s.TierLimits.Raw)) {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("from must be within the last %d days when tz is not UTC", int(s.TierLimits.Raw.Hours()/24)), nil)
 		return
 	}
 
-	if len(events) == 0 {
-		WriteError(w, http.StatusBadRequest, "events is required", nil)
+	// So are groupings the rollups don't keep, eg: week, month or metadata.<key>
+	if !storage.IsRollupGroupBy(metricsDTO.GroupBy) && storage.NormalizeTimestamp(from).Before(time.Now().Add(-s.TierLimits.Raw)) {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("from must be within the last %d days for this group_by", int(s.TierLimits.Raw.Hours()/24)), nil)
 		return
 	}
 
-	if len(events) > 1000 {
-		WriteError(w, http.StatusBadRequest, "too many events (max 1000)", nil)
+	// Validate required fields
+	// A validation library could be used here for more complex validation rules
+	if len(metricsDTO.EventNames) == 0 {
+		WriteError(w, http.StatusBadRequest, "event_name is required", nil)
 		return
 	}
 
-	// Validate each event in the batch
-	for i := 0; i < len(events); i++ {
-		e := events[i]
-		// Validate required fields
-		if e.EventName == "" || e.Channel == "" || e.UserID == "" || e.Timestamp == 0 {
-			WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid event at index %d", i), nil)
+	// formula=name:expression, eg: formula=conversion_rate:purchase/page_view
+	for _, f := range r.URL.Query()["formula"] {
+		name, expression, found := strings.Cut(f, ":")
+		if !found || !formula.ValidName(name) {
+			WriteError(w, http.StatusBadRequest, "invalid formula (expected name:expression)", nil)
+			return
+		}
+
+		expr, err := formula.Parse(expression)
+		if err != nil {
+			// A + left unencoded in the query string is decoded as a space
+			hint := ""
+			if strings.Contains(r.URL.RawQuery, "+") {
+				hint = " (encode + as %2B)"
+			}
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid formula %s: %v%s", name, err, hint), nil)
 			return
 		}
+
+		// Events referenced by a formula are queried even if they weren't listed in event_name
+		for _, eventName := range expr.Events() {
+			if !slices.Contains(metricsDTO.EventNames, eventName) {
+				metricsDTO.EventNames = append(metricsDTO.EventNames, eventName)
+			}
+		}
+
+		metricsDTO.Formulas = append(metricsDTO.Formulas, api.FormulaDTO{Name: name, Expression: expression})
 	}
 
-	// Queue events for processing
-	for i := 0; i < len(events); i++ {
-		e := events[i]
-		select {
-		case s.Queue <- e:
-		default:
-			// queue full
-			w.Header().Set("Retry-After", "1")
-			WriteError(w, http.StatusTooManyRequests, "ingest queue full", nil)
+	if len(metricsDTO.EventNames) > maxMetricsEventNames {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("too many event names (max %d)", maxMetricsEventNames), nil)
+		return
+	}
+
+	if len(metricsDTO.Formulas) > maxMetricsFormulas {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("too many formulas (max %d)", maxMetricsFormulas), nil)
+		return
+	}
+
+	if !isValidGroupBy(metricsDTO.GroupBy) {
+		WriteError(w, http.StatusBadRequest, "invalid group_by value", nil)
+		return
+	}
+
+	// gap_fill=true returns zero rows for empty time buckets so the series is continuous
+	if gapFill := r.URL.Query().Get("gap_fill"); gapFill != "" {
+		var err error
+		metricsDTO.GapFill, err = strconv.ParseBool(gapFill)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid gap_fill value", nil)
 			return
 		}
 	}
 
-	WriteSuccess(w, http.StatusAccepted, api.EventsBulkResponseDTO{
-		Accepted: len(events),
+	if !isWithinBucketLimit(metricsDTO.GroupBy, from, to) {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("too many time buckets for this range (max %d)", storage.MaxTimeBuckets), nil)
+		return
+	}
+
+	// limit=N&order_by=count|unique_users keeps the top N groups of a dimension and collapses the rest into __other__
+	if !parseTopN(w, r, &metricsDTO) {
+		return
+	}
+
+	// compare=previous_period|previous_year|custom runs the same query over a comparison window
+	if !s.parseComparison(w, r, &metricsDTO) {
+		return
+	}
+
+	// page_size=N&cursor=... pages through the breakdown, the cursor only works for the query it was issued for
+	if !parsePagination(w, r, &metricsDTO) {
+		return
+	}
+
+	// Retrieve metrics from the store
+	metrics, err := s.Store.GetMetrics(r.Context(), metricsDTO)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to retrieve metrics", nil)
+		return
+	}
+
+	WriteSuccess(w, http.StatusOK, api.MetricsResponseDTO{
+		Metrics: metrics,
 	})
 }
 
-// HandleGetMetrics handles GET /metrics
-// Returns aggregated metric data over a time range.
-func (s *Server) HandleGetMetrics(w http.ResponseWriter, r *http.Request) {
+// HandleGetSessionMetrics handles GET /metrics/sessions
+// Reconstructs user sessions from raw events and returns session metrics over a time range.
+func (s *Server) HandleGetSessionMetrics(w http.ResponseWriter, r *http.Request) {
+	// Sessions are always rebuilt from raw events
+	from, to, ok := parseMetricsRange(w, r, s.TierLimits.Raw)
+	if !ok {
+		return
+	}
+
+	var sessionsDTO api.SessionMetricsRequestDTO
+
+	// event_name is optional here, sessions are built from every event unless narrowed down
+	sessionsDTO.EventName = r.URL.Query().Get("event_name")
+	sessionsDTO.GroupBy = r.URL.Query().Get("group_by")
+	sessionsDTO.From = from
+	sessionsDTO.To = to
+	sessionsDTO.SessionGap = s.SessionGap
+
+	sessionsDTO.Timezone, ok = parseTimezone(w, r)
+	if !ok {
+		return
+	}
+
+	if !isValidSessionGroupBy(sessionsDTO.GroupBy) {
+		WriteError(w, http.StatusBadRequest, "invalid group_by value", nil)
+		return
+	}
+
+	if !isWithinBucketLimit(sessionsDTO.GroupBy, from, to) {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("too many time buckets for this range (max %d)", storage.MaxTimeBuckets), nil)
+		return
+	}
+
+	// Allow overriding the inactivity gap per request, eg: session_gap=15m
+	if gapStr := r.URL.Query().Get("session_gap"); gapStr != "" {
+		gap, err := parseSessionGap(gapStr)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, "invalid session_gap (must be between 1m and 24h)", nil)
+			return
+		}
+		sessionsDTO.SessionGap = gap
+	}
+
+	sessions, err := s.Store.GetSessionMetrics(r.Context(), sessionsDTO)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to retrieve session metrics", nil)
+		return
+	}
+
+	WriteSuccess(w, http.StatusOK, api.SessionMetricsResponseDTO{
+		Sessions: sessions,
+	})
+}
+
+// parseMetricsRange parses and validates the from and to query parameters shared by the metrics endpoints.
+// from may not be older than maxAge. Writes an error response and returns false if the range is invalid.
+func parseMetricsRange(w http.ResponseWriter, r *http.Request, maxAge time.Duration) (int64, int64, bool) {
 	// Parse query parameters for from and to timestamps
 	fromStr := r.URL.Query().Get("from")
 	if fromStr == "" {
 		WriteError(w, http.StatusBadRequest, "from query parameter is required", nil)
-		return
+		return 0, 0, false
 	}
 
 	from, err := strconv.ParseInt(fromStr, 10, 64)
 	if err != nil {
 		WriteError(w, http.StatusBadRequest, "invalid from timestamp", nil)
-		return
+		return 0, 0, false
 	}
 
 	toStr := r.URL.Query().Get("to")
@@ -180,48 +447,200 @@ func (s *Server) HandleGetMetrics(w http.ResponseWriter, r *http.Request) {
 		to, err = strconv.ParseInt(toStr, 10, 64)
 		if err != nil {
 			WriteError(w, http.StatusBadRequest, "invalid to timestamp", nil)
-			return
+			return 0, 0, false
 		}
 	}
 
-	var metricsDTO api.MetricsRequestDTO
-
-	// Get query parameters
-	metricsDTO.EventName = r.URL.Query().Get("event_name")
-	metricsDTO.GroupBy = r.URL.Query().Get("group_by")
-	metricsDTO.From = from
-	metricsDTO.To = to
+	if storage.NormalizeTimestamp(from).After(storage.NormalizeTimestamp(to)) {
+		WriteError(w, http.StatusBadRequest, "from must be before to", nil)
+		return 0, 0, false
+	}
 
-	// Validate required fields
-	// A validation library could be used here for more complex validation rules
-	if metricsDTO.EventName == "" {
-		WriteError(w, http.StatusBadRequest, "event_name is required", nil)
-		return
+	if storage.NormalizeTimestamp(from).Before(time.Now().Add(-maxAge)) {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("from must be within the last %d days", int(maxAge.Hours()/24)), nil)
+		return 0, 0, false
 	}
 
-	if metricsDTO.GroupBy != "" && metricsDTO.GroupBy != "day" && metricsDTO.GroupBy != "hour" && metricsDTO.GroupBy != "channel" {
-		WriteError(w, http.StatusBadRequest, "invalid group_by value", nil)
-		return
+	return from, to, true
+}
+
+// Limits on how much a single /metrics request may ask for.
+const (
+	maxMetricsEventNames = 20
+	maxMetricsFormulas   = 10
+	maxMetricsGroupLimit = 1000
+	maxMetricsPageSize   = 1000
+)
+
+// parseEventNames reads event_name, which may be repeated and/or hold a comma separated list.
+// Names are trimmed and deduplicated in order of appearance.
+func parseEventNames(r *http.Request) []string {
+	var eventNames []string
+	for _, eventName := range lists.Split(r.URL.Query()["event_name"]...) {
+		if !slices.Contains(eventNames, eventName) {
+			eventNames = append(eventNames, eventName)
+		}
 	}
+	return eventNames
+}
 
-	if storage.NormalizeTimestamp(metricsDTO.From).After(storage.NormalizeTimestamp(metricsDTO.To)) {
-		WriteError(w, http.StatusBadRequest, "from must be before to", nil)
-		return
+// parseComparison reads the compare, compare_from and compare_to query parameters into metricsDTO.
+// Writes an error response and returns false if the comparison window is invalid.
+func (s *Server) parseComparison(w http.ResponseWriter, r *http.Request, metricsDTO *api.MetricsRequestDTO) bool {
+	metricsDTO.Compare = r.URL.Query().Get("compare")
+	if metricsDTO.Compare == "" {
+		return true
 	}
 
-	if storage.NormalizeTimestamp(metricsDTO.From).Before(time.Now().Add(-30 * 24 * time.Hour)) {
-		WriteError(w, http.StatusBadRequest, "from must be within the last 30 days", nil)
-		return
+	for _, param := range []struct {
+		name   string
+		target *int64
+	}{
+		{"compare_from", &metricsDTO.CompareFrom},
+		{"compare_to", &metricsDTO.CompareTo},
+	} {
+		value := r.URL.Query().Get(param.name)
+		if value == "" {
+			continue
+		}
+		ts, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid %s timestamp", param.name), nil)
+			return false
+		}
+		*param.target = ts
 	}
 
-	// Retrieve metrics from the store
-	metrics, err := s.Store.GetMetrics(r.Context(), metricsDTO)
+	compareFrom, _, err := storage.ComparisonWindow(*metricsDTO)
 	if err != nil {
-		WriteError(w, http.StatusInternalServerError, "failed to retrieve metrics", nil)
-		return
+		WriteError(w, http.StatusBadRequest, err.Error(), nil)
+		return false
 	}
 
-	WriteSuccess(w, http.StatusOK, api.MetricsResponseDTO{
-		Metrics: metrics,
-	})
+	maxAge := s.TierLimits.Daily
+	if metricsDTO.Timezone != "UTC" || !storage.IsRollupGroupBy(metricsDTO.GroupBy) {
+		maxAge = s.TierLimits.Raw
+	}
+	if compareFrom.Before(time.Now().Add(-maxAge)) {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("comparison window must be within the last %d days", int(maxAge.Hours()/24)), nil)
+		return false
+	}
+
+	return true
+}
+
+// parseTimezone reads the optional tz query parameter, an IANA timezone name used to align time buckets.
+// Defaults to UTC. Writes an error response and returns false if the name is unknown.
+func parseTimezone(w http.ResponseWriter, r *http.Request) (string, bool) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return "UTC", true
+	}
+
+	loc, err := time.LoadLocation(tz)
+	if err != nil || tz == "Local" {
+		WriteError(w, http.StatusBadRequest, "invalid tz value (expected an IANA timezone name, eg: Europe/Istanbul)", nil)
+		return "", false
+	}
+
+	return loc.String(), true
+}
+
+// isValidGroupBy accepts the dimensions (channel, campaign_id, metadata.<key>), the named time granularities
+// (minute, 5m, 15m, hour, day, week, month) and custom intervals of at least one minute (eg: 10m, 2h).
+func isValidGroupBy(groupBy string) bool {
+	if groupBy == "" || storage.IsDimensionGroupBy(groupBy) {
+		return true
+	}
+	_, ok := storage.ParseTimeGranularity(groupBy)
+	return ok
+}
+
+// isValidSessionGroupBy accepts channel and the time groupings, sessions have no campaign or metadata dimension.
+func isValidSessionGroupBy(groupBy string) bool {
+	if groupBy == "" || groupBy == "channel" {
+		return true
+	}
+	_, ok := storage.ParseTimeGranularity(groupBy)
+	return ok
+}
+
+// parsePagination reads page_size and cursor into metricsDTO. A cursor without page_size is rejected
+// since the page size is not part of the query fingerprint.
+// When to is omitted, the range end is taken from the cursor so later pages don't drift with the clock.
+func parsePagination(w http.ResponseWriter, r *http.Request, metricsDTO *api.MetricsRequestDTO) bool {
+	pageSizeStr := r.URL.Query().Get("page_size")
+	token := r.URL.Query().Get("cursor")
+	if pageSizeStr == "" {
+		if token != "" {
+			WriteError(w, http.StatusBadRequest, "cursor requires page_size", nil)
+			return false
+		}
+		return true
+	}
+
+	pageSize, err := strconv.Atoi(pageSizeStr)
+	if err != nil || pageSize < 1 || pageSize > maxMetricsPageSize {
+		WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid page_size (must be between 1 and %d)", maxMetricsPageSize), nil)
+		return false
+	}
+
+	cursor, err := storage.DecodeMetricsCursor(token)
+	if err == nil && token != "" && r.URL.Query().Get("to") == "" {
+		metricsDTO.To = cursor.To
+	}
+	if err != nil || !cursor.Matches(*metricsDTO) {
+		WriteError(w, http.StatusBadRequest, "invalid cursor (it must come from the same query)", nil)
+		return false
+	}
+
+	metricsDTO.PageSize = pageSize
+	metricsDTO.Cursor = token
+	return true
+}
+
+// parseTopN reads the limit and order_by parameters of dimension breakdowns.
+// A limit without order_by ranks groups by event count.
+func parseTopN(w http.ResponseWriter, r *http.Request, metricsDTO *api.MetricsRequestDTO) bool {
+	limitStr := r.URL.Query().Get("limit")
+	metricsDTO.OrderBy = r.URL.Query().Get("order_by")
+	if limitStr == "" && metricsDTO.OrderBy == "" {
+		return true
+	}
+
+	if !storage.IsDimensionGroupBy(metricsDTO.GroupBy) {
+		WriteError(w, http.StatusBadRequest, "limit and order_by require a dimension group_by (channel, campaign_id or metadata.<key>)", nil)
+		return false
+	}
+
+	switch metricsDTO.OrderBy {
+	case "", storage.OrderByCount, storage.OrderByUniqueUsers:
+	default:
+		WriteError(w, http.StatusBadRequest, "invalid order_by value (expected count or unique_users)", nil)
+		return false
+	}
+
+	if limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxMetricsGroupLimit {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("invalid limit (must be between 1 and %d)", maxMetricsGroupLimit), nil)
+			return false
+		}
+		metricsDTO.Limit = limit
+		if metricsDTO.OrderBy == "" {
+			metricsDTO.OrderBy = storage.OrderByCount
+		}
+	}
+
+	return true
+}
+
+// isWithinBucketLimit reports whether a time grouping over [from, to) stays under storage.MaxTimeBuckets.
+func isWithinBucketLimit(groupBy string, from, to int64) bool {
+	granularity, ok := storage.ParseTimeGranularity(groupBy)
+	if !ok {
+		return true
+	}
+	span := storage.NormalizeTimestamp(to).Sub(storage.NormalizeTimestamp(from))
+	return span/granularity.Approx() <= storage.MaxTimeBuckets
 }
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/model"
+	"fast-ingest/internal/storage"
+	"fast-ingest/internal/transform"
+)
+
+// newEvent returns a valid purchase of the user.
+func newEvent(userID string) model.Event {
+	return model.Event{EventName: "purchase", Channel: "web", UserID: userID, Timestamp: 1769904000}
+}
+
+// metricsStore answers every metrics query with empty metrics.
+type metricsStore struct {
+	storage.Store
+}
+
+func (metricsStore) GetMetrics(context.Context, api.MetricsRequestDTO) (model.Metrics, error) {
+	return model.Metrics{}, nil
+}
+
+func TestGetMetricsRangeLimits(t *testing.T) {
+	router := NewRouter(NewServer(metricsStore{}, 10))
+
+	now := time.Now()
+	recent := now.Add(-7 * 24 * time.Hour).Unix()
+	old := now.Add(-90 * 24 * time.Hour).Unix()
+
+	tests := []struct {
+		name    string
+		from    int64
+		groupBy string
+		want    int
+	}{
+		{"rollup grouping reaches the daily limit", old, "day", http.StatusOK},
+		{"dimension rollup grouping reaches the daily limit", old, "channel", http.StatusOK},
+		{"week is computed from raw events", old, "week", http.StatusBadRequest},
+		{"month is computed from raw events", old, "month", http.StatusBadRequest},
+		{"metadata is computed from raw events", old, "metadata.plan", http.StatusBadRequest},
+		{"raw grouping within the raw window", recent, "week", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := fmt.Sprintf("/metrics?event_name=purchase&from=%d&to=%d&group_by=%s", tt.from, now.Unix(), tt.groupBy)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+
+			if rec.Code != tt.want {
+				t.Errorf("got %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetMetricsFormulaPlus(t *testing.T) {
+	router := NewRouter(NewServer(metricsStore{}, 10))
+	from := time.Now().Add(-24 * time.Hour).Unix()
+
+	tests := []struct {
+		name    string
+		formula string
+		want    int
+	}{
+		{"encoded plus", "visits:page_view%2Bscreen_view", http.StatusOK},
+		{"plain plus decodes to a space", "visits:page_view+screen_view", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := fmt.Sprintf("/metrics?event_name=page_view&from=%d&formula=%s", from, tt.formula)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+
+			if rec.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+			if tt.want == http.StatusBadRequest && !strings.Contains(rec.Body.String(), "%2B") {
+				t.Errorf("expected a hint to encode +, got %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestIngestEventDerivesRequiredFields(t *testing.T) {
+	transformer, err := transform.New([]transform.Rule{
+		{Name: "source", Derive: []transform.Derivation{{Field: "channel", From: "metadata.source"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(nil, 10)
+	s.Transformer = transformer
+	router := NewRouter(s)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"empty channel derived", `{"event_name":"purchase","user_id":"user_1","timestamp":1769904000,"metadata":{"source":"web"}}`, http.StatusAccepted},
+		{"empty channel without source", `{"event_name":"purchase","user_id":"user_1","timestamp":1769904000}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("POST", "/events", strings.NewReader(tt.body)))
+
+			if rec.Code != tt.want {
+				t.Errorf("got %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+
+	if e := <-s.Queue; e.Channel != "web" {
+		t.Errorf("expected the derived channel to be queued, got %+v", e)
+	}
+}
+
+func TestSessionGapFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"defaults when unset", "", DefaultSessionGap, false},
+		{"duration", "15m", 15 * time.Minute, false},
+		{"lower bound", "1m", time.Minute, false},
+		{"upper bound", "24h", 24 * time.Hour, false},
+		{"not a duration", "15", 0, true},
+		{"below a minute", "30s", 0, true},
+		{"above a day", "25h", 0, true},
+		{"negative", "-5m", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SESSION_GAP", tt.env)
+			got, err := SessionGapFromEnv()
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("got %s, %v, want %s (error: %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
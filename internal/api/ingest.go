@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"fast-ingest/internal/ingest"
+	"fast-ingest/internal/model"
+	"fmt"
+	"net/http"
+)
+
+// MaxBatchEvents caps the events of a single batch, on every ingest path.
+const MaxBatchEvents = 1000
+
+// batchError is why a batch was refused, Status is the HTTP status it maps to.
+type batchError struct {
+	Status int
+	Msg    string
+}
+
+// ErrMissingFields is returned by PrepareEvent for an event still missing a required field once transformed.
+var ErrMissingFields = errors.New("missing required fields")
+
+// ValidEvent reports whether e has every required field. Shared by every ingest path.
+func ValidEvent(e model.Event) bool {
+	return e.EventName != "" && e.Channel != "" && e.UserID != "" && e.Timestamp != 0
+}
+
+// PrepareEvent runs an event through transformation, validation, sampling, enrichment and redaction before it is queued.
+// Transformations run first, so they can fill required fields, eg: a channel derived from metadata, and validation
+// sees their result. Sampling and enrichment see the transformed event name, and redaction covers enriched fields too.
+// Every ingest path calls it. Returns false if a rule dropped the event or it was sampled out, ErrMissingFields if it
+// is invalid, or another error if it was rejected.
+func (s *Server) PrepareEvent(req *ingest.Request, e *model.Event) (bool, error) {
+	if result := s.Transformer.Apply(e); result.Dropped != "" {
+		return false, nil
+	}
+
+	if !ValidEvent(*e) {
+		return false, ErrMissingFields
+	}
+
+	if !s.Sampler.Keep(req, e) {
+		return false, nil
+	}
+
+	s.Pipeline.Process(req, e)
+
+	if err := s.Redactor.Apply(e); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Enqueue hands a prepared event to the writer without blocking. Returns false if the queue is full.
+func (s *Server) Enqueue(e model.Event) bool {
+	select {
+	case s.Queue <- e:
+		return true
+	default:
+		return false
+	}
+}
+
+// ingestBatch prepares every event of a batch before queueing any, so an invalid or rejected event
+// refuses the whole batch. Returns how many events were dropped by rules or sampling.
+// Events queued before the queue filled up stay queued, a retry is deduplicated by the writer.
+func (s *Server) ingestBatch(req *ingest.Request, events []model.Event) (int, *batchError) {
+	if len(events) == 0 {
+		return 0, &batchError{http.StatusBadRequest, "events is required"}
+	}
+	if len(events) > MaxBatchEvents {
+		return 0, &batchError{http.StatusBadRequest, fmt.Sprintf("too many events (max %d)", MaxBatchEvents)}
+	}
+
+	kept := events[:0]
+	for i := range events {
+		keep, err := s.PrepareEvent(req, &events[i])
+		if errors.Is(err, ErrMissingFields) {
+			return 0, &batchError{http.StatusBadRequest, fmt.Sprintf("invalid event at index %d", i)}
+		}
+		if err != nil {
+			return 0, &batchError{http.StatusUnprocessableEntity, fmt.Sprintf("event at index %d: %v", i, err)}
+		}
+		if keep {
+			kept = append(kept, events[i])
+		}
+	}
+	dropped := len(events) - len(kept)
+
+	for _, e := range kept {
+		if !s.Enqueue(e) {
+			return dropped, &batchError{http.StatusTooManyRequests, "ingest queue full"}
+		}
+	}
+	return dropped, nil
+}
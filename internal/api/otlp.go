@@ -0,0 +1,129 @@
+package api
+
+import (
+	"compress/gzip"
+	"errors"
+	"fast-ingest/internal/ingest"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxOTLPBody limits OTLP requests once decompressed, like request bodies of the other ingest endpoints.
+const maxOTLPBody = 20 * 1024 * 1024
+
+// HandleOTLPLogs handles POST /v1/logs
+// OTLP/HTTP logs receiver, in protobuf or JSON and optionally gzipped. Log records carrying an event name are queued
+// as events, the others are ignored. Records that aren't valid events are reported as rejected in a partial success.
+// Errors are a google.rpc.Status encoded like the request, as OTLP/HTTP requires.
+func (s *Server) HandleOTLPLogs(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxOTLPBody)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-protobuf" && mediaType != "application/json" {
+		// Answered in protobuf, the default encoding of OTLP
+		writeOTLPError(w, "application/x-protobuf", http.StatusUnsupportedMediaType, "unsupported Content-Type (expected application/x-protobuf or application/json)")
+		return
+	}
+
+	body := io.Reader(r.Body)
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeOTLPError(w, mediaType, http.StatusBadRequest, "invalid gzip body")
+			return
+		}
+		defer gz.Close()
+		body = gz
+	default:
+		writeOTLPError(w, mediaType, http.StatusUnsupportedMediaType, "unsupported Content-Encoding (expected gzip)")
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxOTLPBody+1))
+	if err != nil || len(data) > maxOTLPBody {
+		writeOTLPError(w, mediaType, http.StatusBadRequest, "invalid or too large body")
+		return
+	}
+
+	var export collogspb.ExportLogsServiceRequest
+	if mediaType == "application/json" {
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, &export)
+	} else {
+		err = proto.Unmarshal(data, &export)
+	}
+	if err != nil {
+		writeOTLPError(w, mediaType, http.StatusBadRequest, "invalid OTLP payload")
+		return
+	}
+
+	// Records are handled one by one, OTLP reports the ones it couldn't take instead of failing the request
+	req := ingest.NewRequest(r)
+	var rejected, noChannel int64
+	for _, e := range s.OTLP.Events(&export, req.ReceivedAt) {
+		keep, err := s.PrepareEvent(req, &e)
+		if err != nil {
+			rejected++
+			if errors.Is(err, ErrMissingFields) && e.Channel == "" {
+				noChannel++
+			}
+			continue
+		}
+		if keep && !s.Enqueue(e) {
+			// Exporters retry on 429, records queued already are deduplicated
+			w.Header().Set("Retry-After", "1")
+			writeOTLPError(w, mediaType, http.StatusTooManyRequests, "ingest queue full")
+			return
+		}
+	}
+
+	resp := &collogspb.ExportLogsServiceResponse{}
+	if rejected > 0 {
+		message := fmt.Sprintf("%d log records were invalid or rejected events", rejected)
+		if noChannel > 0 {
+			message += fmt.Sprintf(", %d of them have no channel: the resource has no %s attribute", noChannel, s.OTLP.ChannelAttribute)
+		}
+		resp.PartialSuccess = &collogspb.ExportLogsPartialSuccess{
+			RejectedLogRecords: rejected,
+			ErrorMessage:       message,
+		}
+	}
+
+	writeOTLP(w, mediaType, http.StatusOK, resp)
+}
+
+// writeOTLPError writes a google.rpc.Status with message, encoded as mediaType.
+func writeOTLPError(w http.ResponseWriter, mediaType string, status int, message string) {
+	code := codes.InvalidArgument
+	if status == http.StatusTooManyRequests {
+		code = codes.ResourceExhausted
+	}
+	writeOTLP(w, mediaType, status, &spb.Status{Code: int32(code), Message: message})
+}
+
+// writeOTLP writes m encoded as mediaType, JSON or protobuf.
+func writeOTLP(w http.ResponseWriter, mediaType string, status int, m proto.Message) {
+	var out []byte
+	var err error
+	if mediaType == "application/json" {
+		out, err = protojson.Marshal(m)
+	} else {
+		out, err = proto.Marshal(m)
+	}
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, "failed to encode response", nil)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(status)
+	_, _ = w.Write(out)
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func newLogRecord(eventName, userID string) *logspb.LogRecord {
+	record := &logspb.LogRecord{TimeUnixNano: 1769904000 * 1e9}
+	for key, value := range map[string]string{"event.name": eventName, "user.id": userID} {
+		if value != "" {
+			record.Attributes = append(record.Attributes, &commonpb.KeyValue{
+				Key:   key,
+				Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}},
+			})
+		}
+	}
+	return record
+}
+
+func newExport(records ...*logspb.LogRecord) *collogspb.ExportLogsServiceRequest {
+	return &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				{Key: "service.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "checkout"}}},
+			}},
+			ScopeLogs: []*logspb.ScopeLogs{{LogRecords: records}},
+		}},
+	}
+}
+
+func TestOTLPLogs(t *testing.T) {
+	export := newExport(
+		newLogRecord("purchase", "user_1"),
+		newLogRecord("", "user_1"), // a plain log, ignored
+		newLogRecord("purchase", ""),
+	)
+	protobufBody, _ := proto.Marshal(export)
+	jsonBody, _ := protojson.Marshal(export)
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	_, _ = gz.Write(protobufBody)
+	_ = gz.Close()
+
+	tests := []struct {
+		name        string
+		contentType string
+		encoding    string
+		body        []byte
+	}{
+		{"protobuf", "application/x-protobuf", "", protobufBody},
+		{"json", "application/json", "", jsonBody},
+		{"gzipped protobuf", "application/x-protobuf", "gzip", gzipped.Bytes()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(nil, 10)
+			r := httptest.NewRequest("POST", "/v1/logs", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			r.Header.Set("Content-Encoding", tt.encoding)
+			rec := httptest.NewRecorder()
+			NewRouter(server).ServeHTTP(rec, r)
+
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != tt.contentType {
+				t.Fatalf("got %d %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+			}
+
+			var resp collogspb.ExportLogsServiceResponse
+			var err error
+			if tt.contentType == "application/json" {
+				err = protojson.Unmarshal(rec.Body.Bytes(), &resp)
+			} else {
+				err = proto.Unmarshal(rec.Body.Bytes(), &resp)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.GetPartialSuccess().GetRejectedLogRecords() != 1 {
+				t.Errorf("expected the record without user to be rejected, got %v", resp.GetPartialSuccess())
+			}
+
+			if len(server.Queue) != 1 {
+				t.Fatalf("expected 1 queued event, got %d", len(server.Queue))
+			}
+			if e := <-server.Queue; e.EventName != "purchase" || e.Channel != "checkout" || e.UserID != "user_1" || e.Timestamp != 1769904000 {
+				t.Errorf("unexpected event %+v", e)
+			}
+		})
+	}
+}
+
+func TestOTLPLogsErrors(t *testing.T) {
+	body, _ := proto.Marshal(newExport(newLogRecord("purchase", "user_1"), newLogRecord("purchase", "user_2")))
+
+	tests := []struct {
+		name         string
+		contentType  string
+		body         []byte
+		queueSize    int
+		want         int
+		responseType string
+	}{
+		{"unsupported type", "text/plain", body, 10, http.StatusUnsupportedMediaType, "application/x-protobuf"},
+		{"invalid payload", "application/x-protobuf", []byte{0xff, 0xff}, 10, http.StatusBadRequest, "application/x-protobuf"},
+		{"invalid JSON", "application/json", []byte(`{"resourceLogs":`), 10, http.StatusBadRequest, "application/json"},
+		{"queue full", "application/x-protobuf", body, 1, http.StatusTooManyRequests, "application/x-protobuf"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/v1/logs", strings.NewReader(string(tt.body)))
+			r.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			NewRouter(NewServer(nil, tt.queueSize)).ServeHTTP(rec, r)
+
+			if rec.Code != tt.want || rec.Header().Get("Content-Type") != tt.responseType {
+				t.Fatalf("got %d %q, want %d %q: %s", rec.Code, rec.Header().Get("Content-Type"), tt.want, tt.responseType, rec.Body)
+			}
+
+			// Errors are a google.rpc.Status, encoded like the request
+			var status spb.Status
+			var err error
+			if tt.responseType == "application/json" {
+				err = protojson.Unmarshal(rec.Body.Bytes(), &status)
+			} else {
+				err = proto.Unmarshal(rec.Body.Bytes(), &status)
+			}
+			if err != nil || status.GetMessage() == "" {
+				t.Errorf("expected a status with a message, got %v (%v)", &status, err)
+			}
+		})
+	}
+}
+
+func TestOTLPLogsWithoutChannel(t *testing.T) {
+	export := newExport(newLogRecord("purchase", "user_1"))
+	export.ResourceLogs[0].Resource = nil
+	body, _ := proto.Marshal(export)
+
+	r := httptest.NewRequest("POST", "/v1/logs", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-protobuf")
+	rec := httptest.NewRecorder()
+	NewRouter(NewServer(nil, 10)).ServeHTTP(rec, r)
+
+	var resp collogspb.ExportLogsServiceResponse
+	if err := proto.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	partial := resp.GetPartialSuccess()
+	if partial.GetRejectedLogRecords() != 1 || !strings.Contains(partial.GetErrorMessage(), "no service.name attribute") {
+		t.Errorf("expected the missing service.name to be reported, got %v", partial)
+	}
+}
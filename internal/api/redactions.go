@@ -0,0 +1,9 @@
+package api
+
+import "net/http"
+
+// HandleGetRedactionStats handles GET /admin/redactions
+// Returns how many values each redaction rule redacted since startup.
+func (s *Server) HandleGetRedactionStats(w http.ResponseWriter, r *http.Request) {
+	WriteSuccess(w, http.StatusOK, s.Redactor.Stats())
+}
@@ -18,8 +18,53 @@ func NewRouter(s *Server) *chi.Mux {
 
 	r.Post("/events", s.HandleIngestEvent)
 	r.Post("/events/bulk", s.HandleBulkIngestEvents)
+	r.Get("/events/ws", s.HandleIngestWebSocket)
+	r.Get("/events/tail", s.HandleTailEvents)
+	r.Get("/events", s.HandleSearchEvents)
+	r.Get("/events/{dedupe_key}", s.HandleGetEvent)
+
+	// Pixel and beacon routes are called by browsers and email clients from other origins
+	r.Group(func(r chi.Router) {
+		r.Use(s.AllowBeaconOrigin)
+
+		r.Get("/p.gif", s.HandlePixel)
+		r.Post("/beacon", s.HandleBeacon)
+		r.Options("/p.gif", s.HandlePreflight)
+		r.Options("/beacon", s.HandlePreflight)
+	})
+
+	// Segment-compatible tracking API
+	r.Post("/v1/track", s.HandleSegmentTrack)
+	r.Post("/v1/identify", s.HandleSegmentIdentify)
+	r.Post("/v1/page", s.HandleSegmentPage)
+	r.Post("/v1/screen", s.HandleSegmentScreen)
+	r.Post("/v1/batch", s.HandleSegmentBatch)
+
+	// OpenTelemetry logs carrying business events
+	r.Post("/v1/logs", s.HandleOTLPLogs)
+
+	r.Post("/transforms/dry-run", s.HandleTransformDryRun)
 
 	r.Get("/metrics", s.HandleGetMetrics)
+	r.Get("/metrics/sessions", s.HandleGetSessionMetrics)
+
+	r.Get("/exports/events", s.HandleExportEvents)
+	r.Get("/exports/{id}", s.HandleGetExport)
+	r.Get("/exports/{id}/download", s.HandleDownloadExport)
+
+	// Admin routes require the admin token
+	r.Group(func(r chi.Router) {
+		r.Use(s.RequireAdmin)
+
+		r.Post("/admin/erasures", s.HandleEraseUser)
+		r.Get("/admin/erasures/{id}", s.HandleGetErasure)
+
+		r.Get("/admin/redactions", s.HandleGetRedactionStats)
+
+		r.Get("/admin/consumer/lag", s.HandleGetConsumerLag)
+
+		r.Get("/admin/sinks", s.HandleGetSinkStatus)
+	})
 
 	return r
 }
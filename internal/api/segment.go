@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/ingest"
+	"fast-ingest/internal/model"
+	"fast-ingest/internal/segment"
+	"fmt"
+	"net/http"
+)
+
+// HandleSegmentTrack handles POST /v1/track
+func (s *Server) HandleSegmentTrack(w http.ResponseWriter, r *http.Request) {
+	s.handleSegmentMessage(w, r, segment.TypeTrack)
+}
+
+// HandleSegmentIdentify handles POST /v1/identify
+func (s *Server) HandleSegmentIdentify(w http.ResponseWriter, r *http.Request) {
+	s.handleSegmentMessage(w, r, segment.TypeIdentify)
+}
+
+// HandleSegmentPage handles POST /v1/page
+func (s *Server) HandleSegmentPage(w http.ResponseWriter, r *http.Request) {
+	s.handleSegmentMessage(w, r, segment.TypePage)
+}
+
+// HandleSegmentScreen handles POST /v1/screen
+func (s *Server) HandleSegmentScreen(w http.ResponseWriter, r *http.Request) {
+	s.handleSegmentMessage(w, r, segment.TypeScreen)
+}
+
+// handleSegmentMessage queues a single Segment message of the given type.
+func (s *Server) handleSegmentMessage(w http.ResponseWriter, r *http.Request, messageType string) {
+	r.Body = http.MaxBytesReader(w, r.Body, 32*1024) // Segment caps messages to 32KB
+
+	// Segment payloads carry many fields without an event equivalent, unknown fields are ignored
+	var msg segment.Message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid JSON payload", nil)
+		return
+	}
+	msg.Type = messageType
+
+	req := segmentRequest(r)
+	e, err := msg.ToEvent(req.ReceivedAt)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+
+	s.ingestSegmentEvents(w, req, []model.Event{e}, 0)
+}
+
+// HandleSegmentBatch handles POST /v1/batch
+// Messages of types without an event equivalent, such as group and alias, are skipped and counted as dropped.
+func (s *Server) HandleSegmentBatch(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 500*1024) // Segment caps batches to 500KB
+
+	var batch segment.Batch
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid JSON payload", nil)
+		return
+	}
+	if len(batch.Batch) == 0 {
+		WriteError(w, http.StatusBadRequest, "batch is required", nil)
+		return
+	}
+
+	req := segmentRequest(r)
+	events := make([]model.Event, 0, len(batch.Batch))
+	skipped := 0
+	for i, msg := range batch.Batch {
+		if msg.Context == nil {
+			msg.Context = batch.Context
+		}
+		if msg.SentAt == "" {
+			msg.SentAt = batch.SentAt
+		}
+
+		e, err := msg.ToEvent(req.ReceivedAt)
+		if errors.Is(err, segment.ErrUnsupportedType) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, fmt.Sprintf("message at index %d: %v", i, err), nil)
+			return
+		}
+		events = append(events, e)
+	}
+
+	if len(events) == 0 {
+		WriteSuccess(w, http.StatusOK, api.EventsBulkResponseDTO{Accepted: skipped, Dropped: skipped})
+		return
+	}
+	s.ingestSegmentEvents(w, req, events, skipped)
+}
+
+// ingestSegmentEvents queues mapped events like POST /events/bulk. Segment clients expect 200 on success.
+func (s *Server) ingestSegmentEvents(w http.ResponseWriter, req *ingest.Request, events []model.Event, skipped int) {
+	dropped, batchErr := s.ingestBatch(req, events)
+	if batchErr != nil {
+		if batchErr.Status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		WriteError(w, batchErr.Status, batchErr.Msg, nil)
+		return
+	}
+
+	WriteSuccess(w, http.StatusOK, api.EventsBulkResponseDTO{
+		Accepted: len(events) + skipped,
+		Dropped:  dropped + skipped,
+	})
+}
+
+// segmentRequest describes r to the ingest processors. Segment clients authenticate with their write key as
+// the basic auth username, it stands in for X-API-Key so rules keyed by API key apply.
+func segmentRequest(r *http.Request) *ingest.Request {
+	req := ingest.NewRequest(r)
+	if writeKey, _, ok := r.BasicAuth(); ok && writeKey != "" && req.Header.Get("X-API-Key") == "" {
+		req.SetAPIKey(writeKey)
+	}
+	return req
+}
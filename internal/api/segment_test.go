@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSegmentEndpoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		want   int
+		queued int
+	}{
+		{"track", "/v1/track", `{"event":"Signed Up","userId":"42","writeKey":"wk","integrations":{"All":true}}`, http.StatusOK, 1},
+		{"identify", "/v1/identify", `{"userId":"42","traits":{"plan":"pro"}}`, http.StatusOK, 1},
+		{"page", "/v1/page", `{"anonymousId":"anon_1","name":"Home"}`, http.StatusOK, 1},
+		{"track without event", "/v1/track", `{"userId":"42"}`, http.StatusBadRequest, 0},
+		{"without user", "/v1/page", `{"name":"Home"}`, http.StatusBadRequest, 0},
+		{"invalid timestamp", "/v1/track", `{"event":"e","userId":"42","timestamp":"now"}`, http.StatusBadRequest, 0},
+		{
+			"batch", "/v1/batch",
+			`{"batch":[{"type":"track","event":"e","userId":"42"},{"type":"alias","userId":"42","previousId":"anon_1"},{"type":"page","userId":"42"}],
+			"context":{"library":{"name":"analytics-kotlin"}},"sentAt":"2026-02-01T10:00:00Z"}`,
+			http.StatusOK, 2,
+		},
+		{"empty batch", "/v1/batch", `{"batch":[]}`, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer(nil, 10)
+			rec := httptest.NewRecorder()
+			NewRouter(server).ServeHTTP(rec, httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body)))
+
+			if rec.Code != tt.want {
+				t.Errorf("got %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if len(server.Queue) != tt.queued {
+				t.Errorf("expected %d queued events, got %d", tt.queued, len(server.Queue))
+			}
+		})
+	}
+}
+
+func TestSegmentBatchContext(t *testing.T) {
+	server := NewServer(nil, 10)
+	body := `{"batch":[{"type":"track","event":"e","userId":"42"},{"type":"track","event":"e","userId":"43","context":{"library":{"name":"analytics.js"}}}],
+		"context":{"library":{"name":"analytics-kotlin"}}}`
+
+	rec := httptest.NewRecorder()
+	NewRouter(server).ServeHTTP(rec, httptest.NewRequest("POST", "/v1/batch", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body)
+	}
+
+	if e := <-server.Queue; e.Channel != "android" {
+		t.Errorf("expected the batch context to apply, got channel %q", e.Channel)
+	}
+	if e := <-server.Queue; e.Channel != "web" {
+		t.Errorf("expected the message context to win, got channel %q", e.Channel)
+	}
+}
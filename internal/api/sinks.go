@@ -0,0 +1,22 @@
+package api
+
+import (
+	"fast-ingest/internal/model"
+	"net/http"
+)
+
+// SinkStatusReporter reports the delivery status of the downstream destinations stored events are published to.
+type SinkStatusReporter interface {
+	Status() []model.SinkStatus
+}
+
+// HandleGetSinkStatus handles GET /admin/sinks
+// Returns the delivered, failed, dropped and pending events of every destination and how far behind it is.
+func (s *Server) HandleGetSinkStatus(w http.ResponseWriter, r *http.Request) {
+	if s.Sinks == nil {
+		WriteSuccess(w, http.StatusOK, []model.SinkStatus{})
+		return
+	}
+
+	WriteSuccess(w, http.StatusOK, s.Sinks.Status())
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fast-ingest/internal/lists"
+	"fast-ingest/internal/model"
+	"fast-ingest/internal/storage"
+	"fast-ingest/internal/tail"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// tailHeartbeatInterval is how often an idle tail sends a comment, so proxies don't close the stream.
+const tailHeartbeatInterval = 15 * time.Second
+
+// HandleTailEvents handles GET /events/tail
+// Streams events as Server-Sent Events as soon as they are stored, filtered by the event_name, channel and
+// user_id query parameters (comma separated or repeated). Each event is sent with its dedupe key as id.
+// A client too slow to keep up is sent a close event and disconnected.
+func (s *Server) HandleTailEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := tail.Filter{
+		EventName: lists.Split(query["event_name"]...),
+		Channel:   lists.Split(query["channel"]...),
+		UserID:    lists.Split(query["user_id"]...),
+	}
+
+	subscription, err := s.Tail.Subscribe(filter)
+	if err != nil {
+		WriteError(w, http.StatusServiceUnavailable, "event tail unavailable", err.Error())
+		return
+	}
+	defer s.Tail.Unsubscribe(subscription)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Disable response buffering in nginx
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	if _, err := fmt.Fprint(w, ": connected\n\n"); err != nil || rc.Flush() != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(tailHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-subscription.Done():
+			// Events buffered before the subscription ended are still sent
+			for len(subscription.Events()) > 0 {
+				if writeTailEvent(w, <-subscription.Events()) != nil {
+					return
+				}
+			}
+			reason := "closed"
+			if errors.Is(subscription.Err(), tail.ErrSlowSubscriber) {
+				reason = "slow_consumer"
+			}
+			data, _ := json.Marshal(map[string]string{"reason": reason, "error": subscription.Err().Error()})
+			_, _ = fmt.Fprintf(w, "event: close\ndata: %s\n\n", data)
+			_ = rc.Flush()
+			return
+
+		case e := <-subscription.Events():
+			if err := writeTailEvent(w, e); err != nil {
+				return
+			}
+			// Write what else is buffered before flushing, only this goroutine receives so it can't block
+			for n := len(subscription.Events()); n > 0; n-- {
+				if err := writeTailEvent(w, <-subscription.Events()); err != nil {
+					return
+				}
+			}
+			if rc.Flush() != nil {
+				return
+			}
+
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeTailEvent(w http.ResponseWriter, e model.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\ndata: %s\n\n", storage.DedupeKey(e), data)
+	return err
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fast-ingest/internal/model"
+	"fast-ingest/internal/storage"
+	"fast-ingest/internal/tail"
+)
+
+// readTailEvent reads the next SSE event, skipping comments, and returns its fields.
+func readTailEvent(t *testing.T, lines *bufio.Scanner) map[string]string {
+	t.Helper()
+
+	fields := make(map[string]string)
+	for lines.Scan() {
+		line := lines.Text()
+		if line == "" && len(fields) > 0 {
+			return fields
+		}
+		if line == "" || strings.HasPrefix(line, ":") {
+			continue
+		}
+		name, value, _ := strings.Cut(line, ": ")
+		fields[name] = value
+	}
+	t.Fatalf("stream ended: %v", lines.Err())
+	return nil
+}
+
+func TestTailEvents(t *testing.T) {
+	server := NewServer(nil, 10)
+	ts := httptest.NewServer(NewRouter(server))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/events/tail?user_id=user_1&event_name=purchase,refund", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for server.Tail.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	purchase := newEvent("user_1")
+	_ = server.Tail.Publish(ctx, []model.Event{newEvent("user_2"), purchase})
+
+	lines := bufio.NewScanner(resp.Body)
+	fields := readTailEvent(t, lines)
+	var e model.Event
+	if err := json.Unmarshal([]byte(fields["data"]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.UserID != "user_1" || fields["id"] != storage.DedupeKey(purchase) {
+		t.Errorf("unexpected event %v", fields)
+	}
+
+	server.Tail.Close()
+	if fields := readTailEvent(t, lines); fields["event"] != "close" || !strings.Contains(fields["data"], `"reason":"closed"`) {
+		t.Errorf("expected a close event, got %v", fields)
+	}
+}
+
+func TestTailEventsTooManySubscribers(t *testing.T) {
+	server := NewServer(nil, 10)
+	server.Tail = tail.NewHub(1, 0)
+
+	rec := httptest.NewRecorder()
+	NewRouter(server).ServeHTTP(rec, httptest.NewRequest("GET", "/events/tail", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"encoding/json"
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/model"
+	"net/http"
+)
+
+// HandleTransformDryRun handles POST /transforms/dry-run
+// Shows how the transformation rules would rewrite a sample event, without queueing it.
+func (s *Server) HandleTransformDryRun(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024) // Limit request body to 1MB
+
+	var e model.Event
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&e); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid JSON payload", nil)
+		return
+	}
+
+	result := s.Transformer.Apply(&e)
+	WriteSuccess(w, http.StatusOK, api.TransformDryRunResponseDTO{
+		Event:   e,
+		Dropped: result.Dropped,
+		Applied: result.Applied,
+	})
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/ingest"
+	"fast-ingest/internal/lists"
+	"fast-ingest/internal/model"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+const (
+	// wsMonitorInterval is how often the queue level and idle time of a connection are checked.
+	wsMonitorInterval = 250 * time.Millisecond
+
+	// wsWriteTimeout bounds each reply, so a client that stopped reading can't hold a connection open.
+	wsWriteTimeout = 10 * time.Second
+
+	// Clients are paused once the queue is wsPauseAt percent full, and resumed when it drained to wsResumeAt percent.
+	wsPauseAt  = 80
+	wsResumeAt = 50
+)
+
+// WebSocketConfig configures GET /events/ws.
+type WebSocketConfig struct {
+	// APIKeys are the keys accepted on upgrade, any client can connect while empty.
+	APIKeys []string
+
+	// OriginPatterns are the hosts browsers may connect from besides the API's own, eg: app.example.com or *.example.com.
+	OriginPatterns []string
+
+	// IdleTimeout closes connections that sent no message for that long, zero disables it.
+	IdleTimeout time.Duration
+}
+
+// DefaultWebSocketConfig is used when nothing is configured.
+var DefaultWebSocketConfig = WebSocketConfig{IdleTimeout: time.Minute}
+
+// WebSocketConfigFromEnv reads WS_API_KEYS and WS_ORIGIN_PATTERNS, both comma separated, and WS_IDLE_TIMEOUT.
+func WebSocketConfigFromEnv() (WebSocketConfig, error) {
+	config := DefaultWebSocketConfig
+	config.APIKeys = lists.Split(os.Getenv("WS_API_KEYS"))
+	config.OriginPatterns = lists.Split(os.Getenv("WS_ORIGIN_PATTERNS"))
+
+	if timeout := os.Getenv("WS_IDLE_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d < 0 {
+			return WebSocketConfig{}, fmt.Errorf("invalid WS_IDLE_TIMEOUT %q", timeout)
+		}
+		config.IdleTimeout = d
+	}
+
+	return config, nil
+}
+
+func (c WebSocketConfig) authorized(key string) bool {
+	if len(c.APIKeys) == 0 {
+		return true
+	}
+	for _, apiKey := range c.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleIngestWebSocket handles GET /events/ws
+// Upgrades to a WebSocket that takes one event or a batch per text message and acks each message by its seq.
+// The API key is read from X-API-Key, or from the api_key query parameter since browsers can't set headers on upgrade.
+func (s *Server) HandleIngestWebSocket(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = r.URL.Query().Get("api_key")
+	}
+	if !s.WebSocket.authorized(key) {
+		WriteError(w, http.StatusUnauthorized, "unauthorized", nil)
+		return
+	}
+
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: s.WebSocket.OriginPatterns})
+	if err != nil {
+		// Accept already answered the request
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(20 * 1024 * 1024) // Limit messages to 20MB, like request bodies
+
+	// Processors see the key like on the HTTP endpoints, even when it came as a query parameter
+	req := ingest.NewRequest(r)
+	if key != "" {
+		req.SetAPIKey(key)
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	var lastRead atomic.Int64
+	lastRead.Store(time.Now().UnixNano())
+	go s.monitorWebSocket(ctx, conn, &lastRead)
+
+	for {
+		typ, data, err := conn.Read(ctx)
+		if err != nil {
+			return
+		}
+		lastRead.Store(time.Now().UnixNano())
+
+		var reply any
+		if typ != websocket.MessageText {
+			reply = wsError(0, "expected a text message")
+		} else {
+			// Connections stay open for long, every message gets its own receive time
+			msgReq := *req
+			msgReq.ReceivedAt = time.Now().UTC()
+			reply = s.ingestWebSocketMessage(&msgReq, data)
+		}
+
+		if err := writeWebSocket(ctx, conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// ingestWebSocketMessage handles a message like POST /events/bulk, the reply is an ack or an error.
+func (s *Server) ingestWebSocketMessage(req *ingest.Request, data []byte) any {
+	var msg api.WebSocketMessageDTO
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields() // Strict decoding to catch unexpected fields
+
+	if err := dec.Decode(&msg); err != nil {
+		return wsError(msg.Seq, "invalid JSON payload")
+	}
+
+	events := msg.Events
+	if msg.Event != nil {
+		if len(events) > 0 {
+			return wsError(msg.Seq, "expected either event or events")
+		}
+		events = []model.Event{*msg.Event}
+	}
+	if len(events) == 0 {
+		return wsError(msg.Seq, "event or events is required")
+	}
+
+	dropped, batchErr := s.ingestBatch(req, events)
+	if batchErr != nil {
+		reply := wsError(msg.Seq, batchErr.Msg)
+		if batchErr.Status == http.StatusTooManyRequests {
+			reply.RetryAfterMs = time.Second.Milliseconds()
+		}
+		return reply
+	}
+
+	return api.WebSocketAckDTO{Type: "ack", Seq: msg.Seq, Accepted: len(events), Dropped: dropped}
+}
+
+// monitorWebSocket closes idle connections and tells the client to pause and resume as the queue fills and drains.
+func (s *Server) monitorWebSocket(ctx context.Context, conn *websocket.Conn, lastRead *atomic.Int64) {
+	ticker := time.NewTicker(wsMonitorInterval)
+	defer ticker.Stop()
+
+	paused := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		idle := time.Since(time.Unix(0, lastRead.Load()))
+		if s.WebSocket.IdleTimeout > 0 && idle > s.WebSocket.IdleTimeout {
+			_ = conn.Close(websocket.StatusPolicyViolation, "idle timeout")
+			return
+		}
+
+		length, capacity := len(s.Queue), cap(s.Queue)
+		switch {
+		case capacity == 0:
+			continue
+		case !paused && length*100 >= capacity*wsPauseAt:
+			paused = true
+		case paused && length*100 <= capacity*wsResumeAt:
+			paused = false
+		default:
+			continue
+		}
+
+		err := writeWebSocket(ctx, conn, api.WebSocketBackpressureDTO{
+			Type:          "backpressure",
+			Paused:        paused,
+			QueueLength:   length,
+			QueueCapacity: capacity,
+		})
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeWebSocket(ctx context.Context, conn *websocket.Conn, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, wsWriteTimeout)
+	defer cancel()
+	return wsjson.Write(ctx, conn, v)
+}
+
+func wsError(seq int64, msg string) api.WebSocketErrorDTO {
+	return api.WebSocketErrorDTO{Type: "error", Seq: seq, Error: msg}
+}
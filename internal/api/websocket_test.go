@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"errors"
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+func dialWebSocket(t *testing.T, server *Server, query string) *websocket.Conn {
+	t.Helper()
+
+	ts := httptest.NewServer(NewRouter(server))
+	t.Cleanup(ts.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(ts.URL, "http")+"/events/ws"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+// roundTrip sends msg and decodes its reply into a map, skipping backpressure messages.
+func roundTrip(t *testing.T, conn *websocket.Conn, msg any) map[string]any {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := wsjson.Write(ctx, conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		var reply map[string]any
+		if err := wsjson.Read(ctx, conn, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply["type"] != "backpressure" {
+			return reply
+		}
+	}
+}
+
+func TestWebSocketAcksMessages(t *testing.T) {
+	server := NewServer(nil, 10)
+	conn := dialWebSocket(t, server, "")
+
+	e := newEvent("user_1")
+	reply := roundTrip(t, conn, api.WebSocketMessageDTO{Seq: 1, Event: &e})
+	if reply["type"] != "ack" || reply["seq"] != float64(1) || reply["accepted"] != float64(1) {
+		t.Errorf("unexpected reply %v", reply)
+	}
+
+	reply = roundTrip(t, conn, api.WebSocketMessageDTO{Seq: 2, Events: []model.Event{newEvent("user_2"), newEvent("user_3")}})
+	if reply["type"] != "ack" || reply["seq"] != float64(2) || reply["accepted"] != float64(2) {
+		t.Errorf("unexpected reply %v", reply)
+	}
+
+	if len(server.Queue) != 3 {
+		t.Errorf("expected 3 queued events, got %d", len(server.Queue))
+	}
+}
+
+func TestWebSocketErrors(t *testing.T) {
+	invalid := newEvent("")
+	valid := newEvent("user_1")
+
+	tests := []struct {
+		name string
+		msg  any
+		want string
+	}{
+		{"unknown field", map[string]any{"seq": 1, "unexpected": true}, "invalid JSON payload"},
+		{"no events", api.WebSocketMessageDTO{Seq: 1}, "event or events is required"},
+		{"event and events", api.WebSocketMessageDTO{Seq: 1, Event: &valid, Events: []model.Event{valid}}, "expected either event or events"},
+		{"invalid event", api.WebSocketMessageDTO{Seq: 1, Events: []model.Event{valid, invalid}}, "invalid event at index 1"},
+	}
+
+	server := NewServer(nil, 10)
+	conn := dialWebSocket(t, server, "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := roundTrip(t, conn, tt.msg)
+			if reply["type"] != "error" || reply["seq"] != float64(1) || reply["error"] != tt.want {
+				t.Errorf("unexpected reply %v", reply)
+			}
+		})
+	}
+
+	if len(server.Queue) != 0 {
+		t.Errorf("expected nothing to be queued, got %d", len(server.Queue))
+	}
+}
+
+func TestWebSocketQueueFull(t *testing.T) {
+	server := NewServer(nil, 1)
+	conn := dialWebSocket(t, server, "")
+
+	reply := roundTrip(t, conn, api.WebSocketMessageDTO{Seq: 7, Events: []model.Event{newEvent("user_1"), newEvent("user_2")}})
+	if reply["type"] != "error" || reply["seq"] != float64(7) || reply["retry_after_ms"] != float64(1000) {
+		t.Errorf("unexpected reply %v", reply)
+	}
+}
+
+func TestWebSocketBackpressure(t *testing.T) {
+	server := NewServer(nil, 10)
+	conn := dialWebSocket(t, server, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	readBackpressure := func() api.WebSocketBackpressureDTO {
+		var msg api.WebSocketBackpressureDTO
+		if err := wsjson.Read(ctx, conn, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != "backpressure" {
+			t.Fatalf("expected a backpressure message, got %+v", msg)
+		}
+		return msg
+	}
+
+	for range 8 {
+		server.Queue <- newEvent("user_1")
+	}
+	if msg := readBackpressure(); !msg.Paused || msg.QueueLength != 8 || msg.QueueCapacity != 10 {
+		t.Errorf("expected pause, got %+v", msg)
+	}
+
+	for range 3 {
+		<-server.Queue
+	}
+	if msg := readBackpressure(); msg.Paused || msg.QueueLength != 5 {
+		t.Errorf("expected resume, got %+v", msg)
+	}
+}
+
+func TestWebSocketAuth(t *testing.T) {
+	server := NewServer(nil, 10)
+	server.WebSocket.APIKeys = []string{"key_1"}
+	ts := httptest.NewServer(NewRouter(server))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/events/ws"
+
+	_, resp, err := websocket.Dial(ctx, url+"?api_key=wrong", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %v", err)
+	}
+
+	conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: http.Header{"X-API-Key": {"key_1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.CloseNow()
+
+	conn, _, err = websocket.Dial(ctx, url+"?api_key=key_1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.CloseNow()
+}
+
+func TestWebSocketIdleTimeout(t *testing.T) {
+	server := NewServer(nil, 10)
+	server.WebSocket.IdleTimeout = 100 * time.Millisecond
+	conn := dialWebSocket(t, server, "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, _, err := conn.Read(ctx)
+	var closeErr websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.StatusPolicyViolation {
+		t.Errorf("expected idle timeout close, got %v", err)
+	}
+}
+
+func TestWebSocketConfigFromEnv(t *testing.T) {
+	t.Setenv("WS_API_KEYS", "key_1, key_2,")
+	t.Setenv("WS_IDLE_TIMEOUT", "30s")
+
+	config, err := WebSocketConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.APIKeys) != 2 || config.APIKeys[1] != "key_2" || config.IdleTimeout != 30*time.Second {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	t.Setenv("WS_IDLE_TIMEOUT", "soon")
+	if _, err := WebSocketConfigFromEnv(); err == nil {
+		t.Error("expected error")
+	}
+}
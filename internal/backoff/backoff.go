@@ -0,0 +1,50 @@
+// Package backoff spaces out the retries of a failing operation, eg: an insert or a delivery.
+package backoff
+
+import (
+	"context"
+	"time"
+)
+
+// Delays of a Backoff whose Initial or Max is not set.
+const (
+	DefaultInitial = time.Second
+	DefaultMax     = 30 * time.Second
+)
+
+// Backoff is the delay before the next retry of an operation, from Initial doubling up to Max.
+// The zero value uses the defaults. A copy of a Backoff not used yet starts a new sequence of retries.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+
+	next time.Duration
+}
+
+// Next returns the delay before the next retry and doubles the one after it.
+func (b *Backoff) Next() time.Duration {
+	if b.next == 0 {
+		b.next = b.Initial
+		if b.next <= 0 {
+			b.next = DefaultInitial
+		}
+	}
+	limit := b.Max
+	if limit <= 0 {
+		limit = DefaultMax
+	}
+
+	d := min(b.next, limit)
+	b.next = min(d*2, limit)
+	return d
+}
+
+// Sleep waits for d and returns false if ctx is done first.
+func Sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,41 @@
+package backoff
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackoffNext(t *testing.T) {
+	var b Backoff
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second}
+	for i, w := range want {
+		if got := b.Next(); got != w {
+			t.Errorf("retry %d: expected %s, got %s", i+1, w, got)
+		}
+	}
+
+	configured := Backoff{Initial: time.Millisecond, Max: 3 * time.Millisecond}
+	retry := configured
+	want = []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond, 3 * time.Millisecond}
+	for i, w := range want {
+		if got := retry.Next(); got != w {
+			t.Errorf("configured retry %d: expected %s, got %s", i+1, w, got)
+		}
+	}
+	if got := configured.Next(); got != time.Millisecond {
+		t.Errorf("expected a copy to start over, got %s", got)
+	}
+}
+
+func TestSleep(t *testing.T) {
+	if !Sleep(context.Background(), time.Millisecond) {
+		t.Error("expected the sleep to complete")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if Sleep(ctx, time.Hour) {
+		t.Error("expected the sleep to stop with ctx")
+	}
+}
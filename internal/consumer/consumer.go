@@ -0,0 +1,398 @@
+// Package consumer reads events from Kafka topics as an alternative ingest source.
+// Events are prepared like on the API, but the consumer writes them itself instead of handing them to the
+// in-memory queue, so offsets are only committed once the events are stored. Delivery is at least once,
+// redelivered events are deduplicated by their dedupe key.
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"fast-ingest/internal/api"
+	"fast-ingest/internal/backoff"
+	"fast-ingest/internal/ingest"
+	"fast-ingest/internal/lists"
+	"fast-ingest/internal/model"
+	"fast-ingest/internal/storage"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Config configures the consumer.
+type Config struct {
+	Brokers []string
+	Topics  []string
+	GroupID string
+
+	// BatchSize and FlushInterval bound how many messages are stored and committed at once, like for the writer.
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// MaxInsertAttempts bounds the failed inserts of a batch while the store is reachable. The batch is then
+	// split to find and skip the messages the store refuses, so they don't block the partition.
+	MaxInsertAttempts int
+}
+
+// ConfigFromEnv reads KAFKA_BROKERS and KAFKA_TOPICS, both comma separated, KAFKA_GROUP_ID (default fast-ingest),
+// KAFKA_BATCH_SIZE (default 500), KAFKA_FLUSH_INTERVAL (default 1s) and KAFKA_MAX_INSERT_ATTEMPTS (default 5).
+// Returns false when no brokers are configured, the consumer is disabled then.
+func ConfigFromEnv() (Config, bool, error) {
+	config := Config{
+		Brokers:           lists.Split(os.Getenv("KAFKA_BROKERS")),
+		Topics:            lists.Split(os.Getenv("KAFKA_TOPICS")),
+		GroupID:           os.Getenv("KAFKA_GROUP_ID"),
+		BatchSize:         500,
+		FlushInterval:     time.Second,
+		MaxInsertAttempts: 5,
+	}
+	if len(config.Brokers) == 0 {
+		return Config{}, false, nil
+	}
+	if config.GroupID == "" {
+		config.GroupID = "fast-ingest"
+	}
+
+	if size := os.Getenv("KAFKA_BATCH_SIZE"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			return Config{}, false, fmt.Errorf("invalid KAFKA_BATCH_SIZE %q", size)
+		}
+		config.BatchSize = n
+	}
+	if interval := os.Getenv("KAFKA_FLUSH_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return Config{}, false, fmt.Errorf("invalid KAFKA_FLUSH_INTERVAL %q", interval)
+		}
+		config.FlushInterval = d
+	}
+	if attempts := os.Getenv("KAFKA_MAX_INSERT_ATTEMPTS"); attempts != "" {
+		n, err := strconv.Atoi(attempts)
+		if err != nil || n <= 0 {
+			return Config{}, false, fmt.Errorf("invalid KAFKA_MAX_INSERT_ATTEMPTS %q", attempts)
+		}
+		config.MaxInsertAttempts = n
+	}
+
+	return config, true, nil
+}
+
+// messageReader is the part of kafka.Reader the consumer uses.
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// Consumer reads JSON events, one per message, in a consumer group. Message headers, such as X-API-Key,
+// are seen by the ingest processors like HTTP headers.
+type Consumer struct {
+	Server *api.Server
+
+	// Sinks receive the events of every batch once they are stored, like from the writer.
+	Sinks []storage.Sink
+
+	// Backoff spaces out the retries of failed fetches and inserts.
+	Backoff backoff.Backoff
+
+	config Config
+	reader messageReader
+	client *kafka.Client
+}
+
+// New returns a consumer of config.Topics. Events are prepared by server and written to its store.
+func New(server *api.Server, config Config) (*Consumer, error) {
+	if len(config.Brokers) == 0 {
+		return nil, errors.New("brokers are required")
+	}
+	if len(config.Topics) == 0 {
+		return nil, errors.New("topics are required")
+	}
+	if config.MaxInsertAttempts <= 0 {
+		config.MaxInsertAttempts = 5
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     config.Brokers,
+		GroupID:     config.GroupID,
+		GroupTopics: config.Topics,
+		StartOffset: kafka.FirstOffset,
+		MaxBytes:    10 * 1024 * 1024,
+		// Commits are synchronous, CommitMessages returns once the offsets are stored
+		CommitInterval: 0,
+	})
+
+	return &Consumer{
+		Server: server,
+		config: config,
+		reader: reader,
+		client: &kafka.Client{Addr: kafka.TCP(config.Brokers...), Timeout: 10 * time.Second},
+	}, nil
+}
+
+// FromEnv returns a consumer configured by ConfigFromEnv, or nil when Kafka isn't configured.
+func FromEnv(server *api.Server) (*Consumer, error) {
+	config, ok, err := ConfigFromEnv()
+	if err != nil || !ok {
+		return nil, err
+	}
+	return New(server, config)
+}
+
+// Run consumes messages until ctx is done. Every batch is stored before its offsets are committed,
+// messages fetched but not committed yet are delivered again after a restart.
+func (c *Consumer) Run(ctx context.Context) {
+	defer c.reader.Close()
+
+	events := make([]model.Event, 0, c.config.BatchSize)
+	// sources holds the message of each event, to report the messages the store refuses
+	sources := make([]kafka.Message, 0, c.config.BatchSize)
+	messages := make([]kafka.Message, 0, c.config.BatchSize)
+	deadline := time.Now().Add(c.config.FlushInterval)
+
+	for {
+		fetchCtx, cancel := context.WithDeadline(ctx, deadline)
+		msg, err := c.reader.FetchMessage(fetchCtx)
+		cancel()
+
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			// Flush interval reached
+		case err != nil:
+			log.Printf("Error fetching Kafka message: %v", err)
+			retry := c.Backoff
+			if !backoff.Sleep(ctx, retry.Next()) {
+				return
+			}
+			continue
+		default:
+			// Skipped messages are committed with the batch, retrying them would fail again
+			messages = append(messages, msg)
+			if e, ok := c.prepare(msg); ok {
+				events = append(events, e)
+				sources = append(sources, msg)
+			}
+			if len(messages) < c.config.BatchSize && time.Now().Before(deadline) {
+				continue
+			}
+		}
+
+		if !c.flush(ctx, events, sources, messages) {
+			return
+		}
+		events, sources, messages = events[:0], sources[:0], messages[:0]
+		deadline = time.Now().Add(c.config.FlushInterval)
+	}
+}
+
+// prepare decodes and prepares, which validates, the event of msg. Returns false if the message is skipped.
+func (c *Consumer) prepare(msg kafka.Message) (model.Event, bool) {
+	var e model.Event
+	dec := json.NewDecoder(bytes.NewReader(msg.Value))
+	dec.DisallowUnknownFields() // Strict decoding to catch unexpected fields
+
+	if err := dec.Decode(&e); err != nil {
+		log.Printf("Skipping invalid Kafka message %s/%d@%d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+		return model.Event{}, false
+	}
+
+	keep, err := c.Server.PrepareEvent(newRequest(msg), &e)
+	if err != nil {
+		log.Printf("Skipping Kafka message %s/%d@%d: %v", msg.Topic, msg.Partition, msg.Offset, err)
+		return model.Event{}, false
+	}
+	return e, keep
+}
+
+// flush stores events, read from sources, then commits messages. Returns false once ctx is done.
+func (c *Consumer) flush(ctx context.Context, events []model.Event, sources []kafka.Message, messages []kafka.Message) bool {
+	if len(messages) == 0 {
+		return true
+	}
+
+	if len(events) > 0 {
+		var ok bool
+		if events, ok = c.insert(ctx, events, sources, c.config.MaxInsertAttempts); !ok {
+			return false
+		}
+	}
+
+	for _, sink := range c.Sinks {
+		if len(events) == 0 {
+			break
+		}
+		if err := sink.Publish(ctx, events); err != nil {
+			log.Printf("Error publishing %d Kafka event(s) to sink %s: %v", len(events), sink.Name(), err)
+		}
+	}
+
+	if err := c.reader.CommitMessages(ctx, messages...); err != nil {
+		// The events are stored, redelivering them only costs a deduplicated insert
+		log.Printf("Error committing %d Kafka message(s): %v", len(messages), err)
+	}
+	return ctx.Err() == nil
+}
+
+// insert stores events, read from sources, and returns the inserted ones, duplicates of stored events left out. Failed inserts are retried, the ones while
+// the store is unreachable don't count towards attempts. Once attempts are used up, the events are split in halves
+// inserted once each, down to single events that are skipped after MaxInsertAttempts.
+// Returns false once ctx is done.
+func (c *Consumer) insert(ctx context.Context, events []model.Event, sources []kafka.Message, attempts int) ([]model.Event, bool) {
+	retry := c.Backoff
+	for {
+		inserted, err := c.Server.Store.InsertEvents(ctx, events)
+		if err == nil {
+			return inserted, true
+		}
+		if ctx.Err() != nil {
+			return nil, false
+		}
+
+		// Outages aren't the events' fault, only failures of a reachable store count
+		if pingErr := c.Server.Store.Ping(ctx); pingErr == nil {
+			attempts--
+		}
+		if attempts <= 0 {
+			log.Printf("Error inserting %d Kafka event(s): %v", len(events), err)
+			break
+		}
+
+		delay := retry.Next()
+		log.Printf("Error inserting %d Kafka event(s), retrying in %s: %v", len(events), delay, err)
+		if !backoff.Sleep(ctx, delay) {
+			return nil, false
+		}
+	}
+
+	if len(events) == 1 {
+		msg := sources[0]
+		log.Printf("Skipping Kafka message %s/%d@%d: the store refuses its event", msg.Topic, msg.Partition, msg.Offset)
+		return nil, true
+	}
+
+	half := len(events) / 2
+	stored, ok := c.insert(ctx, events[:half], sources[:half], c.halfAttempts(half))
+	if !ok {
+		return nil, false
+	}
+	right, ok := c.insert(ctx, events[half:], sources[half:], c.halfAttempts(len(events)-half))
+	if !ok {
+		return nil, false
+	}
+	return slices.Concat(stored, right), true
+}
+
+// halfAttempts returns the attempts of a half of size n of a refused batch. The batch was retried already,
+// a half is inserted once, single events get MaxInsertAttempts before they are skipped.
+func (c *Consumer) halfAttempts(n int) int {
+	if n == 1 {
+		return c.config.MaxInsertAttempts
+	}
+	return 1
+}
+
+// Lag returns how many messages of each partition of the topics the group hasn't committed yet.
+func (c *Consumer) Lag(ctx context.Context) (model.ConsumerLag, error) {
+	metadata, err := c.client.Metadata(ctx, &kafka.MetadataRequest{Topics: c.config.Topics})
+	if err != nil {
+		return model.ConsumerLag{}, err
+	}
+
+	partitions := make(map[string][]int)
+	offsetRequests := make(map[string][]kafka.OffsetRequest)
+	for _, topic := range metadata.Topics {
+		if topic.Error != nil {
+			return model.ConsumerLag{}, fmt.Errorf("topic %s: %w", topic.Name, topic.Error)
+		}
+		for _, partition := range topic.Partitions {
+			partitions[topic.Name] = append(partitions[topic.Name], partition.ID)
+			offsetRequests[topic.Name] = append(offsetRequests[topic.Name], kafka.FirstOffsetOf(partition.ID), kafka.LastOffsetOf(partition.ID))
+		}
+	}
+
+	committed, err := c.client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: c.config.GroupID, Topics: partitions})
+	if err != nil {
+		return model.ConsumerLag{}, err
+	}
+	if committed.Error != nil {
+		return model.ConsumerLag{}, committed.Error
+	}
+
+	offsets, err := c.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: offsetRequests})
+	if err != nil {
+		return model.ConsumerLag{}, err
+	}
+
+	return consumerLag(c.config.GroupID, committed.Topics, offsets.Topics)
+}
+
+// consumerLag joins the committed offsets of the group with the offsets of the partitions.
+func consumerLag(groupID string, committed map[string][]kafka.OffsetFetchPartition, offsets map[string][]kafka.PartitionOffsets) (model.ConsumerLag, error) {
+	lag := model.ConsumerLag{GroupID: groupID, Partitions: []model.PartitionLag{}}
+	for topic, partitions := range offsets {
+		committedOffsets := make(map[int]int64)
+		for _, p := range committed[topic] {
+			if p.Error != nil {
+				return model.ConsumerLag{}, fmt.Errorf("topic %s partition %d: %w", topic, p.Partition, p.Error)
+			}
+			committedOffsets[p.Partition] = p.CommittedOffset
+		}
+
+		for _, p := range partitions {
+			if p.Error != nil {
+				return model.ConsumerLag{}, fmt.Errorf("topic %s partition %d: %w", topic, p.Partition, p.Error)
+			}
+
+			// Without a committed offset the group starts from the first offset
+			offset, ok := committedOffsets[p.Partition]
+			if !ok || offset < 0 {
+				offset = -1
+			}
+			partitionLag := p.LastOffset - max(offset, p.FirstOffset)
+
+			lag.TotalLag += partitionLag
+			lag.Partitions = append(lag.Partitions, model.PartitionLag{
+				Topic:           topic,
+				Partition:       p.Partition,
+				CommittedOffset: offset,
+				EndOffset:       p.LastOffset,
+				Lag:             partitionLag,
+			})
+		}
+	}
+
+	slices.SortFunc(lag.Partitions, func(a, b model.PartitionLag) int {
+		if a.Topic != b.Topic {
+			return strings.Compare(a.Topic, b.Topic)
+		}
+		return a.Partition - b.Partition
+	})
+	return lag, nil
+}
+
+// newRequest describes msg to the ingest processors, its headers become HTTP headers.
+func newRequest(msg kafka.Message) *ingest.Request {
+	req := &ingest.Request{
+		ReceivedAt: msg.Time.UTC(),
+		Header:     http.Header{},
+	}
+	if msg.Time.IsZero() {
+		req.ReceivedAt = time.Now().UTC()
+	}
+	for _, header := range msg.Headers {
+		req.Header.Add(header.Key, string(header.Value))
+	}
+	return req
+}
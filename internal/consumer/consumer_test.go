@@ -0,0 +1,292 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"fast-ingest/internal/api"
+	"fast-ingest/internal/backoff"
+	"fast-ingest/internal/model"
+	"fast-ingest/internal/storage"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeReader serves messages from a channel and records commits, in order with inserts.
+type fakeReader struct {
+	messages chan kafka.Message
+	log      *callLog
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case msg := <-r.messages:
+		return msg, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakeReader) CommitMessages(_ context.Context, msgs ...kafka.Message) error {
+	offsets := make([]int64, 0, len(msgs))
+	for _, msg := range msgs {
+		offsets = append(offsets, msg.Offset)
+	}
+	r.log.add(call{kind: "commit", offsets: offsets})
+	return nil
+}
+
+func (r *fakeReader) Close() error { return nil }
+
+// fakeStore fails the first failures inserts, and every insert with an event of the rejected user.
+type fakeStore struct {
+	storage.Store
+
+	failures int
+	rejected string
+	log      *callLog
+}
+
+func (s *fakeStore) InsertEvents(_ context.Context, events []model.Event) ([]model.Event, error) {
+	if s.failures > 0 {
+		s.failures--
+		s.log.add(call{kind: "failed insert", events: len(events)})
+		return nil, errors.New("database down")
+	}
+	for _, e := range events {
+		if s.rejected != "" && e.UserID == s.rejected {
+			s.log.add(call{kind: "failed insert", events: len(events)})
+			return nil, errors.New("invalid input syntax")
+		}
+	}
+	s.log.add(call{kind: "insert", events: len(events)})
+	return events, nil
+}
+
+func (s *fakeStore) Ping(context.Context) error { return nil }
+
+type call struct {
+	kind    string
+	events  int
+	offsets []int64
+}
+
+type callLog struct {
+	mu    sync.Mutex
+	calls []call
+}
+
+func (l *callLog) add(c call) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls = append(l.calls, c)
+}
+
+func (l *callLog) get() []call {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]call(nil), l.calls...)
+}
+
+// newMessage returns a message holding a purchase of the user.
+func newMessage(offset int64, userID string) kafka.Message {
+	return kafka.Message{
+		Topic:   "events",
+		Offset:  offset,
+		Value:   fmt.Appendf(nil, `{"event_name":"purchase","channel":"web","user_id":%q,"timestamp":1769904000}`, userID),
+		Time:    time.Now(),
+		Headers: []kafka.Header{{Key: "X-API-Key", Value: []byte("key_1")}},
+	}
+}
+
+func runConsumer(t *testing.T, store *fakeStore, messages ...kafka.Message) []call {
+	t.Helper()
+
+	log := &callLog{}
+	reader := &fakeReader{messages: make(chan kafka.Message, len(messages)), log: log}
+	for _, msg := range messages {
+		reader.messages <- msg
+	}
+
+	store.log = log
+	server := api.NewServer(store, 10)
+	c := &Consumer{
+		Server:  server,
+		Backoff: backoff.Backoff{Initial: time.Millisecond},
+		config:  Config{BatchSize: 2, FlushInterval: 50 * time.Millisecond, MaxInsertAttempts: 3},
+		reader:  reader,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	// Wait for every message to be committed
+	deadline := time.After(5 * time.Second)
+	for {
+		committed := 0
+		for _, call := range log.get() {
+			committed += len(call.offsets)
+		}
+		if committed == len(messages) {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("timed out, calls: %+v", log.get())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	<-done
+	return log.get()
+}
+
+func TestRunCommitsAfterInsert(t *testing.T) {
+	calls := runConsumer(t, &fakeStore{},
+		newMessage(0, "user_1"),
+		newMessage(1, "user_2"),
+		newMessage(2, "user_3"),
+	)
+
+	// A full batch of 2, then the last message once the flush interval is reached
+	want := []call{
+		{kind: "insert", events: 2},
+		{kind: "commit", offsets: []int64{0, 1}},
+		{kind: "insert", events: 1},
+		{kind: "commit", offsets: []int64{2}},
+	}
+	assertCalls(t, calls, want)
+}
+
+func TestRunRetriesInsertBeforeCommitting(t *testing.T) {
+	calls := runConsumer(t, &fakeStore{failures: 2},
+		newMessage(0, "user_1"),
+		newMessage(1, "user_2"),
+	)
+
+	want := []call{
+		{kind: "failed insert", events: 2},
+		{kind: "failed insert", events: 2},
+		{kind: "insert", events: 2},
+		{kind: "commit", offsets: []int64{0, 1}},
+	}
+	assertCalls(t, calls, want)
+}
+
+func TestRunSkipsRefusedMessages(t *testing.T) {
+	calls := runConsumer(t, &fakeStore{rejected: "user_2"},
+		newMessage(0, "user_1"),
+		newMessage(1, "user_2"),
+	)
+
+	// The batch is retried, then split, the refused event is skipped after its own attempts
+	want := []call{
+		{kind: "failed insert", events: 2},
+		{kind: "failed insert", events: 2},
+		{kind: "failed insert", events: 2},
+		{kind: "insert", events: 1},
+		{kind: "failed insert", events: 1},
+		{kind: "failed insert", events: 1},
+		{kind: "failed insert", events: 1},
+		{kind: "commit", offsets: []int64{0, 1}},
+	}
+	assertCalls(t, calls, want)
+}
+
+func TestRunSkipsInvalidMessages(t *testing.T) {
+	calls := runConsumer(t, &fakeStore{},
+		kafka.Message{Offset: 0, Value: []byte(`{"event_name":"purchase","unexpected":true}`)},
+		newMessage(1, ""),
+	)
+
+	// Nothing to insert, the messages are still committed so they aren't retried forever
+	want := []call{
+		{kind: "commit", offsets: []int64{0, 1}},
+	}
+	assertCalls(t, calls, want)
+}
+
+func assertCalls(t *testing.T, got, want []call) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got calls %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].kind != want[i].kind || got[i].events != want[i].events || len(got[i].offsets) != len(want[i].offsets) {
+			t.Fatalf("call %d: got %+v, want %+v", i, got[i], want[i])
+		}
+		for j := range want[i].offsets {
+			if got[i].offsets[j] != want[i].offsets[j] {
+				t.Fatalf("call %d: got %+v, want %+v", i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	msg := newMessage(0, "user_1")
+	req := newRequest(msg)
+
+	if req.Header.Get("X-API-Key") != "key_1" || !req.ReceivedAt.Equal(msg.Time) {
+		t.Errorf("unexpected request %+v", req)
+	}
+}
+
+func TestConsumerLag(t *testing.T) {
+	committed := map[string][]kafka.OffsetFetchPartition{
+		"events": {{Partition: 0, CommittedOffset: 90}, {Partition: 1, CommittedOffset: -1}},
+	}
+	offsets := map[string][]kafka.PartitionOffsets{
+		"events": {
+			{Partition: 1, FirstOffset: 10, LastOffset: 25},
+			{Partition: 0, FirstOffset: 0, LastOffset: 100},
+		},
+	}
+
+	lag, err := consumerLag("fast-ingest", committed, offsets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lag.TotalLag != 25 || len(lag.Partitions) != 2 {
+		t.Fatalf("unexpected lag %+v", lag)
+	}
+	if p := lag.Partitions[0]; p.Partition != 0 || p.Lag != 10 || p.CommittedOffset != 90 {
+		t.Errorf("unexpected partition lag %+v", p)
+	}
+	// Nothing committed yet, the group starts from the first offset
+	if p := lag.Partitions[1]; p.Partition != 1 || p.Lag != 15 || p.CommittedOffset != -1 {
+		t.Errorf("unexpected partition lag %+v", p)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("KAFKA_BROKERS", "")
+	if _, ok, err := ConfigFromEnv(); ok || err != nil {
+		t.Fatalf("expected the consumer to be disabled, got %v, %v", ok, err)
+	}
+
+	t.Setenv("KAFKA_BROKERS", "localhost:19092, redpanda:9092")
+	t.Setenv("KAFKA_TOPICS", "events")
+	config, ok, err := ConfigFromEnv()
+	if err != nil || !ok {
+		t.Fatalf("expected the consumer to be enabled, got %v, %v", ok, err)
+	}
+	if len(config.Brokers) != 2 || config.GroupID != "fast-ingest" || config.BatchSize != 500 {
+		t.Errorf("unexpected config %+v", config)
+	}
+
+	t.Setenv("KAFKA_BATCH_SIZE", "0")
+	if _, _, err := ConfigFromEnv(); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,196 @@
+// Package export encodes stored events as NDJSON, CSV or Parquet, one row at a time.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+
+	"fast-ingest/internal/model"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Supported export formats.
+const (
+	FormatNDJSON  = "ndjson"
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// contentTypes maps each format onto its media type.
+var contentTypes = map[string]string{
+	FormatNDJSON:  "application/x-ndjson",
+	FormatCSV:     "text/csv",
+	FormatParquet: "application/vnd.apache.parquet",
+}
+
+// parquetRowGroupSize bounds how many rows the Parquet writer buffers before flushing a row group.
+const parquetRowGroupSize = 10000
+
+// IsFormat reports whether format is a supported export format.
+func IsFormat(format string) bool {
+	_, ok := contentTypes[format]
+	return ok
+}
+
+// FormatFromAccept picks the first supported format of an Accept header, defaulting to NDJSON.
+// Returns false if the header only lists unsupported media types.
+func FormatFromAccept(accept string) (string, bool) {
+	if accept == "" {
+		return FormatNDJSON, true
+	}
+
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if mediaType == "*/*" || mediaType == "application/*" {
+			return FormatNDJSON, true
+		}
+		for format, contentType := range contentTypes {
+			if mediaType == contentType {
+				return format, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// ContentType returns the media type of format.
+func ContentType(format string) string {
+	return contentTypes[format]
+}
+
+// Compressible reports whether the output of format benefits from gzip. Parquet is compressed internally.
+func Compressible(format string) bool {
+	return format != FormatParquet
+}
+
+// Writer encodes events into an export. Close must be called to flush the output.
+type Writer interface {
+	Write(e model.StoredEvent) error
+	Close() error
+}
+
+// NewWriter returns a Writer producing format into w.
+func NewWriter(format string, w io.Writer) Writer {
+	switch format {
+	case FormatCSV:
+		return newCSVWriter(w)
+	case FormatParquet:
+		return newParquetWriter(w)
+	}
+	return &ndjsonWriter{enc: json.NewEncoder(w)}
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(e model.StoredEvent) error { return n.enc.Encode(e) }
+
+func (n *ndjsonWriter) Close() error { return nil }
+
+// csvColumns are the CSV header. Tags and metadata are written as JSON.
+var csvColumns = []string{"id", "dedupe_key", "event_name", "channel", "campaign_id", "user_id", "timestamp", "tags", "metadata", "ingested_at"}
+
+type csvWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func newCSVWriter(w io.Writer) *csvWriter {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) Write(e model.StoredEvent) error {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		if err := c.w.Write(csvColumns); err != nil {
+			return err
+		}
+	}
+
+	tags, _ := json.Marshal(e.Tags)
+	metadata, _ := json.Marshal(e.Metadata)
+	return c.w.Write([]string{
+		strconv.FormatInt(e.ID, 10),
+		e.DedupeKey,
+		e.EventName,
+		e.Channel,
+		e.CampaignID,
+		e.UserID,
+		strconv.FormatInt(e.Timestamp, 10),
+		string(tags),
+		string(metadata),
+		e.IngestedAt,
+	})
+}
+
+func (c *csvWriter) Close() error {
+	// An empty export still gets its header
+	if !c.wroteHeader {
+		if err := c.w.Write(csvColumns); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// parquetRow is the Parquet schema of an exported event.
+type parquetRow struct {
+	ID         int64     `parquet:"id"`
+	DedupeKey  string    `parquet:"dedupe_key"`
+	EventName  string    `parquet:"event_name,dict"`
+	Channel    string    `parquet:"channel,dict"`
+	CampaignID string    `parquet:"campaign_id,dict"`
+	UserID     string    `parquet:"user_id"`
+	Timestamp  time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	Tags       []string  `parquet:"tags,list"`
+	Metadata   string    `parquet:"metadata,json"`
+	IngestedAt time.Time `parquet:"ingested_at,timestamp(microsecond)"`
+}
+
+type parquetWriter struct {
+	w   *parquet.GenericWriter[parquetRow]
+	row [1]parquetRow
+}
+
+func newParquetWriter(w io.Writer) *parquetWriter {
+	return &parquetWriter{
+		w: parquet.NewGenericWriter[parquetRow](w,
+			parquet.Compression(&parquet.Zstd),
+			parquet.MaxRowsPerRowGroup(parquetRowGroupSize),
+		),
+	}
+}
+
+func (p *parquetWriter) Write(e model.StoredEvent) error {
+	metadata, _ := json.Marshal(e.Metadata)
+	ingestedAt, _ := time.Parse(time.RFC3339, e.IngestedAt)
+
+	p.row[0] = parquetRow{
+		ID:         e.ID,
+		DedupeKey:  e.DedupeKey,
+		EventName:  e.EventName,
+		Channel:    e.Channel,
+		CampaignID: e.CampaignID,
+		UserID:     e.UserID,
+		Timestamp:  time.Unix(e.Timestamp, 0).UTC(),
+		Tags:       e.Tags,
+		Metadata:   string(metadata),
+		IngestedAt: ingestedAt,
+	}
+	_, err := p.w.Write(p.row[:])
+	return err
+}
+
+func (p *parquetWriter) Close() error { return p.w.Close() }
@@ -0,0 +1,101 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"fast-ingest/internal/model"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+var testEvents = []model.StoredEvent{
+	{ID: 1, DedupeKey: "k1", EventName: "page_view", Channel: "web", UserID: "user_1", Timestamp: 1769904000, Tags: []string{"a"}, Metadata: map[string]any{"plan": "pro"}, IngestedAt: "2026-02-01T00:00:01Z"},
+	{ID: 2, DedupeKey: "k2", EventName: "purchase", Channel: "mobile", CampaignID: "spring", UserID: "user_2", Timestamp: 1769904060, IngestedAt: "2026-02-01T00:01:01Z"},
+}
+
+func writeAll(t *testing.T, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(format, &buf)
+	for _, e := range testEvents {
+		if err := w.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFormatFromAccept(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+		ok     bool
+	}{
+		{"", FormatNDJSON, true},
+		{"*/*", FormatNDJSON, true},
+		{"text/csv", FormatCSV, true},
+		{"application/vnd.apache.parquet", FormatParquet, true},
+		{"text/html, text/csv;q=0.9", FormatCSV, true},
+		{"text/html", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := FormatFromAccept(tt.accept)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("FormatFromAccept(%q) = %q, %v; expected %q, %v", tt.accept, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(string(writeAll(t, FormatNDJSON))), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"campaign_id":"spring"`) {
+		t.Errorf("unexpected output %q", lines)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	lines := strings.Split(strings.TrimSpace(string(writeAll(t, FormatCSV))), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %q", lines)
+	}
+	if lines[0] != strings.Join(csvColumns, ",") {
+		t.Errorf("unexpected header %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], `1,k1,page_view,web,,user_1,1769904000,"[""a""]","{""plan"":""pro""}"`) {
+		t.Errorf("unexpected row %q", lines[1])
+	}
+
+	t.Run("empty export has a header", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := NewWriter(FormatCSV, &buf).Close(); err != nil {
+			t.Fatal(err)
+		}
+		if strings.TrimSpace(buf.String()) != strings.Join(csvColumns, ",") {
+			t.Errorf("unexpected output %q", buf.String())
+		}
+	})
+}
+
+func TestParquet(t *testing.T) {
+	data := writeAll(t, FormatParquet)
+
+	rows, err := parquet.Read[parquetRow](bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	if rows[0].EventName != "page_view" || rows[0].Timestamp.Unix() != 1769904000 || rows[0].Metadata != `{"plan":"pro"}` {
+		t.Errorf("unexpected row %+v", rows[0])
+	}
+	if rows[1].CampaignID != "spring" || len(rows[1].Tags) != 0 {
+		t.Errorf("unexpected row %+v", rows[1])
+	}
+}
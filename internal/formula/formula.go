@@ -0,0 +1,294 @@
+// Package formula parses and evaluates arithmetic formulas over event metrics,
+// eg: purchase / page_view or unique(purchase) / unique(page_view).
+package formula
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Ref is a metric referenced by a formula: the event count of an event name,
+// or its unique user count when wrapped in unique().
+type Ref struct {
+	Event  string
+	Unique bool
+}
+
+// Expr is a parsed formula.
+type Expr struct {
+	root node
+	refs []Ref
+}
+
+// Parse parses a formula made of event names, unique(event_name), numbers, + - * / and parentheses.
+func Parse(src string) (*Expr, error) {
+	p := &parser{src: src}
+	p.next()
+
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.tok.text, p.tok.pos)
+	}
+
+	return &Expr{root: root, refs: p.refs}, nil
+}
+
+// Refs returns the metrics the formula depends on, in order of first appearance.
+func (e *Expr) Refs() []Ref {
+	return e.refs
+}
+
+// Eval evaluates the formula using lookup to resolve references.
+// Returns false if the result is undefined, eg: on division by zero.
+func (e *Expr) Eval(lookup func(Ref) float64) (float64, bool) {
+	return e.root.eval(lookup)
+}
+
+type node interface {
+	eval(lookup func(Ref) float64) (float64, bool)
+}
+
+type numberNode float64
+
+func (n numberNode) eval(func(Ref) float64) (float64, bool) { return float64(n), true }
+
+type refNode Ref
+
+func (n refNode) eval(lookup func(Ref) float64) (float64, bool) { return lookup(Ref(n)), true }
+
+type negNode struct{ operand node }
+
+func (n negNode) eval(lookup func(Ref) float64) (float64, bool) {
+	v, ok := n.operand.eval(lookup)
+	return -v, ok
+}
+
+type binaryNode struct {
+	op          byte
+	left, right node
+}
+
+func (n binaryNode) eval(lookup func(Ref) float64) (float64, bool) {
+	l, ok := n.left.eval(lookup)
+	if !ok {
+		return 0, false
+	}
+	r, ok := n.right.eval(lookup)
+	if !ok {
+		return 0, false
+	}
+
+	switch n.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	case '/':
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	}
+	return 0, false
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type parser struct {
+	src  string
+	pos  int
+	tok  token
+	refs []Ref
+}
+
+// next advances to the next token.
+func (p *parser) next() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: p.pos}
+		return
+	}
+
+	start := p.pos
+	c := rune(p.src[p.pos])
+	switch {
+	case unicode.IsDigit(c) || c == '.':
+		for p.pos < len(p.src) && (unicode.IsDigit(rune(p.src[p.pos])) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		p.tok = token{kind: tokNumber, text: p.src[start:p.pos], pos: start}
+	case unicode.IsLetter(c) || c == '_':
+		for p.pos < len(p.src) && isIdentChar(rune(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.src[start:p.pos], pos: start}
+	default:
+		p.pos++
+		p.tok = token{kind: tokOp, text: p.src[start:p.pos], pos: start}
+	}
+}
+
+func isIdentChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.'
+}
+
+// parseExpr parses term {(+|-) term}.
+func (p *parser) parseExpr() (node, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && (p.tok.text == "+" || p.tok.text == "-") {
+		op := p.tok.text[0]
+		p.next()
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseTerm parses factor {(*|/) factor}.
+func (p *parser) parseTerm() (node, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && (p.tok.text == "*" || p.tok.text == "/") {
+		op := p.tok.text[0]
+		p.next()
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseFactor parses a number, an event name, unique(event_name), a parenthesized expression or a negation.
+func (p *parser) parseFactor() (node, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokNumber:
+		v, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at position %d", tok.text, tok.pos)
+		}
+		p.next()
+		return numberNode(v), nil
+
+	case tok.kind == tokIdent && tok.text == "unique":
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokIdent {
+			return nil, fmt.Errorf("expected event name at position %d", p.tok.pos)
+		}
+		ref := p.addRef(Ref{Event: p.tok.text, Unique: true})
+		p.next()
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return refNode(ref), nil
+
+	case tok.kind == tokIdent:
+		p.next()
+		return refNode(p.addRef(Ref{Event: tok.text})), nil
+
+	case tok.kind == tokOp && tok.text == "(":
+		p.next()
+		inner, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+
+	case tok.kind == tokOp && tok.text == "-":
+		p.next()
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return negNode{operand: operand}, nil
+
+	case tok.kind == tokEOF:
+		return nil, fmt.Errorf("unexpected end of formula")
+	}
+
+	return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+}
+
+func (p *parser) expect(op string) error {
+	if p.tok.kind != tokOp || p.tok.text != op {
+		return fmt.Errorf("expected %q at position %d", op, p.tok.pos)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) addRef(ref Ref) Ref {
+	for _, existing := range p.refs {
+		if existing == ref {
+			return ref
+		}
+	}
+	p.refs = append(p.refs, ref)
+	return ref
+}
+
+// String renders a reference the way it is written in a formula.
+func (r Ref) String() string {
+	if r.Unique {
+		return "unique(" + r.Event + ")"
+	}
+	return r.Event
+}
+
+// Events returns the distinct event names referenced by the formula.
+func (e *Expr) Events() []string {
+	var events []string
+	seen := make(map[string]bool)
+	for _, ref := range e.refs {
+		if !seen[ref.Event] {
+			seen[ref.Event] = true
+			events = append(events, ref.Event)
+		}
+	}
+	return events
+}
+
+// ValidName reports whether name can be used as a formula name: letters, digits and underscores.
+func ValidName(name string) bool {
+	return name != "" && strings.IndexFunc(name, func(c rune) bool {
+		return !(unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_')
+	}) == -1
+}
@@ -0,0 +1,96 @@
+package formula
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseAndEval(t *testing.T) {
+	values := map[Ref]float64{
+		{Event: "purchase"}:                20,
+		{Event: "page_view"}:               200,
+		{Event: "purchase", Unique: true}:  10,
+		{Event: "page_view", Unique: true}: 50,
+		{Event: "app.open"}:                5,
+		{Event: "signup_v2"}:               0,
+	}
+	lookup := func(ref Ref) float64 { return values[ref] }
+
+	tests := []struct {
+		name   string
+		src    string
+		want   float64
+		wantOK bool
+	}{
+		{"ratio", "purchase / page_view", 0.1, true},
+		{"unique ratio", "unique(purchase)/unique(page_view)", 0.2, true},
+		{"difference", "page_view - purchase", 180, true},
+		{"precedence", "purchase + page_view * 2", 420, true},
+		{"parentheses", "(purchase + page_view) * 2", 440, true},
+		{"percentage", "100 * purchase / page_view", 10, true},
+		{"negation", "-purchase + 30", 10, true},
+		{"dotted event name", "app.open * 2", 10, true},
+		{"division by zero is undefined", "purchase / signup_v2", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Parse(tt.src)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, ok := expr.Eval(lookup)
+			if ok != tt.wantOK {
+				t.Fatalf("expected ok=%v, got %v", tt.wantOK, ok)
+			}
+			if ok && math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"purchase /",
+		"(purchase",
+		"purchase page_view",
+		"unique(1)",
+		"unique purchase",
+		"purchase % 2",
+	} {
+		t.Run(src, func(t *testing.T) {
+			if _, err := Parse(src); err == nil {
+				t.Errorf("expected error for %q", src)
+			}
+		})
+	}
+}
+
+func TestRefsAndEvents(t *testing.T) {
+	expr, err := Parse("unique(purchase) / unique(page_view) + purchase / page_view + purchase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(expr.Refs()) != 4 {
+		t.Errorf("expected 4 distinct refs, got %v", expr.Refs())
+	}
+
+	events := expr.Events()
+	if len(events) != 2 || events[0] != "purchase" || events[1] != "page_view" {
+		t.Errorf("unexpected events %v", events)
+	}
+}
+
+func TestValidName(t *testing.T) {
+	if !ValidName("conversion_rate") {
+		t.Error("expected conversion_rate to be valid")
+	}
+	for _, name := range []string{"", "conversion rate", "rate:1"} {
+		if ValidName(name) {
+			t.Errorf("expected %q to be invalid", name)
+		}
+	}
+}
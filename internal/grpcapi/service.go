@@ -0,0 +1,156 @@
+// Package grpcapi serves the gRPC ingest service. It shares validation, enrichment and the queue with the HTTP API.
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"fast-ingest/internal/api"
+	"fast-ingest/internal/ingest"
+	"fast-ingest/internal/ingestpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultMaxStreamEvents is the MaxStreamEvents of services created by NewGRPCServer.
+	DefaultMaxStreamEvents = 10000
+
+	// retryAfter is the back off suggested when the queue is full, like the Retry-After of the HTTP API.
+	retryAfter = time.Second
+)
+
+// Service implements ingestpb.IngestServiceServer on top of an api.Server.
+type Service struct {
+	ingestpb.UnimplementedIngestServiceServer
+
+	Server *api.Server
+
+	// MaxStreamEvents caps the events of a single IngestStream, so the results held until it ends stay bounded.
+	MaxStreamEvents int
+}
+
+// NewService returns the ingest service of server with the default limits.
+func NewService(server *api.Server) *Service {
+	return &Service{Server: server, MaxStreamEvents: DefaultMaxStreamEvents}
+}
+
+// NewGRPCServer returns a gRPC server with the ingest service registered.
+func NewGRPCServer(server *api.Server, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	ingestpb.RegisterIngestServiceServer(s, NewService(server))
+	return s
+}
+
+// Ingest queues a single event.
+func (s *Service) Ingest(ctx context.Context, in *ingestpb.IngestRequest) (*ingestpb.IngestResponse, error) {
+	result := s.ingest(newRequest(ctx), in.GetEvent())
+
+	resp := &ingestpb.IngestResponse{Result: result}
+	if result.Status == ingestpb.Status_STATUS_QUEUE_FULL {
+		resp.RetryAfterMs = retryAfter.Milliseconds()
+	}
+	return resp, nil
+}
+
+// IngestBatch queues every event of the batch independently, so one bad event doesn't fail the others.
+func (s *Service) IngestBatch(ctx context.Context, in *ingestpb.IngestBatchRequest) (*ingestpb.IngestBatchResponse, error) {
+	if len(in.GetEvents()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "events is required")
+	}
+	// Same limit as POST /events/bulk
+	if len(in.GetEvents()) > api.MaxBatchEvents {
+		return nil, status.Errorf(codes.InvalidArgument, "too many events (max %d)", api.MaxBatchEvents)
+	}
+
+	req := newRequest(ctx)
+	resp := &ingestpb.IngestBatchResponse{Results: make([]*ingestpb.EventResult, 0, len(in.GetEvents()))}
+	for _, event := range in.GetEvents() {
+		addResult(resp, s.ingest(req, event))
+	}
+	return resp, nil
+}
+
+// IngestStream queues events as they arrive and answers with their results once the client closes the stream.
+// The stream is answered early, and events sent after its last result are not read, once the queue is full
+// or after MaxStreamEvents events. The client sends those again, on a new stream.
+func (s *Service) IngestStream(stream grpc.ClientStreamingServer[ingestpb.IngestRequest, ingestpb.IngestBatchResponse]) error {
+	req := newRequest(stream.Context())
+	resp := &ingestpb.IngestBatchResponse{}
+	for {
+		in, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(resp)
+		}
+		if err != nil {
+			return err
+		}
+
+		// Streams can stay open for long, every event gets its own receive time
+		eventReq := *req
+		eventReq.ReceivedAt = time.Now().UTC()
+		result := s.ingest(&eventReq, in.GetEvent())
+		addResult(resp, result)
+
+		// Reading on would only queue up results for events the client has to send again
+		if result.Status == ingestpb.Status_STATUS_QUEUE_FULL || len(resp.Results) >= s.MaxStreamEvents {
+			return stream.SendAndClose(resp)
+		}
+	}
+}
+
+// ingest validates, prepares and queues a single event.
+func (s *Service) ingest(req *ingest.Request, event *ingestpb.Event) *ingestpb.EventResult {
+	e := event.ToModel()
+	keep, err := s.Server.PrepareEvent(req, &e)
+	if errors.Is(err, api.ErrMissingFields) {
+		return &ingestpb.EventResult{Status: ingestpb.Status_STATUS_INVALID, Error: err.Error()}
+	}
+	if err != nil {
+		return &ingestpb.EventResult{Status: ingestpb.Status_STATUS_REJECTED, Error: err.Error()}
+	}
+	if !keep {
+		return &ingestpb.EventResult{Status: ingestpb.Status_STATUS_DROPPED}
+	}
+
+	if !s.Server.Enqueue(e) {
+		return &ingestpb.EventResult{Status: ingestpb.Status_STATUS_QUEUE_FULL, Error: "ingest queue full"}
+	}
+	return &ingestpb.EventResult{Status: ingestpb.Status_STATUS_ACCEPTED}
+}
+
+func addResult(resp *ingestpb.IngestBatchResponse, result *ingestpb.EventResult) {
+	resp.Results = append(resp.Results, result)
+	switch result.Status {
+	case ingestpb.Status_STATUS_ACCEPTED:
+		resp.Accepted++
+	case ingestpb.Status_STATUS_QUEUE_FULL:
+		resp.RetryAfterMs = retryAfter.Milliseconds()
+	}
+}
+
+// newRequest describes the call to the ingest processors the way an HTTP request would, eg: user-agent and x-api-key metadata become headers.
+func newRequest(ctx context.Context) *ingest.Request {
+	req := &ingest.Request{
+		ReceivedAt: time.Now().UTC(),
+		Header:     http.Header{},
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		req.RemoteAddr = p.Addr.String()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for key, values := range md {
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+	}
+	return req
+}
@@ -0,0 +1,179 @@
+package grpcapi
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"fast-ingest/internal/api"
+	"fast-ingest/internal/ingestpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func newClient(t *testing.T, queueSize int) (ingestpb.IngestServiceClient, *api.Server) {
+	t.Helper()
+
+	server := api.NewServer(nil, queueSize)
+	return serve(t, NewService(server)), server
+}
+
+// serve registers service on an in-memory gRPC server and returns a client of it.
+func serve(t *testing.T, service *Service) ingestpb.IngestServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	ingestpb.RegisterIngestServiceServer(s, service)
+	go func() { _ = s.Serve(lis) }()
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return ingestpb.NewIngestServiceClient(conn)
+}
+
+func newEvent(userID string) *ingestpb.Event {
+	return &ingestpb.Event{EventName: "purchase", Channel: "web", UserId: userID, Timestamp: 1769904000}
+}
+
+func TestIngest(t *testing.T) {
+	client, server := newClient(t, 1)
+	ctx := context.Background()
+
+	event := newEvent("user_1")
+	event.Tags = []string{"promo"}
+	event.Metadata, _ = structpb.NewStruct(map[string]any{"plan": "pro", "seats": 3})
+
+	resp, err := client.Ingest(ctx, &ingestpb.IngestRequest{Event: event})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetResult().GetStatus() != ingestpb.Status_STATUS_ACCEPTED || resp.GetRetryAfterMs() != 0 {
+		t.Errorf("unexpected response %v", resp)
+	}
+
+	e := <-server.Queue
+	if e.UserID != "user_1" || e.Metadata["seats"] != float64(3) || e.Tags[0] != "promo" {
+		t.Errorf("unexpected queued event %+v", e)
+	}
+
+	resp, err = client.Ingest(ctx, &ingestpb.IngestRequest{Event: &ingestpb.Event{EventName: "purchase"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetResult().GetStatus() != ingestpb.Status_STATUS_INVALID {
+		t.Errorf("expected invalid, got %v", resp)
+	}
+}
+
+func TestIngestBatch(t *testing.T) {
+	client, _ := newClient(t, 2)
+	ctx := context.Background()
+
+	resp, err := client.IngestBatch(ctx, &ingestpb.IngestBatchRequest{Events: []*ingestpb.Event{
+		newEvent("user_1"),
+		{EventName: "purchase"},
+		newEvent("user_2"),
+		newEvent("user_3"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ingestpb.Status{
+		ingestpb.Status_STATUS_ACCEPTED,
+		ingestpb.Status_STATUS_INVALID,
+		ingestpb.Status_STATUS_ACCEPTED,
+		ingestpb.Status_STATUS_QUEUE_FULL,
+	}
+	for i, result := range resp.GetResults() {
+		if result.GetStatus() != want[i] {
+			t.Errorf("event %d: expected %v, got %v", i, want[i], result.GetStatus())
+		}
+	}
+	if resp.GetAccepted() != 2 || resp.GetRetryAfterMs() == 0 {
+		t.Errorf("unexpected response %v", resp)
+	}
+
+	_, err = client.IngestBatch(ctx, &ingestpb.IngestBatchRequest{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected InvalidArgument for an empty batch, got %v", err)
+	}
+}
+
+func TestIngestStream(t *testing.T) {
+	client, server := newClient(t, 10)
+
+	stream, err := client.IngestStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range []string{"user_1", "user_2", ""} {
+		if err := stream.Send(&ingestpb.IngestRequest{Event: newEvent(userID)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetResults()) != 3 || resp.GetAccepted() != 2 || resp.GetResults()[2].GetStatus() != ingestpb.Status_STATUS_INVALID {
+		t.Errorf("unexpected response %v", resp)
+	}
+	if len(server.Queue) != 2 {
+		t.Errorf("expected 2 queued events, got %d", len(server.Queue))
+	}
+}
+
+func TestIngestStreamEndsEarly(t *testing.T) {
+	tests := []struct {
+		name      string
+		queueSize int
+		maxEvents int
+		want      int
+	}{
+		{"queue full", 2, 100, 3},
+		{"too many events", 10, 2, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(api.NewServer(nil, tt.queueSize))
+			service.MaxStreamEvents = tt.maxEvents
+			client := serve(t, service)
+
+			stream, err := client.IngestStream(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Sends fail with io.EOF once the server answered, the response tells how far it read
+			for i := 0; i < 5; i++ {
+				if err := stream.Send(&ingestpb.IngestRequest{Event: newEvent("user_1")}); err != nil {
+					break
+				}
+			}
+
+			resp, err := stream.CloseAndRecv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.GetResults()) != tt.want {
+				t.Errorf("expected %d results, got %v", tt.want, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package ingest
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"fast-ingest/internal/model"
+
+	"github.com/mileusna/useragent"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// receivedAt records when the server received the event, next to the client's timestamp.
+func receivedAt(req *Request, e *model.Event) {
+	enriched(e)["received_at"] = req.ReceivedAt.Format(time.RFC3339Nano)
+}
+
+// clientIPs resolves the IP of the client, looking through trusted proxies.
+type clientIPs struct {
+	trusted []netip.Prefix
+}
+
+func (c *clientIPs) Process(req *Request, e *model.Event) {
+	if ip, ok := c.resolve(req); ok {
+		enriched(e)["ip"] = ip.String()
+	}
+}
+
+// resolve returns the client IP of req. X-Forwarded-For is walked from the right, skipping trusted proxies,
+// and only while the hop that added it is trusted, so clients can't spoof their IP.
+func (c *clientIPs) resolve(req *Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	ip = ip.Unmap()
+
+	if !c.isTrusted(ip) {
+		return ip, true
+	}
+
+	var hops []string
+	for _, header := range req.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+		if !c.isTrusted(ip) {
+			break
+		}
+	}
+	return ip, true
+}
+
+func (c *clientIPs) isTrusted(ip netip.Addr) bool {
+	for _, prefix := range c.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// userAgent parses the User-Agent header into device, OS and browser.
+func userAgent(req *Request, e *model.Event) {
+	header := req.Header.Get("User-Agent")
+	if header == "" {
+		return
+	}
+
+	ua := useragent.Parse(header)
+	m := enriched(e)
+	m["device"] = deviceType(ua)
+	setIfNotEmpty(m, "os", ua.OS)
+	setIfNotEmpty(m, "os_version", ua.OSVersion)
+	setIfNotEmpty(m, "browser", ua.Name)
+	setIfNotEmpty(m, "browser_version", ua.Version)
+}
+
+func deviceType(ua useragent.UserAgent) string {
+	switch {
+	case ua.Bot:
+		return "bot"
+	case ua.Tablet:
+		return "tablet"
+	case ua.Mobile:
+		return "mobile"
+	case ua.Desktop:
+		return "desktop"
+	}
+	return "unknown"
+}
+
+// geoIP looks the client IP up in a MaxMind database.
+type geoIP struct {
+	db  *maxminddb.Reader
+	ips *clientIPs
+}
+
+// geoRecord holds the fields read from city and country databases alike.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+}
+
+func openGeoIP(path string, ips *clientIPs) (*geoIP, error) {
+	if path == "" {
+		return nil, fmt.Errorf("geoip requires geoip_database")
+	}
+
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open geoip database: %w", err)
+	}
+	return &geoIP{db: db, ips: ips}, nil
+}
+
+func (g *geoIP) Process(req *Request, e *model.Event) {
+	ip, ok := g.ips.resolve(req)
+	if !ok {
+		return
+	}
+
+	var record geoRecord
+	if err := g.db.Lookup(ip.AsSlice(), &record); err != nil || record.Country.ISOCode == "" {
+		return
+	}
+
+	m := enriched(e)
+	m["country"] = record.Country.ISOCode
+	if len(record.Subdivisions) > 0 {
+		setIfNotEmpty(m, "region", record.Subdivisions[0].ISOCode)
+	}
+	setIfNotEmpty(m, "city", record.City.Names["en"])
+}
+
+func (g *geoIP) Close() error { return g.db.Close() }
+
+// apiKeys adds the static metadata of the X-API-Key the event was sent with.
+func apiKeys(keys map[string]map[string]any) ProcessorFunc {
+	return func(req *Request, e *model.Event) {
+		metadata, ok := keys[req.Header.Get("X-API-Key")]
+		if !ok {
+			return
+		}
+		maps.Copy(enriched(e), metadata)
+	}
+}
+
+func setIfNotEmpty(m map[string]any, key, value string) {
+	if value != "" {
+		m[key] = value
+	}
+}
@@ -0,0 +1,207 @@
+// Package ingest runs events through processors between decoding and queueing,
+// eg: to enrich them with the client IP, user agent or location of the request.
+package ingest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"time"
+
+	"fast-ingest/internal/model"
+)
+
+// EnrichedKey is the metadata key enriched fields are written under, eg: metadata._enriched.ip
+const EnrichedKey = "_enriched"
+
+// DefaultChain is the events key of the chain used by event names without their own chain.
+const DefaultChain = "*"
+
+// Request is what processors know about the request an event came in with.
+type Request struct {
+	ReceivedAt time.Time
+	RemoteAddr string
+	Header     http.Header
+}
+
+// NewRequest captures an HTTP request, received now.
+func NewRequest(r *http.Request) *Request {
+	return &Request{
+		ReceivedAt: time.Now().UTC(),
+		RemoteAddr: r.RemoteAddr,
+		Header:     r.Header,
+	}
+}
+
+// SetAPIKey sets the X-API-Key processors see, eg: for a key that didn't come as a header.
+// The header is copied first, it is shared with the HTTP request.
+func (r *Request) SetAPIKey(key string) {
+	header := r.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("X-API-Key", key)
+	r.Header = header
+}
+
+// Processor enriches an event. Processors never fail an event, missing data is skipped.
+type Processor interface {
+	Process(req *Request, e *model.Event)
+}
+
+// ProcessorFunc adapts a function to a Processor.
+type ProcessorFunc func(req *Request, e *model.Event)
+
+func (f ProcessorFunc) Process(req *Request, e *model.Event) { f(req, e) }
+
+// Config is the JSON configuration of a Pipeline, eg:
+//
+//	{
+//	  "trusted_proxies": ["10.0.0.0/8"],
+//	  "geoip_database": "GeoLite2-City.mmdb",
+//	  "api_keys": {"ios-key": {"app": "ios"}},
+//	  "events": {"*": ["received_at", "client_ip", "user_agent"], "purchase": ["received_at", "client_ip", "geoip", "api_key"]}
+//	}
+type Config struct {
+	// TrustedProxies are the networks whose X-Forwarded-For header is believed.
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	// GeoIPDatabase is the path of a MaxMind-format (.mmdb) city or country database.
+	GeoIPDatabase string `json:"geoip_database"`
+
+	// APIKeys maps each X-API-Key value onto the static metadata its events get.
+	APIKeys map[string]map[string]any `json:"api_keys"`
+
+	// Events maps event names, or * for every other event, onto their processors.
+	Events map[string][]string `json:"events"`
+}
+
+// Pipeline runs the processor chain configured for each event name. It is safe for concurrent use.
+type Pipeline struct {
+	chains map[string][]Processor
+	closer func() error
+}
+
+// New builds a pipeline from config.
+func New(config Config) (*Pipeline, error) {
+	ips := &clientIPs{}
+	for _, cidr := range config.TrustedProxies {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			// A single address is trusted on its own
+			addr, addrErr := netip.ParseAddr(cidr)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		ips.trusted = append(ips.trusted, prefix.Masked())
+	}
+
+	// Static metadata is shared by every event of a key, nested values could be modified downstream
+	for key, metadata := range config.APIKeys {
+		for name, value := range metadata {
+			switch value.(type) {
+			case map[string]any, []any:
+				return nil, fmt.Errorf("api key %q: metadata %q must be a string, number or boolean", key, name)
+			}
+		}
+	}
+
+	p := &Pipeline{chains: make(map[string][]Processor)}
+
+	var geo *geoIP
+	for eventName, names := range config.Events {
+		chain := make([]Processor, 0, len(names))
+		for _, name := range names {
+			switch name {
+			case "received_at":
+				chain = append(chain, ProcessorFunc(receivedAt))
+			case "client_ip":
+				chain = append(chain, ips)
+			case "user_agent":
+				chain = append(chain, ProcessorFunc(userAgent))
+			case "geoip":
+				if geo == nil {
+					var err error
+					geo, err = openGeoIP(config.GeoIPDatabase, ips)
+					if err != nil {
+						return nil, err
+					}
+					p.closer = geo.Close
+				}
+				chain = append(chain, geo)
+			case "api_key":
+				chain = append(chain, apiKeys(config.APIKeys))
+			default:
+				p.Close()
+				return nil, fmt.Errorf("event %q: unknown processor %q", eventName, name)
+			}
+		}
+		p.chains[eventName] = chain
+	}
+
+	return p, nil
+}
+
+// FromEnv builds the pipeline configured by the JSON file at ENRICHMENT_CONFIG_FILE.
+// Returns a nil Pipeline, which leaves events untouched, when no file is configured.
+func FromEnv() (*Pipeline, error) {
+	path := os.Getenv("ENRICHMENT_CONFIG_FILE")
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	return New(config)
+}
+
+// Process runs the chain of the event name over e.
+func (p *Pipeline) Process(req *Request, e *model.Event) {
+	if p == nil {
+		return
+	}
+
+	// Clients can't pass their own values off as enrichment
+	delete(e.Metadata, EnrichedKey)
+
+	chain, ok := p.chains[e.EventName]
+	if !ok {
+		chain = p.chains[DefaultChain]
+	}
+	for _, processor := range chain {
+		processor.Process(req, e)
+	}
+}
+
+// Close releases the GeoIP database.
+func (p *Pipeline) Close() error {
+	if p == nil || p.closer == nil {
+		return nil
+	}
+	return p.closer()
+}
+
+// enriched returns the namespaced metadata object of e, creating it if needed.
+func enriched(e *model.Event) map[string]any {
+	if e.Metadata == nil {
+		e.Metadata = make(map[string]any)
+	}
+	m, ok := e.Metadata[EnrichedKey].(map[string]any)
+	if !ok {
+		m = make(map[string]any)
+		e.Metadata[EnrichedKey] = m
+	}
+	return m
+}
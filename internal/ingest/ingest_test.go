@@ -0,0 +1,198 @@
+package ingest
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"fast-ingest/internal/model"
+)
+
+const iPhoneUA = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1"
+
+func newRequest(remoteAddr string, header http.Header) *Request {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &Request{
+		ReceivedAt: time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC),
+		RemoteAddr: remoteAddr,
+		Header:     header,
+	}
+}
+
+func enrichedOf(t *testing.T, e model.Event) map[string]any {
+	t.Helper()
+	m, ok := e.Metadata[EnrichedKey].(map[string]any)
+	if !ok {
+		t.Fatalf("expected %s metadata, got %v", EnrichedKey, e.Metadata)
+	}
+	return m
+}
+
+func TestPipelineChains(t *testing.T) {
+	p, err := New(Config{
+		APIKeys: map[string]map[string]any{"ios-key": {"app": "ios"}},
+		Events: map[string][]string{
+			DefaultChain: {"received_at"},
+			"purchase":   {"client_ip", "user_agent", "api_key"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{"User-Agent": {iPhoneUA}, "X-Api-Key": {"ios-key"}}
+	req := newRequest("203.0.113.7:51234", header)
+
+	t.Run("event name chain", func(t *testing.T) {
+		e := model.Event{EventName: "purchase", Metadata: map[string]any{"plan": "pro"}}
+		p.Process(req, &e)
+
+		m := enrichedOf(t, e)
+		if m["ip"] != "203.0.113.7" || m["app"] != "ios" {
+			t.Errorf("unexpected enrichment %v", m)
+		}
+		if m["device"] != "mobile" || m["os"] != "iOS" || m["browser"] != "Safari" {
+			t.Errorf("unexpected user agent fields %v", m)
+		}
+		if _, ok := m["received_at"]; ok {
+			t.Error("expected the default chain not to run")
+		}
+		if e.Metadata["plan"] != "pro" {
+			t.Error("expected client metadata to be kept")
+		}
+	})
+
+	t.Run("default chain", func(t *testing.T) {
+		e := model.Event{EventName: "page_view"}
+		p.Process(req, &e)
+
+		m := enrichedOf(t, e)
+		if m["received_at"] != "2026-02-01T12:00:00Z" || len(m) != 1 {
+			t.Errorf("unexpected enrichment %v", m)
+		}
+	})
+
+	t.Run("client values are replaced", func(t *testing.T) {
+		e := model.Event{EventName: "page_view", Metadata: map[string]any{EnrichedKey: map[string]any{"ip": "1.2.3.4"}}}
+		p.Process(req, &e)
+
+		if _, ok := enrichedOf(t, e)["ip"]; ok {
+			t.Error("expected the client's enrichment to be dropped")
+		}
+	})
+
+	t.Run("nil pipeline", func(t *testing.T) {
+		var nilPipeline *Pipeline
+		e := model.Event{EventName: "purchase"}
+		nilPipeline.Process(req, &e)
+		if e.Metadata != nil {
+			t.Errorf("expected event to be untouched, got %v", e.Metadata)
+		}
+	})
+}
+
+func TestSetAPIKey(t *testing.T) {
+	r, err := http.NewRequest("GET", "/events/ws?api_key=key_1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := NewRequest(r)
+	req.SetAPIKey("key_1")
+	if got := req.Header.Get("X-API-Key"); got != "key_1" {
+		t.Errorf("expected key_1, got %q", got)
+	}
+	if got := r.Header.Get("X-API-Key"); got != "" {
+		t.Errorf("expected the HTTP request header untouched, got %q", got)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	p, err := New(Config{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"}, Events: map[string][]string{DefaultChain: {"client_ip"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips := p.chains[DefaultChain][0].(*clientIPs)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct client", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"untrusted peer can't spoof", "203.0.113.7:1234", []string{"1.1.1.1"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.5:1234", []string{"198.51.100.9"}, "198.51.100.9"},
+		{"chain of trusted proxies", "10.0.0.5:1234", []string{"1.1.1.1, 198.51.100.9, 192.0.2.1"}, "198.51.100.9"},
+		{"multiple headers", "10.0.0.5:1234", []string{"198.51.100.9", "10.1.1.1"}, "198.51.100.9"},
+		{"only trusted hops", "10.0.0.5:1234", []string{"10.2.2.2"}, "10.2.2.2"},
+		{"ipv6", "[2001:db8::1]:1234", nil, "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for _, v := range tt.forwarded {
+				header.Add("X-Forwarded-For", v)
+			}
+
+			ip, ok := ips.resolve(newRequest(tt.remoteAddr, header))
+			if !ok || ip.String() != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, ip)
+			}
+		})
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{"unknown processor", Config{Events: map[string][]string{DefaultChain: {"weather"}}}},
+		{"invalid trusted proxy", Config{TrustedProxies: []string{"not-an-ip"}}},
+		{"geoip without database", Config{Events: map[string][]string{DefaultChain: {"geoip"}}}},
+		{"geoip database missing", Config{GeoIPDatabase: "missing.mmdb", Events: map[string][]string{DefaultChain: {"geoip"}}}},
+		{"nested api key metadata", Config{APIKeys: map[string]map[string]any{"k": {"app": map[string]any{}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.config); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Run("disabled when unset", func(t *testing.T) {
+		t.Setenv("ENRICHMENT_CONFIG_FILE", "")
+		p, err := FromEnv()
+		if err != nil || p != nil {
+			t.Errorf("expected nil pipeline, got %v, %v", p, err)
+		}
+	})
+
+	t.Run("loads config from file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "enrichment.json")
+		if err := os.WriteFile(path, []byte(`{"events": {"*": ["received_at"]}}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("ENRICHMENT_CONFIG_FILE", path)
+
+		p, err := FromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := model.Event{EventName: "page_view"}
+		p.Process(newRequest("203.0.113.7:1234", nil), &e)
+		if _, ok := enrichedOf(t, e)["received_at"]; !ok {
+			t.Error("expected received_at")
+		}
+	})
+}
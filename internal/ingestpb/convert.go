@@ -0,0 +1,19 @@
+package ingestpb
+
+import "fast-ingest/internal/model"
+
+// ToModel converts a protobuf event. Metadata decodes the way JSON does, eg: numbers become float64.
+func (x *Event) ToModel() model.Event {
+	e := model.Event{
+		EventName:  x.GetEventName(),
+		Channel:    x.GetChannel(),
+		CampaignID: x.GetCampaignId(),
+		UserID:     x.GetUserId(),
+		Timestamp:  x.GetTimestamp(),
+		Tags:       x.GetTags(),
+	}
+	if x.GetMetadata() != nil {
+		e.Metadata = x.GetMetadata().AsMap()
+	}
+	return e
+}
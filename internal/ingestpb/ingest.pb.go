@@ -0,0 +1,547 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: ingest/v1/ingest.proto
+
+// Typed ingest path for backend services, served next to the HTTP API.
+
+package ingestpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Status int32
+
+const (
+	Status_STATUS_UNSPECIFIED Status = 0
+	// The event is queued for storage.
+	Status_STATUS_ACCEPTED Status = 1
+	// A transformation rule dropped the event or it was sampled out. Don't retry.
+	Status_STATUS_DROPPED Status = 2
+	// Required fields are missing. Don't retry.
+	Status_STATUS_INVALID Status = 3
+	// A redaction rule rejected the event. Don't retry.
+	Status_STATUS_REJECTED Status = 4
+	// The ingest queue is full. Retry after retry_after_ms.
+	Status_STATUS_QUEUE_FULL Status = 5
+)
+
+// Enum value maps for Status.
+var (
+	Status_name = map[int32]string{
+		0: "STATUS_UNSPECIFIED",
+		1: "STATUS_ACCEPTED",
+		2: "STATUS_DROPPED",
+		3: "STATUS_INVALID",
+		4: "STATUS_REJECTED",
+		5: "STATUS_QUEUE_FULL",
+	}
+	Status_value = map[string]int32{
+		"STATUS_UNSPECIFIED": 0,
+		"STATUS_ACCEPTED":    1,
+		"STATUS_DROPPED":     2,
+		"STATUS_INVALID":     3,
+		"STATUS_REJECTED":    4,
+		"STATUS_QUEUE_FULL":  5,
+	}
+)
+
+func (x Status) Enum() *Status {
+	p := new(Status)
+	*p = x
+	return p
+}
+
+func (x Status) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Status) Descriptor() protoreflect.EnumDescriptor {
+	return file_ingest_v1_ingest_proto_enumTypes[0].Descriptor()
+}
+
+func (Status) Type() protoreflect.EnumType {
+	return &file_ingest_v1_ingest_proto_enumTypes[0]
+}
+
+func (x Status) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Status.Descriptor instead.
+func (Status) EnumDescriptor() ([]byte, []int) {
+	return file_ingest_v1_ingest_proto_rawDescGZIP(), []int{0}
+}
+
+// Event mirrors the JSON event of POST /events.
+type Event struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	EventName  string                 `protobuf:"bytes,1,opt,name=event_name,json=eventName,proto3" json:"event_name,omitempty"`
+	Channel    string                 `protobuf:"bytes,2,opt,name=channel,proto3" json:"channel,omitempty"`
+	CampaignId string                 `protobuf:"bytes,3,opt,name=campaign_id,json=campaignId,proto3" json:"campaign_id,omitempty"`
+	UserId     string                 `protobuf:"bytes,4,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	// Unix seconds or milliseconds.
+	Timestamp     int64            `protobuf:"varint,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Tags          []string         `protobuf:"bytes,6,rep,name=tags,proto3" json:"tags,omitempty"`
+	Metadata      *structpb.Struct `protobuf:"bytes,7,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_ingest_v1_ingest_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_v1_ingest_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_ingest_v1_ingest_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Event) GetEventName() string {
+	if x != nil {
+		return x.EventName
+	}
+	return ""
+}
+
+func (x *Event) GetChannel() string {
+	if x != nil {
+		return x.Channel
+	}
+	return ""
+}
+
+func (x *Event) GetCampaignId() string {
+	if x != nil {
+		return x.CampaignId
+	}
+	return ""
+}
+
+func (x *Event) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *Event) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+func (x *Event) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Event) GetMetadata() *structpb.Struct {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+type EventResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Status        Status                 `protobuf:"varint,1,opt,name=status,proto3,enum=fastingest.ingest.v1.Status" json:"status,omitempty"`
+	Error         string                 `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EventResult) Reset() {
+	*x = EventResult{}
+	mi := &file_ingest_v1_ingest_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EventResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EventResult) ProtoMessage() {}
+
+func (x *EventResult) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_v1_ingest_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EventResult.ProtoReflect.Descriptor instead.
+func (*EventResult) Descriptor() ([]byte, []int) {
+	return file_ingest_v1_ingest_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *EventResult) GetStatus() Status {
+	if x != nil {
+		return x.Status
+	}
+	return Status_STATUS_UNSPECIFIED
+}
+
+func (x *EventResult) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type IngestRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Event         *Event                 `protobuf:"bytes,1,opt,name=event,proto3" json:"event,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestRequest) Reset() {
+	*x = IngestRequest{}
+	mi := &file_ingest_v1_ingest_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestRequest) ProtoMessage() {}
+
+func (x *IngestRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_v1_ingest_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestRequest.ProtoReflect.Descriptor instead.
+func (*IngestRequest) Descriptor() ([]byte, []int) {
+	return file_ingest_v1_ingest_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *IngestRequest) GetEvent() *Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+type IngestResponse struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Result *EventResult           `protobuf:"bytes,1,opt,name=result,proto3" json:"result,omitempty"`
+	// Set when the queue is full: how long to back off before retrying.
+	RetryAfterMs  int64 `protobuf:"varint,2,opt,name=retry_after_ms,json=retryAfterMs,proto3" json:"retry_after_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestResponse) Reset() {
+	*x = IngestResponse{}
+	mi := &file_ingest_v1_ingest_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestResponse) ProtoMessage() {}
+
+func (x *IngestResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_v1_ingest_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestResponse.ProtoReflect.Descriptor instead.
+func (*IngestResponse) Descriptor() ([]byte, []int) {
+	return file_ingest_v1_ingest_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *IngestResponse) GetResult() *EventResult {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+func (x *IngestResponse) GetRetryAfterMs() int64 {
+	if x != nil {
+		return x.RetryAfterMs
+	}
+	return 0
+}
+
+type IngestBatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Events        []*Event               `protobuf:"bytes,1,rep,name=events,proto3" json:"events,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestBatchRequest) Reset() {
+	*x = IngestBatchRequest{}
+	mi := &file_ingest_v1_ingest_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestBatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestBatchRequest) ProtoMessage() {}
+
+func (x *IngestBatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_v1_ingest_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestBatchRequest.ProtoReflect.Descriptor instead.
+func (*IngestBatchRequest) Descriptor() ([]byte, []int) {
+	return file_ingest_v1_ingest_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *IngestBatchRequest) GetEvents() []*Event {
+	if x != nil {
+		return x.Events
+	}
+	return nil
+}
+
+type IngestBatchResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// One result per event, in request order.
+	Results  []*EventResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	Accepted int32          `protobuf:"varint,2,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	// Set when the queue was full for any event: how long to back off before retrying those.
+	RetryAfterMs  int64 `protobuf:"varint,3,opt,name=retry_after_ms,json=retryAfterMs,proto3" json:"retry_after_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestBatchResponse) Reset() {
+	*x = IngestBatchResponse{}
+	mi := &file_ingest_v1_ingest_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestBatchResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestBatchResponse) ProtoMessage() {}
+
+func (x *IngestBatchResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_ingest_v1_ingest_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestBatchResponse.ProtoReflect.Descriptor instead.
+func (*IngestBatchResponse) Descriptor() ([]byte, []int) {
+	return file_ingest_v1_ingest_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *IngestBatchResponse) GetResults() []*EventResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+func (x *IngestBatchResponse) GetAccepted() int32 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+func (x *IngestBatchResponse) GetRetryAfterMs() int64 {
+	if x != nil {
+		return x.RetryAfterMs
+	}
+	return 0
+}
+
+var File_ingest_v1_ingest_proto protoreflect.FileDescriptor
+
+const file_ingest_v1_ingest_proto_rawDesc = "" +
+	"\n" +
+	"\x16ingest/v1/ingest.proto\x12\x14fastingest.ingest.v1\x1a\x1cgoogle/protobuf/struct.proto\"\xe1\x01\n" +
+	"\x05Event\x12\x1d\n" +
+	"\n" +
+	"event_name\x18\x01 \x01(\tR\teventName\x12\x18\n" +
+	"\achannel\x18\x02 \x01(\tR\achannel\x12\x1f\n" +
+	"\vcampaign_id\x18\x03 \x01(\tR\n" +
+	"campaignId\x12\x17\n" +
+	"\auser_id\x18\x04 \x01(\tR\x06userId\x12\x1c\n" +
+	"\ttimestamp\x18\x05 \x01(\x03R\ttimestamp\x12\x12\n" +
+	"\x04tags\x18\x06 \x03(\tR\x04tags\x123\n" +
+	"\bmetadata\x18\a \x01(\v2\x17.google.protobuf.StructR\bmetadata\"Y\n" +
+	"\vEventResult\x124\n" +
+	"\x06status\x18\x01 \x01(\x0e2\x1c.fastingest.ingest.v1.StatusR\x06status\x12\x14\n" +
+	"\x05error\x18\x02 \x01(\tR\x05error\"B\n" +
+	"\rIngestRequest\x121\n" +
+	"\x05event\x18\x01 \x01(\v2\x1b.fastingest.ingest.v1.EventR\x05event\"q\n" +
+	"\x0eIngestResponse\x129\n" +
+	"\x06result\x18\x01 \x01(\v2!.fastingest.ingest.v1.EventResultR\x06result\x12$\n" +
+	"\x0eretry_after_ms\x18\x02 \x01(\x03R\fretryAfterMs\"I\n" +
+	"\x12IngestBatchRequest\x123\n" +
+	"\x06events\x18\x01 \x03(\v2\x1b.fastingest.ingest.v1.EventR\x06events\"\x94\x01\n" +
+	"\x13IngestBatchResponse\x12;\n" +
+	"\aresults\x18\x01 \x03(\v2!.fastingest.ingest.v1.EventResultR\aresults\x12\x1a\n" +
+	"\baccepted\x18\x02 \x01(\x05R\baccepted\x12$\n" +
+	"\x0eretry_after_ms\x18\x03 \x01(\x03R\fretryAfterMs*\x89\x01\n" +
+	"\x06Status\x12\x16\n" +
+	"\x12STATUS_UNSPECIFIED\x10\x00\x12\x13\n" +
+	"\x0fSTATUS_ACCEPTED\x10\x01\x12\x12\n" +
+	"\x0eSTATUS_DROPPED\x10\x02\x12\x12\n" +
+	"\x0eSTATUS_INVALID\x10\x03\x12\x13\n" +
+	"\x0fSTATUS_REJECTED\x10\x04\x12\x15\n" +
+	"\x11STATUS_QUEUE_FULL\x10\x052\xaa\x02\n" +
+	"\rIngestService\x12S\n" +
+	"\x06Ingest\x12#.fastingest.ingest.v1.IngestRequest\x1a$.fastingest.ingest.v1.IngestResponse\x12b\n" +
+	"\vIngestBatch\x12(.fastingest.ingest.v1.IngestBatchRequest\x1a).fastingest.ingest.v1.IngestBatchResponse\x12`\n" +
+	"\fIngestStream\x12#.fastingest.ingest.v1.IngestRequest\x1a).fastingest.ingest.v1.IngestBatchResponse(\x01B(Z&fast-ingest/internal/ingestpb;ingestpbb\x06proto3"
+
+var (
+	file_ingest_v1_ingest_proto_rawDescOnce sync.Once
+	file_ingest_v1_ingest_proto_rawDescData []byte
+)
+
+func file_ingest_v1_ingest_proto_rawDescGZIP() []byte {
+	file_ingest_v1_ingest_proto_rawDescOnce.Do(func() {
+		file_ingest_v1_ingest_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_ingest_v1_ingest_proto_rawDesc), len(file_ingest_v1_ingest_proto_rawDesc)))
+	})
+	return file_ingest_v1_ingest_proto_rawDescData
+}
+
+var file_ingest_v1_ingest_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_ingest_v1_ingest_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_ingest_v1_ingest_proto_goTypes = []any{
+	(Status)(0),                 // 0: fastingest.ingest.v1.Status
+	(*Event)(nil),               // 1: fastingest.ingest.v1.Event
+	(*EventResult)(nil),         // 2: fastingest.ingest.v1.EventResult
+	(*IngestRequest)(nil),       // 3: fastingest.ingest.v1.IngestRequest
+	(*IngestResponse)(nil),      // 4: fastingest.ingest.v1.IngestResponse
+	(*IngestBatchRequest)(nil),  // 5: fastingest.ingest.v1.IngestBatchRequest
+	(*IngestBatchResponse)(nil), // 6: fastingest.ingest.v1.IngestBatchResponse
+	(*structpb.Struct)(nil),     // 7: google.protobuf.Struct
+}
+var file_ingest_v1_ingest_proto_depIdxs = []int32{
+	7, // 0: fastingest.ingest.v1.Event.metadata:type_name -> google.protobuf.Struct
+	0, // 1: fastingest.ingest.v1.EventResult.status:type_name -> fastingest.ingest.v1.Status
+	1, // 2: fastingest.ingest.v1.IngestRequest.event:type_name -> fastingest.ingest.v1.Event
+	2, // 3: fastingest.ingest.v1.IngestResponse.result:type_name -> fastingest.ingest.v1.EventResult
+	1, // 4: fastingest.ingest.v1.IngestBatchRequest.events:type_name -> fastingest.ingest.v1.Event
+	2, // 5: fastingest.ingest.v1.IngestBatchResponse.results:type_name -> fastingest.ingest.v1.EventResult
+	3, // 6: fastingest.ingest.v1.IngestService.Ingest:input_type -> fastingest.ingest.v1.IngestRequest
+	5, // 7: fastingest.ingest.v1.IngestService.IngestBatch:input_type -> fastingest.ingest.v1.IngestBatchRequest
+	3, // 8: fastingest.ingest.v1.IngestService.IngestStream:input_type -> fastingest.ingest.v1.IngestRequest
+	4, // 9: fastingest.ingest.v1.IngestService.Ingest:output_type -> fastingest.ingest.v1.IngestResponse
+	6, // 10: fastingest.ingest.v1.IngestService.IngestBatch:output_type -> fastingest.ingest.v1.IngestBatchResponse
+	6, // 11: fastingest.ingest.v1.IngestService.IngestStream:output_type -> fastingest.ingest.v1.IngestBatchResponse
+	9, // [9:12] is the sub-list for method output_type
+	6, // [6:9] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_ingest_v1_ingest_proto_init() }
+func file_ingest_v1_ingest_proto_init() {
+	if File_ingest_v1_ingest_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_ingest_v1_ingest_proto_rawDesc), len(file_ingest_v1_ingest_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_ingest_v1_ingest_proto_goTypes,
+		DependencyIndexes: file_ingest_v1_ingest_proto_depIdxs,
+		EnumInfos:         file_ingest_v1_ingest_proto_enumTypes,
+		MessageInfos:      file_ingest_v1_ingest_proto_msgTypes,
+	}.Build()
+	File_ingest_v1_ingest_proto = out.File
+	file_ingest_v1_ingest_proto_goTypes = nil
+	file_ingest_v1_ingest_proto_depIdxs = nil
+}
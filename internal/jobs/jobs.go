@@ -0,0 +1,159 @@
+// Package jobs runs long operations in the background and keeps track of their status
+// so clients can poll for the result.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"maps"
+	"sync"
+	"time"
+)
+
+// Status of a job.
+const (
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// retention is how long finished jobs stay queryable.
+const retention = 24 * time.Hour
+
+// pruneInterval is how often finished jobs past retention are dropped.
+const pruneInterval = 10 * time.Minute
+
+// Job is a snapshot of a background job.
+type Job struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Result     any        `json:"result,omitempty"`
+}
+
+// Func is the work of a job. It receives the job ID, eg: to name its output, and returns the job result.
+type Func func(ctx context.Context, id string) (any, error)
+
+// Registry runs jobs and keeps them in memory, so they don't survive a restart.
+type Registry struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	onPrune map[string]func(Job)
+}
+
+// NewRegistry returns an empty registry. Jobs run with ctx, cancelling it aborts them and stops pruning.
+func NewRegistry(ctx context.Context) *Registry {
+	r := &Registry{ctx: ctx, jobs: make(map[string]*Job), onPrune: make(map[string]func(Job))}
+	go r.pruneLoop()
+	return r
+}
+
+// OnPrune sets fn to be called with each job of the kind dropped after retention, eg: to remove its output.
+func (r *Registry) OnPrune(kind string, fn func(Job)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onPrune[kind] = fn
+}
+
+// Start runs fn in the background and returns the job as registered.
+func (r *Registry) Start(kind string, fn Func) Job {
+	job := &Job{
+		ID:        newID(),
+		Kind:      kind,
+		Status:    StatusRunning,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	r.mu.Lock()
+	r.jobs[job.ID] = job
+	snapshot := *job
+	r.mu.Unlock()
+
+	go func() {
+		result, err := fn(r.ctx, job.ID)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		finishedAt := time.Now().UTC()
+		job.FinishedAt = &finishedAt
+		if err != nil {
+			log.Printf("Job %s (%s) failed: %v", job.ID, job.Kind, err)
+			job.Status = StatusFailed
+			job.Error = err.Error()
+			return
+		}
+		job.Status = StatusDone
+		job.Result = result
+	}()
+
+	return snapshot
+}
+
+// Get returns a snapshot of the job with the given ID and kind.
+func (r *Registry) Get(kind, id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok || job.Kind != kind {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// pruneLoop prunes the registry every pruneInterval until its context is done.
+func (r *Registry) pruneLoop() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+			r.prune()
+		}
+	}
+}
+
+// prune drops jobs that finished more than retention ago and hands them to the prune hook of their kind.
+func (r *Registry) prune() {
+	r.mu.Lock()
+	pruned := r.expired()
+	onPrune := maps.Clone(r.onPrune)
+	r.mu.Unlock()
+
+	// Prune hooks run outside the lock, they may be slow or use the registry
+	for _, p := range pruned {
+		if fn := onPrune[p.Kind]; fn != nil {
+			fn(p)
+		}
+	}
+}
+
+// expired drops jobs that finished more than retention ago and returns them. Must be called with mu held.
+func (r *Registry) expired() []Job {
+	var pruned []Job
+	cutoff := time.Now().Add(-retention)
+	for id, job := range r.jobs {
+		if job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			pruned = append(pruned, *job)
+			delete(r.jobs, id)
+		}
+	}
+	return pruned
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
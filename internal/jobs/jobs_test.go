@@ -0,0 +1,82 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, r *Registry, kind, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		job, ok := r.Get(kind, id)
+		if !ok {
+			t.Fatalf("job %s not found", id)
+		}
+		if job.Status != StatusRunning {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry(context.Background())
+
+	t.Run("successful job keeps its result", func(t *testing.T) {
+		var gotID string
+		job := r.Start("export", func(ctx context.Context, id string) (any, error) {
+			gotID = id
+			return 42, nil
+		})
+		if job.Status != StatusRunning {
+			t.Errorf("expected running, got %s", job.Status)
+		}
+
+		done := waitFor(t, r, "export", job.ID)
+		if done.Status != StatusDone || done.Result != 42 || done.FinishedAt == nil || gotID != job.ID {
+			t.Errorf("unexpected job %+v", done)
+		}
+	})
+
+	t.Run("failed job keeps its error", func(t *testing.T) {
+		job := r.Start("export", func(ctx context.Context, id string) (any, error) {
+			return nil, errors.New("disk full")
+		})
+
+		done := waitFor(t, r, "export", job.ID)
+		if done.Status != StatusFailed || done.Error != "disk full" {
+			t.Errorf("unexpected job %+v", done)
+		}
+	})
+
+	t.Run("jobs are looked up by kind", func(t *testing.T) {
+		job := r.Start("export", func(ctx context.Context, id string) (any, error) { return nil, nil })
+		if _, ok := r.Get("erasure", job.ID); ok {
+			t.Error("expected export job not to be found as an erasure job")
+		}
+	})
+	t.Run("pruned jobs are handed to the prune hook", func(t *testing.T) {
+		var pruned []string
+		r.OnPrune("export", func(job Job) { pruned = append(pruned, job.ID) })
+
+		old := r.Start("export", func(ctx context.Context, id string) (any, error) { return nil, nil })
+		waitFor(t, r, "export", old.ID)
+		r.mu.Lock()
+		finishedAt := time.Now().Add(-retention - time.Minute)
+		r.jobs[old.ID].FinishedAt = &finishedAt
+		r.mu.Unlock()
+
+		r.prune()
+		if _, ok := r.Get("export", old.ID); ok {
+			t.Error("expected the old job to be pruned")
+		}
+		if len(pruned) != 1 || pruned[0] != old.ID {
+			t.Errorf("expected the prune hook to get %s, got %v", old.ID, pruned)
+		}
+	})
+}
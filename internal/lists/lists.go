@@ -0,0 +1,18 @@
+// Package lists parses the comma separated lists of environment variables, flags and query parameters.
+package lists
+
+import "strings"
+
+// Split splits each value on commas and returns the trimmed items, skipping empty ones.
+// Several values are flattened, eg: a query parameter that is both repeated and comma separated.
+func Split(values ...string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
@@ -0,0 +1,29 @@
+package lists
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+	}{
+		{"no value", nil, nil},
+		{"empty value", []string{""}, nil},
+		{"single item", []string{"email"}, []string{"email"}},
+		{"items are trimmed", []string{" email , phone "}, []string{"email", "phone"}},
+		{"empty items are skipped", []string{"email,,phone,"}, []string{"email", "phone"}},
+		{"values are flattened", []string{"email,phone", "ip"}, []string{"email", "phone", "ip"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Split(tt.values...); !slices.Equal(got, tt.want) {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package model
+
+type ConsumerLag struct {
+	GroupID    string         `json:"group_id"`
+	TotalLag   int64          `json:"total_lag"`
+	Partitions []PartitionLag `json:"partitions"`
+}
+
+type PartitionLag struct {
+	Topic           string `json:"topic"`
+	Partition       int    `json:"partition"`
+	CommittedOffset int64  `json:"committed_offset"`
+	EndOffset       int64  `json:"end_offset"`
+	Lag             int64  `json:"lag"`
+}
@@ -0,0 +1,9 @@
+package model
+
+type ErasureResult struct {
+	Mode                 string   `json:"mode"`
+	EventsAffected       int64    `json:"events_affected"`
+	ScrubbedKeys         []string `json:"scrubbed_keys,omitempty"`
+	HourlyBucketsRebuilt int      `json:"hourly_buckets_rebuilt"`
+	DailyBucketsRebuilt  int      `json:"daily_buckets_rebuilt"`
+}
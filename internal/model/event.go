@@ -8,4 +8,29 @@ type Event struct {
 	Timestamp  int64          `json:"timestamp"`
 	Tags       []string       `json:"tags"`
 	Metadata   map[string]any `json:"metadata"`
+
+	// SampleRate is the share of events like this one kept by sampling, set at ingest. 0 means unsampled.
+	SampleRate float64 `json:"-"`
+
+	// MessageID is the id a source gave the event, eg: the Segment messageId, set at ingest.
+	// Events with one are deduplicated by it, so retries whose timestamp is the receive time still match.
+	MessageID string `json:"-"`
+}
+
+type StoredEvent struct {
+	ID         int64          `json:"id,omitempty"`
+	DedupeKey  string         `json:"dedupe_key,omitempty"`
+	EventName  string         `json:"event_name,omitempty"`
+	Channel    string         `json:"channel,omitempty"`
+	CampaignID string         `json:"campaign_id,omitempty"`
+	UserID     string         `json:"user_id,omitempty"`
+	Timestamp  int64          `json:"timestamp,omitempty"`
+	Tags       []string       `json:"tags,omitempty"`
+	Metadata   map[string]any `json:"metadata,omitempty"`
+	IngestedAt string         `json:"ingested_at,omitempty"`
+}
+
+type EventPage struct {
+	Events     []StoredEvent
+	NextCursor string
 }
@@ -3,28 +3,121 @@ package model
 import "time"
 
 type Metrics struct {
-	EventName                string `json:"event_name"`
-	From                     string `json:"from"`
-	To                       string `json:"to"`
-	TotalEvents              int64  `json:"total_events"`
-	TotalUniqueEventsForUser int64  `json:"total_unique_events_for_user"`
-	GroupBy                  string `json:"group_by,omitempty"`
-	GroupBreakdown           any    `json:"group_breakdown,omitempty"`
+	EventName                string             `json:"event_name"`
+	From                     string             `json:"from"`
+	To                       string             `json:"to"`
+	TotalEvents              int64              `json:"total_events"`
+	EstimatedTotalEvents     int64              `json:"estimated_total_events"`
+	TotalUniqueEventsForUser int64              `json:"total_unique_events_for_user"`
+	GroupBy                  string             `json:"group_by,omitempty"`
+	Timezone                 string             `json:"timezone,omitempty"`
+	GroupBreakdown           any                `json:"group_breakdown,omitempty"`
+	Source                   string             `json:"source"`
+	Segments                 []MetricsSegment   `json:"segments,omitempty"`
+	Comparison               *MetricsComparison `json:"comparison,omitempty"`
+	Events                   []Metrics          `json:"events,omitempty"`
+	Formulas                 []FormulaMetrics   `json:"formulas,omitempty"`
+	NextCursor               string             `json:"next_cursor,omitempty"`
+}
+
+type MetricsSegment struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Resolution string `json:"resolution"`
 }
 
 type MetricsTotalsQueryResult struct {
 	TotalEvents              int64
+	EstimatedTotalEvents     int64
 	TotalUniqueEventsForUser int64
 }
 
 type MetricsTimeGroupQueryResult struct {
 	Bucket                   time.Time `json:"bucket"`
 	TotalEvents              int64     `json:"total_events"`
+	EstimatedTotalEvents     int64     `json:"estimated_total_events"`
 	TotalUniqueEventsForUser int64     `json:"total_unique_events_for_user"`
 }
 
 type MetricsChannelGroupQueryResult struct {
 	Channel                  string `json:"channel"`
 	TotalEvents              int64  `json:"total_events"`
+	EstimatedTotalEvents     int64  `json:"estimated_total_events"`
 	TotalUniqueEventsForUser int64  `json:"total_unique_events_for_user"`
+	Other                    bool   `json:"other,omitempty"`
+}
+
+type MetricsDimensionGroupQueryResult struct {
+	Dimension                string `json:"dimension"`
+	TotalEvents              int64  `json:"total_events"`
+	EstimatedTotalEvents     int64  `json:"estimated_total_events"`
+	TotalUniqueEventsForUser int64  `json:"total_unique_events_for_user"`
+	// Other marks the row collecting every group beyond the limit, which a group named __other__ is not.
+	Other bool `json:"other,omitempty"`
+}
+
+type MetricsComparison struct {
+	Mode                     string       `json:"mode"`
+	From                     string       `json:"from"`
+	To                       string       `json:"to"`
+	TotalEvents              int64        `json:"total_events"`
+	TotalUniqueEventsForUser int64        `json:"total_unique_events_for_user"`
+	Delta                    MetricsDelta `json:"delta"`
+	GroupBreakdown           any          `json:"group_breakdown,omitempty"`
+}
+
+// MetricsDelta is current minus comparison. Percentages are nil when the comparison value is zero.
+type MetricsDelta struct {
+	TotalEvents                 int64    `json:"total_events"`
+	TotalEventsPct              *float64 `json:"total_events_pct"`
+	TotalUniqueEventsForUser    int64    `json:"total_unique_events_for_user"`
+	TotalUniqueEventsForUserPct *float64 `json:"total_unique_events_for_user_pct"`
+}
+
+type MetricsTimeComparisonResult struct {
+	Bucket                   time.Time    `json:"bucket"`
+	ComparisonBucket         time.Time    `json:"comparison_bucket"`
+	TotalEvents              int64        `json:"total_events"`
+	TotalUniqueEventsForUser int64        `json:"total_unique_events_for_user"`
+	Delta                    MetricsDelta `json:"delta"`
+}
+
+type MetricsChannelComparisonResult struct {
+	Channel                  string       `json:"channel"`
+	TotalEvents              int64        `json:"total_events"`
+	TotalUniqueEventsForUser int64        `json:"total_unique_events_for_user"`
+	Delta                    MetricsDelta `json:"delta"`
+	Other                    bool         `json:"other,omitempty"`
+}
+
+type MetricsDimensionComparisonResult struct {
+	Dimension                string       `json:"dimension"`
+	TotalEvents              int64        `json:"total_events"`
+	TotalUniqueEventsForUser int64        `json:"total_unique_events_for_user"`
+	Delta                    MetricsDelta `json:"delta"`
+	Other                    bool         `json:"other,omitempty"`
+}
+
+type FormulaMetrics struct {
+	Name           string   `json:"name"`
+	Expression     string   `json:"expression"`
+	Value          *float64 `json:"value"`
+	GroupBreakdown any      `json:"group_breakdown,omitempty"`
+}
+
+type FormulaTimeGroupResult struct {
+	Bucket time.Time `json:"bucket"`
+	Value  *float64  `json:"value"`
+}
+
+type FormulaChannelGroupResult struct {
+	Channel string   `json:"channel"`
+	Value   *float64 `json:"value"`
+	Other   bool     `json:"other,omitempty"`
+}
+
+type FormulaDimensionGroupResult struct {
+	Dimension string   `json:"dimension"`
+	Value     *float64 `json:"value"`
+	Other     bool     `json:"other,omitempty"`
 }
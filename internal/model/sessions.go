@@ -0,0 +1,40 @@
+package model
+
+import "time"
+
+type SessionMetrics struct {
+	EventName                 string  `json:"event_name,omitempty"`
+	From                      string  `json:"from"`
+	To                        string  `json:"to"`
+	SessionGapSeconds         int64   `json:"session_gap_seconds"`
+	TotalSessions             int64   `json:"total_sessions"`
+	AvgSessionDurationSeconds float64 `json:"avg_session_duration_seconds"`
+	AvgEventsPerSession       float64 `json:"avg_events_per_session"`
+	BounceRate                float64 `json:"bounce_rate"`
+	GroupBy                   string  `json:"group_by,omitempty"`
+	Timezone                  string  `json:"timezone,omitempty"`
+	GroupBreakdown            any     `json:"group_breakdown,omitempty"`
+}
+
+type SessionMetricsTotalsQueryResult struct {
+	TotalSessions             int64
+	AvgSessionDurationSeconds float64
+	AvgEventsPerSession       float64
+	BounceRate                float64
+}
+
+type SessionMetricsTimeGroupQueryResult struct {
+	Bucket                    time.Time `json:"bucket"`
+	TotalSessions             int64     `json:"total_sessions"`
+	AvgSessionDurationSeconds float64   `json:"avg_session_duration_seconds"`
+	AvgEventsPerSession       float64   `json:"avg_events_per_session"`
+	BounceRate                float64   `json:"bounce_rate"`
+}
+
+type SessionMetricsChannelGroupQueryResult struct {
+	Channel                   string  `json:"channel"`
+	TotalSessions             int64   `json:"total_sessions"`
+	AvgSessionDurationSeconds float64 `json:"avg_session_duration_seconds"`
+	AvgEventsPerSession       float64 `json:"avg_events_per_session"`
+	BounceRate                float64 `json:"bounce_rate"`
+}
@@ -0,0 +1,16 @@
+package model
+
+import "time"
+
+type SinkStatus struct {
+	Name            string     `json:"name"`
+	Type            string     `json:"type"`
+	DeliveredEvents int64      `json:"delivered_events"`
+	FailedEvents    int64      `json:"failed_events"`
+	DroppedEvents   int64      `json:"dropped_events"`
+	PendingEvents   int64      `json:"pending_events"`
+	LagSeconds      float64    `json:"lag_seconds"`
+	LastDeliveredAt *time.Time `json:"last_delivered_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastErrorAt     *time.Time `json:"last_error_at,omitempty"`
+}
@@ -0,0 +1,178 @@
+// Package otlp turns OpenTelemetry log records into events, so services can emit business events through their OTel logs SDK.
+package otlp
+
+import (
+	"encoding/base64"
+	"os"
+	"strconv"
+	"time"
+
+	"fast-ingest/internal/model"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+)
+
+// Config names the attributes log records are mapped from.
+type Config struct {
+	// EventAttribute is the record attribute holding the event name. Records without it, or an OTel event name, aren't events.
+	EventAttribute string
+
+	// UserAttribute and CampaignAttribute are the record attributes holding the user and campaign ids.
+	UserAttribute     string
+	CampaignAttribute string
+
+	// ChannelAttribute is the resource attribute holding the channel.
+	ChannelAttribute string
+}
+
+// DefaultConfig follows the OpenTelemetry semantic conventions where they have a name for the field.
+var DefaultConfig = Config{
+	EventAttribute:    "event.name",
+	UserAttribute:     "user.id",
+	CampaignAttribute: "campaign.id",
+	ChannelAttribute:  "service.name",
+}
+
+// ConfigFromEnv reads OTLP_EVENT_ATTRIBUTE, OTLP_USER_ATTRIBUTE, OTLP_CAMPAIGN_ATTRIBUTE and OTLP_CHANNEL_ATTRIBUTE
+// over DefaultConfig.
+func ConfigFromEnv() Config {
+	config := DefaultConfig
+	setFromEnv(&config.EventAttribute, "OTLP_EVENT_ATTRIBUTE")
+	setFromEnv(&config.UserAttribute, "OTLP_USER_ATTRIBUTE")
+	setFromEnv(&config.CampaignAttribute, "OTLP_CAMPAIGN_ATTRIBUTE")
+	setFromEnv(&config.ChannelAttribute, "OTLP_CHANNEL_ATTRIBUTE")
+	return config
+}
+
+func setFromEnv(field *string, key string) {
+	if value := os.Getenv(key); value != "" {
+		*field = value
+	}
+}
+
+// Events maps the log records of req that carry an event name onto events, the other records are skipped.
+// Record attributes, besides the mapped ones, become metadata, next to the body, the severity and the resource
+// attributes under resource. receivedAt stands in for records without a timestamp.
+func (c Config) Events(req *collogspb.ExportLogsServiceRequest, receivedAt time.Time) []model.Event {
+	var events []model.Event
+	for _, resourceLogs := range req.GetResourceLogs() {
+		resource := resourceLogs.GetResource().GetAttributes()
+		for _, scopeLogs := range resourceLogs.GetScopeLogs() {
+			for _, record := range scopeLogs.GetLogRecords() {
+				if e, ok := c.event(record, resource, receivedAt); ok {
+					events = append(events, e)
+				}
+			}
+		}
+	}
+	return events
+}
+
+func (c Config) event(record *logspb.LogRecord, resourceAttributes []*commonpb.KeyValue, receivedAt time.Time) (model.Event, bool) {
+	metadata := attributes(record.GetAttributes())
+
+	eventName := textValue(record.GetAttributes(), c.EventAttribute)
+	if eventName == "" {
+		eventName = record.GetEventName()
+	}
+	if eventName == "" {
+		return model.Event{}, false
+	}
+
+	userID := textValue(record.GetAttributes(), c.UserAttribute)
+	campaignID := textValue(record.GetAttributes(), c.CampaignAttribute)
+	delete(metadata, c.EventAttribute)
+	delete(metadata, c.UserAttribute)
+	delete(metadata, c.CampaignAttribute)
+
+	if body := record.GetBody(); body != nil {
+		metadata["body"] = value(body)
+	}
+	if severity := record.GetSeverityText(); severity != "" {
+		metadata["severity"] = severity
+	}
+
+	// Every event gets its own copy, processors may rewrite metadata in place
+	resource := attributes(resourceAttributes)
+	channel := textValue(resourceAttributes, c.ChannelAttribute)
+	if len(resource) > 0 {
+		metadata["resource"] = resource
+	}
+
+	timestamp := receivedAt
+	if nanos := record.GetTimeUnixNano(); nanos != 0 {
+		timestamp = time.Unix(0, int64(nanos))
+	} else if nanos := record.GetObservedTimeUnixNano(); nanos != 0 {
+		timestamp = time.Unix(0, int64(nanos))
+	}
+
+	return model.Event{
+		EventName:  eventName,
+		Channel:    channel,
+		CampaignID: campaignID,
+		UserID:     userID,
+		Timestamp:  timestamp.Unix(),
+		Metadata:   metadata,
+	}, true
+}
+
+func attributes(kvs []*commonpb.KeyValue) map[string]any {
+	m := make(map[string]any, len(kvs))
+	for _, kv := range kvs {
+		m[kv.GetKey()] = value(kv.GetValue())
+	}
+	return m
+}
+
+// value converts an attribute value the way JSON decodes, eg: integers become float64, so rules see the same
+// values as for events sent as JSON.
+func value(v *commonpb.AnyValue) any {
+	switch v := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return v.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return float64(v.IntValue)
+	case *commonpb.AnyValue_DoubleValue:
+		return v.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(v.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		values := make([]any, 0, len(v.ArrayValue.GetValues()))
+		for _, item := range v.ArrayValue.GetValues() {
+			values = append(values, value(item))
+		}
+		return values
+	case *commonpb.AnyValue_KvlistValue:
+		return attributes(v.KvlistValue.GetValues())
+	}
+	return nil
+}
+
+// textValue formats the scalar attribute key of kvs, used as an id or name. Integers are formatted from their
+// int64 rather than the float64 of value, which can't hold ids above 2^53. The last of repeated keys wins, like in attributes.
+func textValue(kvs []*commonpb.KeyValue, key string) string {
+	var text string
+	for _, kv := range kvs {
+		if kv.GetKey() != key {
+			continue
+		}
+
+		switch v := kv.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			text = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			text = strconv.FormatInt(v.IntValue, 10)
+		case *commonpb.AnyValue_DoubleValue:
+			text = strconv.FormatFloat(v.DoubleValue, 'f', -1, 64)
+		case *commonpb.AnyValue_BoolValue:
+			text = strconv.FormatBool(v.BoolValue)
+		default:
+			text = ""
+		}
+	}
+	return text
+}
@@ -0,0 +1,104 @@
+package otlp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"fast-ingest/internal/model"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+func stringValue(s string) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: s}}
+}
+
+func intValue(i int64) *commonpb.AnyValue {
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: i}}
+}
+
+func TestEvents(t *testing.T) {
+	happenedAt := time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC)
+	receivedAt := happenedAt.Add(time.Minute)
+
+	req := &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				{Key: "service.name", Value: stringValue("checkout")},
+				{Key: "deployment.environment", Value: stringValue("prod")},
+			}},
+			ScopeLogs: []*logspb.ScopeLogs{{
+				LogRecords: []*logspb.LogRecord{
+					{
+						TimeUnixNano: uint64(happenedAt.UnixNano()),
+						SeverityText: "INFO",
+						Body:         stringValue("order placed"),
+						Attributes: []*commonpb.KeyValue{
+							{Key: "event.name", Value: stringValue("purchase")},
+							// Above 2^53, where float64 would round the id
+							{Key: "user.id", Value: intValue(9007199254740993)},
+							{Key: "campaign.id", Value: stringValue("cmp_1")},
+							{Key: "items", Value: intValue(3)},
+						},
+					},
+					{
+						// Plain logs aren't events
+						Body: stringValue("cache miss"),
+					},
+					{
+						// The OTel event name stands in for the attribute, the observed time for the timestamp
+						EventName:            "refund",
+						ObservedTimeUnixNano: uint64(happenedAt.UnixNano()),
+						Attributes:           []*commonpb.KeyValue{{Key: "user.id", Value: stringValue("user_1")}},
+					},
+					{
+						EventName: "signup",
+					},
+				},
+			}},
+		}},
+	}
+
+	resource := map[string]any{"service.name": "checkout", "deployment.environment": "prod"}
+	want := []model.Event{
+		{
+			EventName:  "purchase",
+			Channel:    "checkout",
+			CampaignID: "cmp_1",
+			UserID:     "9007199254740993",
+			Timestamp:  happenedAt.Unix(),
+			Metadata:   map[string]any{"items": float64(3), "body": "order placed", "severity": "INFO", "resource": resource},
+		},
+		{
+			EventName: "refund",
+			Channel:   "checkout",
+			UserID:    "user_1",
+			Timestamp: happenedAt.Unix(),
+			Metadata:  map[string]any{"resource": resource},
+		},
+		{
+			EventName: "signup",
+			Channel:   "checkout",
+			Timestamp: receivedAt.Unix(),
+			Metadata:  map[string]any{"resource": resource},
+		},
+	}
+
+	got := DefaultConfig.Events(req, receivedAt)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("OTLP_EVENT_ATTRIBUTE", "business.event")
+
+	config := ConfigFromEnv()
+	if config.EventAttribute != "business.event" || config.UserAttribute != DefaultConfig.UserAttribute {
+		t.Errorf("unexpected config %+v", config)
+	}
+}
@@ -0,0 +1,316 @@
+// Package redact detects PII in ingested events and drops, masks, hashes or rejects it
+// before the events are queued for storage.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"fast-ingest/internal/model"
+)
+
+// Supported rule actions.
+const (
+	ActionDrop   = "drop"
+	ActionMask   = "mask"
+	ActionHash   = "hash"
+	ActionReject = "reject"
+)
+
+// Mask replaces masked values.
+const Mask = "[REDACTED]"
+
+// Rule matches values by regular expression, by field path, or both.
+type Rule struct {
+	Name string `json:"name"`
+
+	// Pattern matches string values. With mask and hash only the matched part is replaced.
+	Pattern string `json:"pattern,omitempty"`
+
+	// Fields restricts the rule to field paths, eg: tags, metadata.email or metadata.contact.phone.
+	// Without a pattern the whole value of the field is redacted. Every tag and metadata value is checked when empty.
+	Fields []string `json:"fields,omitempty"`
+
+	Action string `json:"action"`
+}
+
+// RejectedError is returned when a reject rule matches an event.
+type RejectedError struct {
+	Rule string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("event rejected by redaction rule %q", e.Rule)
+}
+
+// RuleStats is how many values a rule redacted, and events it rejected, since startup.
+type RuleStats struct {
+	Name     string `json:"name"`
+	Action   string `json:"action"`
+	Redacted int64  `json:"redacted"`
+	Rejected int64  `json:"rejected"`
+}
+
+type compiledRule struct {
+	Rule
+	re       *regexp.Regexp
+	paths    [][]string
+	redacted atomic.Int64
+	rejected atomic.Int64
+}
+
+// Redactor applies rules to events. It is safe for concurrent use.
+type Redactor struct {
+	rules  []*compiledRule
+	secret []byte
+}
+
+// New compiles rules. secret keys the HMAC of hash rules and is required when there are any.
+func New(rules []Rule, secret []byte) (*Redactor, error) {
+	r := &Redactor{secret: secret}
+
+	names := make(map[string]bool)
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Action {
+		case ActionDrop, ActionMask, ActionReject:
+		case ActionHash:
+			if len(secret) == 0 {
+				return nil, fmt.Errorf("rule %q: hash requires REDACTION_SECRET", rule.Name)
+			}
+		default:
+			return nil, fmt.Errorf("rule %q: invalid action %q (expected drop, mask, hash or reject)", rule.Name, rule.Action)
+		}
+
+		if rule.Pattern == "" && len(rule.Fields) == 0 {
+			return nil, fmt.Errorf("rule %q: pattern or fields is required", rule.Name)
+		}
+
+		compiled := &compiledRule{Rule: rule}
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+			compiled.re = re
+		}
+		for _, field := range rule.Fields {
+			path := strings.Split(field, ".")
+			if (path[0] != "tags" || len(path) != 1) && (path[0] != "metadata" || len(path) < 2) {
+				return nil, fmt.Errorf("rule %q: invalid field %q (expected tags or metadata.<key>)", rule.Name, field)
+			}
+			compiled.paths = append(compiled.paths, path)
+		}
+
+		r.rules = append(r.rules, compiled)
+	}
+
+	return r, nil
+}
+
+// FromEnv loads the rules of the JSON file at REDACTION_RULES_FILE, keyed by REDACTION_SECRET.
+// Returns a nil Redactor, which leaves events untouched, when no file is configured.
+func FromEnv() (*Redactor, error) {
+	path := os.Getenv("REDACTION_RULES_FILE")
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	return New(rules, []byte(os.Getenv("REDACTION_SECRET")))
+}
+
+// Apply redacts e in place, rules run in order. Returns a *RejectedError if a reject rule matches,
+// in which case e may be partially redacted and must be discarded.
+func (r *Redactor) Apply(e *model.Event) error {
+	if r == nil {
+		return nil
+	}
+
+	for _, rule := range r.rules {
+		n := r.applyRule(rule, e)
+		if n == 0 {
+			continue
+		}
+		if rule.Action == ActionReject {
+			rule.rejected.Add(1)
+			return &RejectedError{Rule: rule.Name}
+		}
+		rule.redacted.Add(int64(n))
+	}
+
+	return nil
+}
+
+// Stats returns the counters of every rule, in rule order.
+func (r *Redactor) Stats() []RuleStats {
+	stats := []RuleStats{}
+	if r == nil {
+		return stats
+	}
+
+	for _, rule := range r.rules {
+		stats = append(stats, RuleStats{
+			Name:     rule.Name,
+			Action:   rule.Action,
+			Redacted: rule.redacted.Load(),
+			Rejected: rule.rejected.Load(),
+		})
+	}
+	return stats
+}
+
+// applyRule runs a rule over the fields it targets and returns how many values it matched.
+func (r *Redactor) applyRule(rule *compiledRule, e *model.Event) int {
+	n := 0
+
+	if len(rule.paths) == 0 {
+		e.Tags, n = r.redactTags(rule, e.Tags)
+		for key, value := range e.Metadata {
+			redacted, keep, matched := r.redactValue(rule, value)
+			n += matched
+			if !keep {
+				delete(e.Metadata, key)
+			} else if matched > 0 {
+				e.Metadata[key] = redacted
+			}
+		}
+		return n
+	}
+
+	for _, path := range rule.paths {
+		if path[0] == "tags" {
+			var matched int
+			e.Tags, matched = r.redactTags(rule, e.Tags)
+			n += matched
+			continue
+		}
+		n += r.redactPath(rule, e.Metadata, path[1:])
+	}
+	return n
+}
+
+// redactPath walks a metadata path and redacts the value at its end.
+func (r *Redactor) redactPath(rule *compiledRule, m map[string]any, path []string) int {
+	value, ok := m[path[0]]
+	if !ok {
+		return 0
+	}
+
+	if len(path) > 1 {
+		nested, ok := value.(map[string]any)
+		if !ok {
+			return 0
+		}
+		return r.redactPath(rule, nested, path[1:])
+	}
+
+	redacted, keep, matched := r.redactValue(rule, value)
+	if !keep {
+		delete(m, path[0])
+	} else if matched > 0 {
+		m[path[0]] = redacted
+	}
+	return matched
+}
+
+func (r *Redactor) redactTags(rule *compiledRule, tags []string) ([]string, int) {
+	n := 0
+	kept := tags[:0]
+	for _, tag := range tags {
+		redacted, keep, matched := r.redactValue(rule, tag)
+		n += matched
+		if keep {
+			if s, ok := redacted.(string); ok {
+				kept = append(kept, s)
+			}
+		}
+	}
+	return kept, n
+}
+
+// redactValue redacts a metadata value or tag. Nested objects and arrays are walked when the rule has a pattern.
+// Returns the new value, whether to keep it and how many values matched.
+func (r *Redactor) redactValue(rule *compiledRule, value any) (any, bool, int) {
+	if rule.re == nil {
+		// A field rule redacts the whole value, whatever its type
+		switch rule.Action {
+		case ActionDrop:
+			return nil, false, 1
+		case ActionHash:
+			return r.hash(fmt.Sprint(value)), true, 1
+		}
+		return Mask, true, 1
+	}
+
+	switch v := value.(type) {
+	case string:
+		if !rule.re.MatchString(v) {
+			return v, true, 0
+		}
+		switch rule.Action {
+		case ActionDrop:
+			return nil, false, 1
+		case ActionHash:
+			return rule.re.ReplaceAllStringFunc(v, r.hash), true, 1
+		}
+		return rule.re.ReplaceAllLiteralString(v, Mask), true, 1
+
+	case map[string]any:
+		n := 0
+		for key, nested := range v {
+			redacted, keep, matched := r.redactValue(rule, nested)
+			n += matched
+			if !keep {
+				delete(v, key)
+			} else if matched > 0 {
+				v[key] = redacted
+			}
+		}
+		return v, true, n
+
+	case []any:
+		n := 0
+		kept := v[:0]
+		for _, nested := range v {
+			redacted, keep, matched := r.redactValue(rule, nested)
+			n += matched
+			if keep {
+				kept = append(kept, redacted)
+			}
+		}
+		return kept, true, n
+	}
+
+	return value, true, 0
+}
+
+// hash replaces a value with a keyed hash, so equal values can still be correlated, eg: hash_3f2a...
+func (r *Redactor) hash(value string) string {
+	mac := hmac.New(sha256.New, r.secret)
+	mac.Write([]byte(value))
+	return "hash_" + hex.EncodeToString(mac.Sum(nil))[:32]
+}
@@ -0,0 +1,247 @@
+package redact
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"fast-ingest/internal/model"
+)
+
+const emailPattern = `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`
+
+// eventWithPII returns an event with PII in its tags and in nested metadata values.
+func eventWithPII() model.Event {
+	return model.Event{
+		EventName: "signup",
+		Channel:   "web",
+		UserID:    "42",
+		Timestamp: 1769904000,
+		Tags:      []string{"promo", "ref:jane@example.com"},
+		Metadata: map[string]any{
+			"email":   "jane@example.com",
+			"plan":    "pro",
+			"contact": map[string]any{"phone": "+90 555 123 4567", "note": "call jane@example.com"},
+			"emails":  []any{"a@example.com", "none"},
+		},
+	}
+}
+
+func TestNewValidatesRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   Rule
+		secret string
+	}{
+		{"missing name", Rule{Pattern: "x", Action: ActionMask}, ""},
+		{"invalid action", Rule{Name: "r", Pattern: "x", Action: "encrypt"}, ""},
+		{"missing pattern and fields", Rule{Name: "r", Action: ActionMask}, ""},
+		{"invalid pattern", Rule{Name: "r", Pattern: "(", Action: ActionMask}, ""},
+		{"invalid field", Rule{Name: "r", Fields: []string{"user_id"}, Action: ActionMask}, ""},
+		{"bare metadata field", Rule{Name: "r", Fields: []string{"metadata"}, Action: ActionMask}, ""},
+		{"hash without secret", Rule{Name: "r", Pattern: "x", Action: ActionHash}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New([]Rule{tt.rule}, []byte(tt.secret)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	if _, err := New([]Rule{{Name: "r", Pattern: "x", Action: ActionMask}, {Name: "r", Pattern: "y", Action: ActionDrop}}, nil); err == nil {
+		t.Error("expected error for duplicate names")
+	}
+}
+
+func TestApplyPatternRules(t *testing.T) {
+	t.Run("mask replaces matches everywhere", func(t *testing.T) {
+		r, err := New([]Rule{{Name: "email", Pattern: emailPattern, Action: ActionMask}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := eventWithPII()
+		if err := r.Apply(&e); err != nil {
+			t.Fatal(err)
+		}
+
+		if e.Metadata["email"] != Mask || e.Metadata["plan"] != "pro" {
+			t.Errorf("unexpected metadata %v", e.Metadata)
+		}
+		if note := e.Metadata["contact"].(map[string]any)["note"]; note != "call "+Mask {
+			t.Errorf("expected nested value to be masked, got %v", note)
+		}
+		if !reflect.DeepEqual(e.Metadata["emails"], []any{Mask, "none"}) {
+			t.Errorf("expected array value to be masked, got %v", e.Metadata["emails"])
+		}
+		if !reflect.DeepEqual(e.Tags, []string{"promo", "ref:" + Mask}) {
+			t.Errorf("unexpected tags %v", e.Tags)
+		}
+		if stats := r.Stats(); stats[0].Redacted != 4 {
+			t.Errorf("expected 4 redacted values, got %+v", stats)
+		}
+	})
+
+	t.Run("drop removes matching values", func(t *testing.T) {
+		r, err := New([]Rule{{Name: "email", Pattern: emailPattern, Action: ActionDrop}}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := eventWithPII()
+		if err := r.Apply(&e); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, ok := e.Metadata["email"]; ok {
+			t.Error("expected email to be dropped")
+		}
+		if !reflect.DeepEqual(e.Tags, []string{"promo"}) {
+			t.Errorf("unexpected tags %v", e.Tags)
+		}
+		if !reflect.DeepEqual(e.Metadata["emails"], []any{"none"}) {
+			t.Errorf("unexpected emails %v", e.Metadata["emails"])
+		}
+	})
+
+	t.Run("hash is keyed and stable", func(t *testing.T) {
+		rules := []Rule{{Name: "email", Pattern: emailPattern, Fields: []string{"metadata.email"}, Action: ActionHash}}
+		r, err := New(rules, []byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := New(rules, []byte("other"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e1, e2, e3 := eventWithPII(), eventWithPII(), eventWithPII()
+		_ = r.Apply(&e1)
+		_ = r.Apply(&e2)
+		_ = other.Apply(&e3)
+
+		hashed, _ := e1.Metadata["email"].(string)
+		if !strings.HasPrefix(hashed, "hash_") {
+			t.Fatalf("expected hashed email, got %v", e1.Metadata["email"])
+		}
+		if e2.Metadata["email"] != hashed {
+			t.Error("expected the same value to hash the same")
+		}
+		if e3.Metadata["email"] == hashed {
+			t.Error("expected a different secret to hash differently")
+		}
+		if e1.Tags[1] != "ref:jane@example.com" {
+			t.Error("expected fields to restrict the rule")
+		}
+	})
+
+	t.Run("reject stops at the first match", func(t *testing.T) {
+		r, err := New([]Rule{
+			{Name: "phone", Pattern: `\+\d[\d ]{7,}`, Action: ActionReject},
+			{Name: "email", Pattern: emailPattern, Action: ActionMask},
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := eventWithPII()
+		err = r.Apply(&e)
+		var rejected *RejectedError
+		if !errors.As(err, &rejected) || rejected.Rule != "phone" {
+			t.Fatalf("expected rejection by phone, got %v", err)
+		}
+		if stats := r.Stats(); stats[0].Rejected != 1 || stats[0].Redacted != 0 || stats[1].Redacted != 0 {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+	})
+}
+
+func TestApplyFieldRules(t *testing.T) {
+	r, err := New([]Rule{
+		{Name: "phone", Fields: []string{"metadata.contact.phone"}, Action: ActionDrop},
+		{Name: "plan", Fields: []string{"metadata.plan", "metadata.missing", "metadata.plan.nested"}, Action: ActionMask},
+		{Name: "tags", Fields: []string{"tags"}, Action: ActionDrop},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := eventWithPII()
+	if err := r.Apply(&e); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := e.Metadata["contact"].(map[string]any)["phone"]; ok {
+		t.Error("expected nested phone to be dropped")
+	}
+	if e.Metadata["plan"] != Mask {
+		t.Errorf("expected plan to be masked, got %v", e.Metadata["plan"])
+	}
+	if _, ok := e.Metadata["missing"]; ok {
+		t.Error("expected missing field to stay missing")
+	}
+	if len(e.Tags) != 0 {
+		t.Errorf("expected tags to be dropped, got %v", e.Tags)
+	}
+	if e.Metadata["email"] != "jane@example.com" {
+		t.Error("expected other fields to be untouched")
+	}
+}
+
+func TestNilRedactor(t *testing.T) {
+	var r *Redactor
+	e := model.Event{EventName: "signup", Metadata: map[string]any{"email": "jane@example.com"}}
+	if err := r.Apply(&e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Metadata["email"] != "jane@example.com" {
+		t.Error("expected event to be untouched")
+	}
+	if stats := r.Stats(); stats == nil || len(stats) != 0 {
+		t.Errorf("expected empty stats, got %v", stats)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Run("disabled when unset", func(t *testing.T) {
+		t.Setenv("REDACTION_RULES_FILE", "")
+		r, err := FromEnv()
+		if err != nil || r != nil {
+			t.Errorf("expected nil redactor, got %v, %v", r, err)
+		}
+	})
+
+	t.Run("loads rules from file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		rules := `[{"name": "email", "pattern": "@", "action": "hash"}]`
+		if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("REDACTION_RULES_FILE", path)
+		t.Setenv("REDACTION_SECRET", "secret")
+
+		r, err := FromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats := r.Stats(); len(stats) != 1 || stats[0].Name != "email" {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("rejects invalid JSON", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "rules.json")
+		if err := os.WriteFile(path, []byte(`{`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("REDACTION_RULES_FILE", path)
+		if _, err := FromEnv(); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
@@ -0,0 +1,111 @@
+// Package sampling keeps a deterministic share of high-volume events at ingest.
+// A user is either in or out of the sample for a rule, so their sampled events stay consistent.
+package sampling
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"fast-ingest/internal/ingest"
+	"fast-ingest/internal/model"
+)
+
+// Rule keeps Rate of the events it matches. Every condition set must hold, a rule without conditions matches every event.
+type Rule struct {
+	Name      string   `json:"name"`
+	EventName []string `json:"event_name,omitempty"`
+	Channel   []string `json:"channel,omitempty"`
+
+	// APIKey matches the X-API-Key header of the request.
+	APIKey []string `json:"api_key,omitempty"`
+
+	// Rate is the share of users kept, in (0, 1].
+	Rate float64 `json:"rate"`
+}
+
+// Sampler applies the first matching rule to each event. It is safe for concurrent use.
+type Sampler struct {
+	rules []Rule
+}
+
+// New validates rules.
+func New(rules []Rule) (*Sampler, error) {
+	names := make(map[string]bool)
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if rule.Rate <= 0 || rule.Rate > 1 {
+			return nil, fmt.Errorf("rule %q: rate must be in (0, 1], got %v", rule.Name, rule.Rate)
+		}
+	}
+
+	return &Sampler{rules: rules}, nil
+}
+
+// FromEnv loads the rules of the JSON file at SAMPLING_RULES_FILE.
+// Returns a nil Sampler, which keeps every event, when no file is configured.
+func FromEnv() (*Sampler, error) {
+	path := os.Getenv("SAMPLING_RULES_FILE")
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	return New(rules)
+}
+
+// Keep reports whether e is in the sample and records the sample rate it was kept at on e.
+func (s *Sampler) Keep(req *ingest.Request, e *model.Event) bool {
+	e.SampleRate = 1
+	if s == nil {
+		return true
+	}
+
+	for _, rule := range s.rules {
+		if !rule.matches(req, e) {
+			continue
+		}
+		e.SampleRate = rule.Rate
+		return rule.Rate >= 1 || userFraction(e.UserID) < rule.Rate
+	}
+
+	return true
+}
+
+func (r Rule) matches(req *ingest.Request, e *model.Event) bool {
+	if len(r.EventName) > 0 && !slices.Contains(r.EventName, e.EventName) {
+		return false
+	}
+	if len(r.Channel) > 0 && !slices.Contains(r.Channel, e.Channel) {
+		return false
+	}
+	if len(r.APIKey) > 0 && !slices.Contains(r.APIKey, req.Header.Get("X-API-Key")) {
+		return false
+	}
+	return true
+}
+
+// userFraction maps a user id onto [0, 1), uniformly and always to the same value.
+func userFraction(userID string) float64 {
+	sum := sha256.Sum256([]byte(userID))
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+}
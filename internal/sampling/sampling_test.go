@@ -0,0 +1,125 @@
+package sampling
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"fast-ingest/internal/ingest"
+	"fast-ingest/internal/model"
+)
+
+func TestNewValidatesRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+	}{
+		{"missing name", []Rule{{Rate: 0.5}}},
+		{"zero rate", []Rule{{Name: "r"}}},
+		{"rate above 1", []Rule{{Name: "r", Rate: 1.5}}},
+		{"duplicate names", []Rule{{Name: "r", Rate: 0.5}, {Name: "r", Rate: 0.1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.rules); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestKeep(t *testing.T) {
+	s, err := New([]Rule{
+		{Name: "purchases", EventName: []string{"purchase"}, Rate: 1},
+		{Name: "scroll", EventName: []string{"scroll"}, Rate: 0.1},
+		{Name: "load test", APIKey: []string{"load-test"}, Rate: 0.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &ingest.Request{}
+
+	t.Run("rate is recorded", func(t *testing.T) {
+		e := model.Event{EventName: "purchase", UserID: "user_1"}
+		if !s.Keep(req, &e) || e.SampleRate != 1 {
+			t.Errorf("expected purchase to be kept at 1, got %v", e.SampleRate)
+		}
+
+		e = model.Event{EventName: "page_view", UserID: "user_1"}
+		if !s.Keep(req, &e) || e.SampleRate != 1 {
+			t.Errorf("expected unmatched events to be kept at 1, got %v", e.SampleRate)
+		}
+
+		e = model.Event{EventName: "page_view", UserID: "user_1"}
+		s.Keep(&ingest.Request{Header: http.Header{"X-Api-Key": {"load-test"}}}, &e)
+		if e.SampleRate != 0.5 {
+			t.Errorf("expected the api key rule to apply, got %v", e.SampleRate)
+		}
+	})
+
+	t.Run("users are consistently in or out", func(t *testing.T) {
+		for i := range 100 {
+			userID := fmt.Sprintf("user_%d", i)
+			first := s.Keep(req, &model.Event{EventName: "scroll", UserID: userID})
+			for range 3 {
+				if s.Keep(req, &model.Event{EventName: "scroll", UserID: userID}) != first {
+					t.Fatalf("expected %s to be sampled consistently", userID)
+				}
+			}
+		}
+	})
+
+	t.Run("keeps about the rate", func(t *testing.T) {
+		kept := 0
+		const users = 20000
+		for i := range users {
+			if s.Keep(req, &model.Event{EventName: "scroll", UserID: fmt.Sprintf("user_%d", i)}) {
+				kept++
+			}
+		}
+		if share := float64(kept) / users; math.Abs(share-0.1) > 0.01 {
+			t.Errorf("expected about 10%% to be kept, got %.3f", share)
+		}
+	})
+}
+
+func TestNilSampler(t *testing.T) {
+	var s *Sampler
+	e := model.Event{EventName: "scroll", UserID: "user_1"}
+	if !s.Keep(&ingest.Request{}, &e) || e.SampleRate != 1 {
+		t.Errorf("expected event to be kept at 1, got %v", e.SampleRate)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Run("disabled when unset", func(t *testing.T) {
+		t.Setenv("SAMPLING_RULES_FILE", "")
+		s, err := FromEnv()
+		if err != nil || s != nil {
+			t.Errorf("expected nil sampler, got %v, %v", s, err)
+		}
+	})
+
+	t.Run("loads rules from file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "sampling.json")
+		if err := os.WriteFile(path, []byte(`[{"name": "scroll", "event_name": ["scroll"], "rate": 0.25}]`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("SAMPLING_RULES_FILE", path)
+
+		s, err := FromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := model.Event{EventName: "scroll", UserID: "user_1"}
+		s.Keep(&ingest.Request{}, &e)
+		if e.SampleRate != 0.25 {
+			t.Errorf("expected rate 0.25, got %v", e.SampleRate)
+		}
+	})
+}
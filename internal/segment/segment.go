@@ -0,0 +1,217 @@
+// Package segment maps Segment tracking API messages onto events, so Segment clients can send to fast-ingest unchanged.
+// See https://segment.com/docs/connections/spec/ for the message format.
+package segment
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	"fast-ingest/internal/model"
+)
+
+// Message types mapped onto events. Other types, such as group and alias, have no event equivalent.
+const (
+	TypeTrack    = "track"
+	TypeIdentify = "identify"
+	TypePage     = "page"
+	TypeScreen   = "screen"
+)
+
+// ErrUnsupportedType is returned for message types that aren't mapped onto events.
+var ErrUnsupportedType = errors.New("unsupported message type")
+
+// libraryChannels maps the library a message was sent with onto a channel.
+var libraryChannels = map[string]string{
+	"analytics.js":           "web",
+	"analytics-next":         "web",
+	"analytics-ios":          "ios",
+	"analytics-swift":        "ios",
+	"analytics-android":      "android",
+	"analytics-kotlin":       "android",
+	"analytics-react-native": "mobile",
+	"analytics-flutter":      "mobile",
+}
+
+// defaultChannel is used for messages that carry no hint of where they come from, typically sent by server libraries.
+const defaultChannel = "server"
+
+// Message is a track, identify, page or screen call. Fields with no event equivalent are ignored.
+type Message struct {
+	Type        string         `json:"type"`
+	Event       string         `json:"event"`
+	Name        string         `json:"name"`
+	Category    string         `json:"category"`
+	UserID      ID             `json:"userId"`
+	AnonymousID ID             `json:"anonymousId"`
+	Channel     string         `json:"channel"`
+	Properties  map[string]any `json:"properties"`
+	Traits      map[string]any `json:"traits"`
+	Context     *Context       `json:"context"`
+	MessageID   string         `json:"messageId"`
+
+	// Timestamp is when the event happened. Without it, it is derived from OriginalTimestamp and SentAt,
+	// both set by the client's clock, the way Segment corrects clock skew.
+	Timestamp         string `json:"timestamp"`
+	OriginalTimestamp string `json:"originalTimestamp"`
+	SentAt            string `json:"sentAt"`
+}
+
+// Context holds the parts of the message context mapped onto events.
+type Context struct {
+	Library struct {
+		Name string `json:"name"`
+	} `json:"library"`
+	Campaign struct {
+		Name string `json:"name"`
+	} `json:"campaign"`
+	Traits map[string]any `json:"traits"`
+}
+
+// Batch is the body of /v1/batch. Context applies to the messages that have none.
+type Batch struct {
+	Batch   []Message `json:"batch"`
+	Context *Context  `json:"context"`
+	SentAt  string    `json:"sentAt"`
+}
+
+// ID is a user or anonymous id. Segment clients may send them as numbers.
+type ID string
+
+func (id *ID) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		*id = ID(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("id must be a string or a number")
+	}
+	*id = ID(n.String())
+	return nil
+}
+
+// ToEvent maps m onto an event. receivedAt stands in for a missing timestamp and corrects the client's clock skew.
+//
+// The event name is the tracked event, or identify, page or screen. The user is userId, or anonymousId for anonymous users.
+// The channel comes from the library in the context, the campaign from its UTM campaign, and the metadata from
+// the properties, or the traits of identify calls. The messageId deduplicates the event and is kept in metadata.message_id.
+func (m Message) ToEvent(receivedAt time.Time) (model.Event, error) {
+	e := model.Event{
+		UserID:   string(m.UserID),
+		Channel:  m.channel(),
+		Metadata: make(map[string]any),
+	}
+	if e.UserID == "" {
+		e.UserID = string(m.AnonymousID)
+	}
+	if m.Context != nil {
+		e.CampaignID = m.Context.Campaign.Name
+	}
+
+	switch m.Type {
+	case TypeTrack:
+		e.EventName = m.Event
+		maps.Copy(e.Metadata, m.Properties)
+
+	case TypeIdentify:
+		e.EventName = TypeIdentify
+		if m.Context != nil {
+			maps.Copy(e.Metadata, m.Context.Traits)
+		}
+		maps.Copy(e.Metadata, m.Traits)
+
+	case TypePage, TypeScreen:
+		e.EventName = m.Type
+		maps.Copy(e.Metadata, m.Properties)
+		setIfMissing(e.Metadata, "name", m.Name)
+		setIfMissing(e.Metadata, "category", m.Category)
+
+	default:
+		return model.Event{}, fmt.Errorf("%w %q", ErrUnsupportedType, m.Type)
+	}
+
+	// Anonymous users who later identify can be linked through their anonymous id
+	if m.AnonymousID != "" && string(m.AnonymousID) != e.UserID {
+		setIfMissing(e.Metadata, "anonymous_id", string(m.AnonymousID))
+	}
+
+	// Clients retry with the same messageId, which deduplicates messages sent without a timestamp
+	e.MessageID = m.MessageID
+	setIfMissing(e.Metadata, "message_id", m.MessageID)
+	if len(e.Metadata) == 0 {
+		e.Metadata = nil
+	}
+
+	timestamp, err := m.timestamp(receivedAt)
+	if err != nil {
+		return model.Event{}, err
+	}
+	e.Timestamp = timestamp.Unix()
+
+	return e, nil
+}
+
+func (m Message) channel() string {
+	if m.Context != nil {
+		if channel, ok := libraryChannels[m.Context.Library.Name]; ok {
+			return channel
+		}
+	}
+
+	switch m.Channel {
+	case "":
+		return defaultChannel
+	case "browser":
+		return "web"
+	}
+	return strings.ToLower(m.Channel)
+}
+
+func (m Message) timestamp(receivedAt time.Time) (time.Time, error) {
+	if m.Timestamp != "" {
+		return parseTime("timestamp", m.Timestamp)
+	}
+	if m.OriginalTimestamp == "" {
+		return receivedAt, nil
+	}
+
+	original, err := parseTime("originalTimestamp", m.OriginalTimestamp)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if m.SentAt == "" {
+		return original, nil
+	}
+	sentAt, err := parseTime("sentAt", m.SentAt)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return receivedAt.Add(original.Sub(sentAt)), nil
+}
+
+func parseTime(field, value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q (expected ISO 8601)", field, value)
+	}
+	return t, nil
+}
+
+func setIfMissing(m map[string]any, key, value string) {
+	if _, ok := m[key]; !ok && value != "" {
+		m[key] = value
+	}
+}
@@ -0,0 +1,96 @@
+package segment
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"fast-ingest/internal/model"
+)
+
+func TestToEvent(t *testing.T) {
+	receivedAt := time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		msg  string
+		want model.Event
+	}{
+		{
+			name: "track",
+			msg: `{"type":"track","event":"Order Completed","userId":"42","anonymousId":"anon_1","timestamp":"2026-02-01T10:00:00.000Z",
+				"properties":{"revenue":9.99},"context":{"library":{"name":"analytics.js"},"campaign":{"name":"spring_sale"}}}`,
+			want: model.Event{
+				EventName:  "Order Completed",
+				Channel:    "web",
+				CampaignID: "spring_sale",
+				UserID:     "42",
+				Timestamp:  time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC).Unix(),
+				Metadata:   map[string]any{"revenue": 9.99, "anonymous_id": "anon_1"},
+			},
+		},
+		{
+			name: "identify of an anonymous user with a numeric id",
+			msg:  `{"type":"identify","anonymousId":1234,"messageId":"msg_1","traits":{"plan":"pro"},"context":{"traits":{"plan":"free","email":"a@example.com"}}}`,
+			want: model.Event{
+				EventName: "identify",
+				Channel:   "server",
+				UserID:    "1234",
+				Timestamp: receivedAt.Unix(),
+				Metadata:  map[string]any{"plan": "pro", "email": "a@example.com", "message_id": "msg_1"},
+				MessageID: "msg_1",
+			},
+		},
+		{
+			name: "page with skewed client clock",
+			msg: `{"type":"page","name":"Pricing","userId":"42","channel":"browser",
+				"originalTimestamp":"2026-02-01T13:59:50Z","sentAt":"2026-02-01T14:00:00Z"}`,
+			want: model.Event{
+				EventName: "page",
+				Channel:   "web",
+				UserID:    "42",
+				Timestamp: receivedAt.Add(-10 * time.Second).Unix(),
+				Metadata:  map[string]any{"name": "Pricing"},
+			},
+		},
+		{
+			name: "screen",
+			msg:  `{"type":"screen","name":"Home","userId":"42","context":{"library":{"name":"analytics-ios"}},"timestamp":"2026-02-01T10:00:00Z"}`,
+			want: model.Event{
+				EventName: "screen",
+				Channel:   "ios",
+				UserID:    "42",
+				Timestamp: time.Date(2026, 2, 1, 10, 0, 0, 0, time.UTC).Unix(),
+				Metadata:  map[string]any{"name": "Home"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var msg Message
+			if err := json.Unmarshal([]byte(tt.msg), &msg); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := msg.ToEvent(receivedAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToEventErrors(t *testing.T) {
+	if _, err := (Message{Type: "alias", UserID: "42"}).ToEvent(time.Now()); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected unsupported type, got %v", err)
+	}
+	if _, err := (Message{Type: TypeTrack, Event: "e", UserID: "42", Timestamp: "yesterday"}).ToEvent(time.Now()); err == nil {
+		t.Error("expected invalid timestamp error")
+	}
+}
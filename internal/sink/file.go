@@ -0,0 +1,57 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"fast-ingest/internal/model"
+)
+
+// File appends events to a local file, one JSON event per line. It stands in for a message broker,
+// eg: in development, follow the feed with tail -f.
+type File struct {
+	name string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFile opens path for appending, creating it if needed.
+func OpenFile(name, path string) (*File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &File{name: name, file: file}, nil
+}
+
+// Name implements storage.Sink.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Publish appends events in a single write, so concurrent readers don't see partial batches.
+func (f *File) Publish(_ context.Context, events []model.Event) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range events {
+		if err := enc.Encode(newEvent(e)); err != nil {
+			return permanentError{err}
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.file.Write(buf.Bytes())
+	return err
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,362 @@
+// Package sink publishes stored events to downstream destinations, such as webhooks or a local file.
+// Every destination has its own queue and delivery loop, so a slow or failing destination holds back
+// neither the writer nor the other destinations.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fast-ingest/internal/backoff"
+	"fast-ingest/internal/model"
+	"fast-ingest/internal/storage"
+)
+
+// Supported destination types.
+const (
+	TypeWebhook = "webhook"
+	TypeFile    = "file"
+)
+
+// Destination configures where events are published, and which ones.
+type Destination struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// URL receives the webhook deliveries. SecretEnv names the environment variable holding the secret
+	// deliveries are signed with, they are unsigned without it.
+	URL       string `json:"url,omitempty"`
+	SecretEnv string `json:"secret_env,omitempty"`
+
+	// Path is the file events are appended to, one JSON event per line.
+	Path string `json:"path,omitempty"`
+
+	// EventName and Channel filter the published events, any of the listed values matches. Every event is published when empty.
+	EventName []string `json:"event_name,omitempty"`
+	Channel   []string `json:"channel,omitempty"`
+
+	// MaxAttempts bounds the deliveries of a batch before it is given up on, default 5.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
+	// MaxPending bounds the batches waiting for delivery, default 100. New batches are dropped while it is reached.
+	MaxPending int `json:"max_pending,omitempty"`
+}
+
+// Event is a published event, with the dedupe key destinations deduplicate redeliveries by.
+type Event struct {
+	DedupeKey string `json:"dedupe_key"`
+	model.Event
+}
+
+func newEvent(e model.Event) Event {
+	return Event{DedupeKey: storage.DedupeKey(e), Event: e}
+}
+
+// permanentError marks a failed delivery retrying can't fix, such as a webhook rejecting the payload.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+type batch struct {
+	events   []model.Event
+	storedAt time.Time
+}
+
+type destination struct {
+	Destination
+	sink    storage.Sink
+	batches chan batch
+
+	delivered atomic.Int64
+	failed    atomic.Int64
+	dropped   atomic.Int64
+	pending   atomic.Int64
+
+	// oldest is when the batch being delivered was stored, in Unix nanoseconds, 0 while idle.
+	oldest atomic.Int64
+
+	mu              sync.Mutex
+	lastDeliveredAt time.Time
+	lastError       string
+	lastErrorAt     time.Time
+}
+
+// Fanout publishes stored batches to every destination whose filters match. It is safe for concurrent use.
+type Fanout struct {
+	destinations []*destination
+
+	// Backoff spaces out the retries of failed deliveries.
+	Backoff backoff.Backoff
+}
+
+// New validates destinations and opens their sinks.
+func New(destinations []Destination) (*Fanout, error) {
+	f := &Fanout{}
+
+	names := make(map[string]bool)
+	for i, d := range destinations {
+		if d.Name == "" {
+			f.Close()
+			return nil, fmt.Errorf("destination %d: name is required", i)
+		}
+		if names[d.Name] {
+			f.Close()
+			return nil, fmt.Errorf("destination %q: duplicate name", d.Name)
+		}
+		names[d.Name] = true
+
+		if d.MaxAttempts < 0 || d.MaxPending < 0 {
+			f.Close()
+			return nil, fmt.Errorf("destination %q: max_attempts and max_pending must not be negative", d.Name)
+		}
+
+		s, err := open(d)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("destination %q: %w", d.Name, err)
+		}
+		f.add(d, s)
+	}
+
+	return f, nil
+}
+
+func open(d Destination) (storage.Sink, error) {
+	switch d.Type {
+	case TypeWebhook:
+		u, err := url.Parse(d.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid url %q", d.URL)
+		}
+		var secret []byte
+		if d.SecretEnv != "" {
+			secret = []byte(os.Getenv(d.SecretEnv))
+			if len(secret) == 0 {
+				return nil, fmt.Errorf("secret_env %s is not set", d.SecretEnv)
+			}
+		}
+		return NewWebhook(d.Name, d.URL, secret), nil
+	case TypeFile:
+		if d.Path == "" {
+			return nil, errors.New("path is required")
+		}
+		return OpenFile(d.Name, d.Path)
+	default:
+		return nil, fmt.Errorf("invalid type %q (expected webhook or file)", d.Type)
+	}
+}
+
+// add registers a destination delivering to s.
+func (f *Fanout) add(d Destination, s storage.Sink) {
+	if d.MaxAttempts == 0 {
+		d.MaxAttempts = 5
+	}
+	if d.MaxPending == 0 {
+		d.MaxPending = 100
+	}
+
+	f.destinations = append(f.destinations, &destination{
+		Destination: d,
+		sink:        s,
+		batches:     make(chan batch, d.MaxPending),
+	})
+}
+
+// FromEnv loads the destinations of the JSON file at SINKS_CONFIG_FILE.
+// Returns a nil Fanout, which publishes nothing, when no file is configured.
+func FromEnv() (*Fanout, error) {
+	path := os.Getenv("SINKS_CONFIG_FILE")
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var destinations []Destination
+	if err := json.Unmarshal(data, &destinations); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	return New(destinations)
+}
+
+// Name implements storage.Sink.
+func (f *Fanout) Name() string {
+	return "fanout"
+}
+
+// Publish queues the events matching each destination's filters without waiting for their delivery.
+// A destination with too many pending batches drops the events, which are counted in its status.
+func (f *Fanout) Publish(_ context.Context, events []model.Event) error {
+	if f == nil {
+		return nil
+	}
+
+	storedAt := time.Now()
+	for _, d := range f.destinations {
+		// Matching copies the events, the caller may reuse its slice
+		matched := d.match(events)
+		if len(matched) == 0 {
+			continue
+		}
+
+		d.pending.Add(int64(len(matched)))
+		select {
+		case d.batches <- batch{events: matched, storedAt: storedAt}:
+		default:
+			d.pending.Add(-int64(len(matched)))
+			d.dropped.Add(int64(len(matched)))
+			log.Printf("Sink %s has too many pending batches, dropped %d event(s)", d.Name, len(matched))
+		}
+	}
+	return nil
+}
+
+// Run delivers queued batches until ctx is done. Batches still queued then are not delivered.
+func (f *Fanout) Run(ctx context.Context) {
+	if f == nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range f.destinations {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.run(ctx, f.Backoff)
+		}()
+	}
+	wg.Wait()
+}
+
+// Status returns the delivery counters and lag of every destination, in config order.
+func (f *Fanout) Status() []model.SinkStatus {
+	statuses := []model.SinkStatus{}
+	if f == nil {
+		return statuses
+	}
+
+	now := time.Now()
+	for _, d := range f.destinations {
+		status := model.SinkStatus{
+			Name:            d.Name,
+			Type:            d.Type,
+			DeliveredEvents: d.delivered.Load(),
+			FailedEvents:    d.failed.Load(),
+			DroppedEvents:   d.dropped.Load(),
+			PendingEvents:   d.pending.Load(),
+		}
+		if oldest := d.oldest.Load(); oldest != 0 {
+			status.LagSeconds = now.Sub(time.Unix(0, oldest)).Seconds()
+		}
+
+		d.mu.Lock()
+		if !d.lastDeliveredAt.IsZero() {
+			lastDeliveredAt := d.lastDeliveredAt
+			status.LastDeliveredAt = &lastDeliveredAt
+		}
+		if !d.lastErrorAt.IsZero() {
+			lastErrorAt := d.lastErrorAt
+			status.LastError = d.lastError
+			status.LastErrorAt = &lastErrorAt
+		}
+		d.mu.Unlock()
+
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Close releases the sinks holding resources, such as open files.
+func (f *Fanout) Close() {
+	if f == nil {
+		return
+	}
+
+	for _, d := range f.destinations {
+		if closer, ok := d.sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("Error closing sink %s: %v", d.Name, err)
+			}
+		}
+	}
+}
+
+func (d *destination) match(events []model.Event) []model.Event {
+	var matched []model.Event
+	for _, e := range events {
+		if len(d.EventName) > 0 && !slices.Contains(d.EventName, e.EventName) {
+			continue
+		}
+		if len(d.Channel) > 0 && !slices.Contains(d.Channel, e.Channel) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	return matched
+}
+
+func (d *destination) run(ctx context.Context, retry backoff.Backoff) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case b := <-d.batches:
+			d.oldest.Store(b.storedAt.UnixNano())
+			d.deliver(ctx, b.events, retry)
+			d.oldest.Store(0)
+			d.pending.Add(-int64(len(b.events)))
+		}
+	}
+}
+
+// deliver publishes events, retrying with back off until it succeeds, MaxAttempts is reached or ctx is done.
+func (d *destination) deliver(ctx context.Context, events []model.Event, retry backoff.Backoff) {
+	for attempt := 1; ; attempt++ {
+		err := d.sink.Publish(ctx, events)
+		if err == nil {
+			d.delivered.Add(int64(len(events)))
+			d.mu.Lock()
+			d.lastDeliveredAt = time.Now().UTC()
+			d.mu.Unlock()
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		d.mu.Lock()
+		d.lastError = err.Error()
+		d.lastErrorAt = time.Now().UTC()
+		d.mu.Unlock()
+
+		if attempt >= d.MaxAttempts || errors.As(err, new(permanentError)) {
+			d.failed.Add(int64(len(events)))
+			log.Printf("Sink %s gave up on %d event(s) after %d attempt(s): %v", d.Name, len(events), attempt, err)
+			return
+		}
+
+		delay := retry.Next()
+		log.Printf("Error publishing %d event(s) to sink %s, retrying in %s: %v", len(events), d.Name, delay, err)
+		if !backoff.Sleep(ctx, delay) {
+			return
+		}
+	}
+}
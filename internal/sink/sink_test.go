@@ -0,0 +1,223 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"fast-ingest/internal/backoff"
+	"fast-ingest/internal/model"
+)
+
+// fakeSink fails the first failures publishes with err and records the delivered batches.
+type fakeSink struct {
+	mu       sync.Mutex
+	failures int
+	err      error
+	attempts int
+	batches  [][]model.Event
+}
+
+func (s *fakeSink) Name() string { return "fake" }
+
+func (s *fakeSink) Publish(_ context.Context, events []model.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts++
+	if s.failures > 0 {
+		s.failures--
+		return s.err
+	}
+	s.batches = append(s.batches, events)
+	return nil
+}
+
+func (s *fakeSink) get() (int, [][]model.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts, append([][]model.Event(nil), s.batches...)
+}
+
+// runFanout publishes events to f and waits for every destination to have nothing pending.
+func runFanout(t *testing.T, f *Fanout, events []model.Event) []model.SinkStatus {
+	t.Helper()
+
+	f.Backoff = backoff.Backoff{Initial: time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		f.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	if err := f.Publish(ctx, events); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(5 * time.Second)
+	for {
+		pending := int64(0)
+		for _, status := range f.Status() {
+			pending += status.PendingEvents
+		}
+		if pending == 0 {
+			return f.Status()
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("timed out, status: %+v", f.Status())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
+
+func TestFanoutFilters(t *testing.T) {
+	purchases, web := &fakeSink{}, &fakeSink{}
+	f := &Fanout{}
+	f.add(Destination{Name: "purchases", Type: TypeWebhook, EventName: []string{"purchase"}}, purchases)
+	f.add(Destination{Name: "web", Type: TypeFile, Channel: []string{"web"}}, web)
+
+	events := []model.Event{{EventName: "purchase", Channel: "ios"}, {EventName: "page_view", Channel: "web"}, {EventName: "purchase", Channel: "web"}}
+	statuses := runFanout(t, f, events)
+
+	if _, batches := purchases.get(); len(batches) != 1 || len(batches[0]) != 2 {
+		t.Errorf("expected the 2 purchases, got %+v", batches)
+	}
+	if _, batches := web.get(); len(batches) != 1 || len(batches[0]) != 2 || batches[0][0].EventName != "page_view" {
+		t.Errorf("expected the 2 web events, got %+v", batches)
+	}
+	if statuses[0].DeliveredEvents != 2 || statuses[1].DeliveredEvents != 2 || statuses[0].LastDeliveredAt == nil {
+		t.Errorf("unexpected status %+v", statuses)
+	}
+}
+
+func TestFanoutRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		sink          *fakeSink
+		wantAttempts  int
+		wantDelivered int64
+		wantFailed    int64
+	}{
+		{"recovers", &fakeSink{failures: 2, err: errors.New("connection refused")}, 3, 1, 0},
+		{"gives up", &fakeSink{failures: 10, err: errors.New("connection refused")}, 3, 0, 1},
+		{"permanent", &fakeSink{failures: 10, err: permanentError{errors.New("webhook responded 400 Bad Request")}}, 1, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Fanout{}
+			f.add(Destination{Name: "webhook", Type: TypeWebhook, MaxAttempts: 3}, tt.sink)
+			status := runFanout(t, f, []model.Event{{EventName: "purchase", Channel: "web"}})[0]
+
+			if attempts, _ := tt.sink.get(); attempts != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+			if status.DeliveredEvents != tt.wantDelivered || status.FailedEvents != tt.wantFailed {
+				t.Errorf("unexpected status %+v", status)
+			}
+			if status.LastError == "" || status.LastErrorAt == nil {
+				t.Errorf("expected the last error, got %+v", status)
+			}
+		})
+	}
+}
+
+func TestFanoutDropsWhenFull(t *testing.T) {
+	f := &Fanout{}
+	f.add(Destination{Name: "slow", Type: TypeWebhook, MaxPending: 1}, &fakeSink{})
+
+	// Nothing delivers, the second batch doesn't fit
+	_ = f.Publish(context.Background(), []model.Event{{EventName: "purchase", Channel: "web"}})
+	_ = f.Publish(context.Background(), []model.Event{{EventName: "purchase", Channel: "web"}, {EventName: "refund", Channel: "web"}})
+
+	status := f.Status()[0]
+	if status.PendingEvents != 1 || status.DroppedEvents != 2 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestFanoutLag(t *testing.T) {
+	f := &Fanout{}
+	f.add(Destination{Name: "webhook", Type: TypeWebhook}, &fakeSink{})
+	d := f.destinations[0]
+
+	d.oldest.Store(time.Now().Add(-time.Minute).UnixNano())
+	if lag := f.Status()[0].LagSeconds; lag < 60 || lag > 61 {
+		t.Errorf("expected a minute of lag, got %v", lag)
+	}
+}
+
+func TestNilFanout(t *testing.T) {
+	var f *Fanout
+	if err := f.Publish(context.Background(), []model.Event{{EventName: "purchase", Channel: "web"}}); err != nil {
+		t.Error(err)
+	}
+	if statuses := f.Status(); statuses == nil || len(statuses) != 0 {
+		t.Errorf("expected no status, got %+v", statuses)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		destinations []Destination
+	}{
+		{"missing name", []Destination{{Type: TypeFile, Path: "events.ndjson"}}},
+		{"duplicate name", []Destination{{Name: "a", Type: TypeWebhook, URL: "https://example.com"}, {Name: "a", Type: TypeWebhook, URL: "https://example.com"}}},
+		{"invalid type", []Destination{{Name: "a", Type: "nats"}}},
+		{"invalid url", []Destination{{Name: "a", Type: TypeWebhook, URL: "example.com/hook"}}},
+		{"missing secret", []Destination{{Name: "a", Type: TypeWebhook, URL: "https://example.com", SecretEnv: "SINK_TEST_UNSET_SECRET"}}},
+		{"missing path", []Destination{{Name: "a", Type: TypeFile}}},
+		{"negative attempts", []Destination{{Name: "a", Type: TypeWebhook, URL: "https://example.com", MaxAttempts: -1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.destinations); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	f, err := New([]Destination{{Name: "local", Type: TypeFile, Path: path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runFanout(t, f, []model.Event{{EventName: "purchase", Channel: "web"}, {EventName: "refund", Channel: "web"}})
+	f.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var lines []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, e)
+	}
+	if len(lines) != 2 || lines[1].EventName != "refund" || lines[1].DedupeKey == "" {
+		t.Errorf("unexpected lines %+v", lines)
+	}
+}
@@ -0,0 +1,102 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fast-ingest/internal/model"
+)
+
+// Headers of webhook deliveries.
+const (
+	TimestampHeader = "X-Fast-Ingest-Timestamp"
+	SignatureHeader = "X-Fast-Ingest-Signature"
+)
+
+// WebhookPayload is the JSON body of a webhook delivery.
+type WebhookPayload struct {
+	Events []Event `json:"events"`
+}
+
+// Webhook POSTs batches of events as JSON to a URL.
+type Webhook struct {
+	name   string
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// NewWebhook returns a webhook delivering to url. Deliveries are signed when secret is set.
+func NewWebhook(name, url string, secret []byte) *Webhook {
+	return &Webhook{
+		name:   name,
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Name implements storage.Sink.
+func (w *Webhook) Name() string {
+	return w.name
+}
+
+// Publish sends events in one request. Any 2xx response is a success. Other 4xx responses than 408 and 429
+// mean the payload is refused and aren't retried.
+func (w *Webhook) Publish(ctx context.Context, events []model.Event) error {
+	payload := WebhookPayload{Events: make([]Event, 0, len(events))}
+	for _, e := range events {
+		payload.Events = append(payload.Events, newEvent(e))
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return permanentError{err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fast-ingest")
+	if len(w.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, "sha256="+Sign(w.secret, timestamp, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024)) // Drain to reuse the connection
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("webhook responded %s", resp.Status)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
+
+// Sign returns the hex HMAC-SHA256 of the timestamp and body of a delivery, joined by a dot.
+// Receivers recompute it to check the signature header, and reject old timestamps to prevent replays.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
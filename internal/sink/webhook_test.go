@@ -0,0 +1,67 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"fast-ingest/internal/model"
+)
+
+func TestWebhookPublish(t *testing.T) {
+	secret := []byte("s3cret")
+
+	var payload WebhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature := strings.TrimPrefix(r.Header.Get(SignatureHeader), "sha256=")
+		if signature != Sign(secret, r.Header.Get(TimestampHeader), body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.Unmarshal(body, &payload)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook("billing", server.URL, secret)
+	if err := webhook.Publish(context.Background(), []model.Event{{EventName: "purchase", Channel: "web"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload.Events) != 1 || payload.Events[0].EventName != "purchase" || len(payload.Events[0].DedupeKey) != 64 {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
+
+func TestWebhookErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusServiceUnavailable, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			err := NewWebhook("billing", server.URL, nil).Publish(context.Background(), []model.Event{{EventName: "purchase", Channel: "web"}})
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if permanent := errors.As(err, new(permanentError)); permanent != tt.permanent {
+				t.Errorf("got permanent %v, want %v: %v", permanent, tt.permanent, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/model"
+)
+
+// Supported values for the compare option of /metrics.
+const (
+	ComparePreviousPeriod = "previous_period"
+	ComparePreviousYear   = "previous_year"
+	CompareCustom         = "custom"
+)
+
+// ComparisonWindow returns the window a metrics query is compared against.
+// For custom comparisons CompareTo defaults to CompareFrom plus the length of the current range. A custom window
+// must end by now and, for time groupings, hold at most MaxTimeBuckets buckets.
+func ComparisonWindow(metricsDTO api.MetricsRequestDTO) (time.Time, time.Time, error) {
+	from := NormalizeTimestamp(metricsDTO.From)
+	to := NormalizeTimestamp(metricsDTO.To)
+
+	switch metricsDTO.Compare {
+	case ComparePreviousPeriod:
+		return from.Add(-to.Sub(from)), from, nil
+	case ComparePreviousYear:
+		return from.AddDate(-1, 0, 0), to.AddDate(-1, 0, 0), nil
+	case CompareCustom:
+		if metricsDTO.CompareFrom == 0 {
+			return time.Time{}, time.Time{}, fmt.Errorf("compare_from is required for custom comparisons")
+		}
+		compareFrom := NormalizeTimestamp(metricsDTO.CompareFrom)
+		compareTo := compareFrom.Add(to.Sub(from))
+		if metricsDTO.CompareTo != 0 {
+			compareTo = NormalizeTimestamp(metricsDTO.CompareTo)
+		}
+		if compareFrom.After(compareTo) {
+			return time.Time{}, time.Time{}, fmt.Errorf("compare_from must be before compare_to")
+		}
+		if compareTo.After(time.Now()) {
+			return time.Time{}, time.Time{}, fmt.Errorf("compare_to must not be in the future")
+		}
+		// Comparisons are gap filled, so the window must stay within the bucket limit like the current range
+		if granularity, ok := ParseTimeGranularity(metricsDTO.GroupBy); ok && compareTo.Sub(compareFrom)/granularity.Approx() > MaxTimeBuckets {
+			return time.Time{}, time.Time{}, fmt.Errorf("too many time buckets for the comparison window (max %d)", MaxTimeBuckets)
+		}
+		return compareFrom, compareTo, nil
+	}
+
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid compare value %q", metricsDTO.Compare)
+}
+
+// getComparedMetrics runs the same query over the current and the comparison window and attaches the comparison.
+// Time breakdowns are gap filled on both sides so the nth bucket of each window can be paired up.
+func (p *PostgresStore) getComparedMetrics(ctx context.Context, metricsDTO api.MetricsRequestDTO) (model.Metrics, error) {
+	compareFrom, compareTo, err := ComparisonWindow(metricsDTO)
+	if err != nil {
+		return model.Metrics{}, err
+	}
+
+	if _, ok := ParseTimeGranularity(metricsDTO.GroupBy); ok {
+		metricsDTO.GapFill = true
+	}
+
+	current, err := p.getMetrics(ctx, metricsDTO)
+	if err != nil {
+		return model.Metrics{}, err
+	}
+
+	previousDTO := metricsDTO
+	previousDTO.From = compareFrom.Unix()
+	previousDTO.To = compareTo.Unix()
+
+	previous, err := p.getMetrics(ctx, previousDTO)
+	if err != nil {
+		return model.Metrics{}, err
+	}
+
+	current.Comparison = compareMetrics(metricsDTO.Compare, metricsDTO.OrderBy, current, previous)
+
+	return current, nil
+}
+
+// compareMetrics builds the comparison block of current against previous, pairing breakdown rows
+// by position for time groupings and by value for dimension groupings, which are then ordered by orderBy.
+func compareMetrics(mode, orderBy string, current, previous model.Metrics) *model.MetricsComparison {
+	comparison := &model.MetricsComparison{
+		Mode:                     mode,
+		From:                     previous.From,
+		To:                       previous.To,
+		TotalEvents:              previous.TotalEvents,
+		TotalUniqueEventsForUser: previous.TotalUniqueEventsForUser,
+		Delta:                    newMetricsDelta(current.TotalEvents, previous.TotalEvents, current.TotalUniqueEventsForUser, previous.TotalUniqueEventsForUser),
+	}
+
+	switch currentBreakdown := current.GroupBreakdown.(type) {
+	case []model.MetricsTimeGroupQueryResult:
+		previousBreakdown, _ := previous.GroupBreakdown.([]model.MetricsTimeGroupQueryResult)
+		comparison.GroupBreakdown = compareTimeBreakdowns(currentBreakdown, previousBreakdown)
+	case []model.MetricsChannelGroupQueryResult:
+		previousBreakdown, _ := previous.GroupBreakdown.([]model.MetricsChannelGroupQueryResult)
+		comparison.GroupBreakdown = compareChannelBreakdowns(currentBreakdown, previousBreakdown, orderBy)
+	case []model.MetricsDimensionGroupQueryResult:
+		previousBreakdown, _ := previous.GroupBreakdown.([]model.MetricsDimensionGroupQueryResult)
+		comparison.GroupBreakdown = compareDimensionBreakdowns(currentBreakdown, previousBreakdown, orderBy)
+	}
+
+	return comparison
+}
+
+// compareTimeBreakdowns pairs the nth bucket of each window. Buckets without a counterpart
+// (eg: months of different length) are compared against zero.
+func compareTimeBreakdowns(current, previous []model.MetricsTimeGroupQueryResult) []model.MetricsTimeComparisonResult {
+	results := make([]model.MetricsTimeComparisonResult, 0, len(current))
+	for i, c := range current {
+		var prev model.MetricsTimeGroupQueryResult
+		if i < len(previous) {
+			prev = previous[i]
+		}
+
+		results = append(results, model.MetricsTimeComparisonResult{
+			Bucket:                   c.Bucket,
+			ComparisonBucket:         prev.Bucket,
+			TotalEvents:              prev.TotalEvents,
+			TotalUniqueEventsForUser: prev.TotalUniqueEventsForUser,
+			Delta:                    newMetricsDelta(c.TotalEvents, prev.TotalEvents, c.TotalUniqueEventsForUser, prev.TotalUniqueEventsForUser),
+		})
+	}
+	return results
+}
+
+// compareChannelBreakdowns pairs rows by channel, see compareDimensionBreakdowns.
+func compareChannelBreakdowns(current, previous []model.MetricsChannelGroupQueryResult, orderBy string) []model.MetricsChannelComparisonResult {
+	compared := compareDimensionBreakdowns(channelDimensionRows(current), channelDimensionRows(previous), orderBy)
+
+	results := make([]model.MetricsChannelComparisonResult, 0, len(compared))
+	for _, c := range compared {
+		results = append(results, model.MetricsChannelComparisonResult{
+			Channel:                  c.Dimension,
+			TotalEvents:              c.TotalEvents,
+			TotalUniqueEventsForUser: c.TotalUniqueEventsForUser,
+			Delta:                    c.Delta,
+			Other:                    c.Other,
+		})
+	}
+	return results
+}
+
+// compareDimensionBreakdowns pairs rows by dimension value. Values present on only one side are compared against zero.
+// Rows are ordered like the current breakdown, by orderBy over the current values.
+func compareDimensionBreakdowns(current, previous []model.MetricsDimensionGroupQueryResult, orderBy string) []model.MetricsDimensionComparisonResult {
+	byDimension := make(map[string]model.MetricsDimensionGroupQueryResult, len(previous))
+	for _, prev := range previous {
+		byDimension[dimensionKey(prev.Dimension, prev.Other)] = prev
+	}
+
+	// Rows of both sides, with the current values, in the order of the current breakdown
+	rows := make([]model.MetricsDimensionGroupQueryResult, 0, len(current)+len(previous))
+	seen := make(map[string]bool, len(current))
+	for _, c := range current {
+		seen[dimensionKey(c.Dimension, c.Other)] = true
+		rows = append(rows, c)
+	}
+	for _, prev := range previous {
+		if !seen[dimensionKey(prev.Dimension, prev.Other)] {
+			rows = append(rows, model.MetricsDimensionGroupQueryResult{Dimension: prev.Dimension, Other: prev.Other})
+		}
+	}
+	sortDimensionRows(rows, orderBy)
+
+	results := make([]model.MetricsDimensionComparisonResult, 0, len(rows))
+	for _, c := range rows {
+		prev := byDimension[dimensionKey(c.Dimension, c.Other)]
+		results = append(results, model.MetricsDimensionComparisonResult{
+			Dimension:                c.Dimension,
+			TotalEvents:              prev.TotalEvents,
+			TotalUniqueEventsForUser: prev.TotalUniqueEventsForUser,
+			Delta:                    newMetricsDelta(c.TotalEvents, prev.TotalEvents, c.TotalUniqueEventsForUser, prev.TotalUniqueEventsForUser),
+			Other:                    c.Other,
+		})
+	}
+	return results
+}
+
+func newMetricsDelta(currentEvents, previousEvents, currentUsers, previousUsers int64) model.MetricsDelta {
+	return model.MetricsDelta{
+		TotalEvents:                 currentEvents - previousEvents,
+		TotalEventsPct:              percentChange(currentEvents, previousEvents),
+		TotalUniqueEventsForUser:    currentUsers - previousUsers,
+		TotalUniqueEventsForUserPct: percentChange(currentUsers, previousUsers),
+	}
+}
+
+// percentChange returns the change from previous to current in percent, or nil if previous is zero.
+func percentChange(current, previous int64) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	pct := float64(current-previous) / float64(previous) * 100
+	return &pct
+}
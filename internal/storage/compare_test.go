@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/model"
+)
+
+func TestComparisonWindow(t *testing.T) {
+	from := time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)
+	base := api.MetricsRequestDTO{From: from.Unix(), To: to.Unix()}
+
+	t.Run("previous period has the same length and ends at from", func(t *testing.T) {
+		dto := base
+		dto.Compare = ComparePreviousPeriod
+		compareFrom, compareTo, err := ComparisonWindow(dto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !compareFrom.Equal(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)) || !compareTo.Equal(from) {
+			t.Errorf("unexpected window %v - %v", compareFrom, compareTo)
+		}
+	})
+
+	t.Run("previous year shifts by one calendar year", func(t *testing.T) {
+		dto := base
+		dto.Compare = ComparePreviousYear
+		compareFrom, compareTo, err := ComparisonWindow(dto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !compareFrom.Equal(from.AddDate(-1, 0, 0)) || !compareTo.Equal(to.AddDate(-1, 0, 0)) {
+			t.Errorf("unexpected window %v - %v", compareFrom, compareTo)
+		}
+	})
+
+	t.Run("custom defaults compare_to to the current length", func(t *testing.T) {
+		dto := base
+		dto.Compare = CompareCustom
+		dto.CompareFrom = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+		_, compareTo, err := ComparisonWindow(dto)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !compareTo.Equal(time.Date(2026, 1, 8, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("unexpected compare_to %v", compareTo)
+		}
+	})
+
+	t.Run("custom requires compare_from", func(t *testing.T) {
+		dto := base
+		dto.Compare = CompareCustom
+		if _, _, err := ComparisonWindow(dto); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("custom rejects a window ending in the future", func(t *testing.T) {
+		dto := base
+		dto.Compare = CompareCustom
+		dto.CompareFrom = time.Now().Add(-time.Hour).Unix()
+		dto.CompareTo = time.Now().Add(time.Hour).Unix()
+		if _, _, err := ComparisonWindow(dto); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("custom window is held to the bucket limit", func(t *testing.T) {
+		dto := base
+		dto.Compare = CompareCustom
+		dto.GroupBy = "minute"
+		dto.CompareFrom = time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC).Unix()
+		dto.CompareTo = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC).Unix()
+		if _, _, err := ComparisonWindow(dto); err == nil {
+			t.Error("expected error")
+		}
+
+		dto.GroupBy = "day"
+		if _, _, err := ComparisonWindow(dto); err != nil {
+			t.Errorf("expected a daily breakdown to fit, got %v", err)
+		}
+	})
+
+	t.Run("unknown mode is rejected", func(t *testing.T) {
+		dto := base
+		dto.Compare = "last_week"
+		if _, _, err := ComparisonWindow(dto); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestCompareMetrics(t *testing.T) {
+	t.Run("totals delta and percentage", func(t *testing.T) {
+		comparison := compareMetrics(ComparePreviousPeriod, "",
+			model.Metrics{TotalEvents: 150, TotalUniqueEventsForUser: 10},
+			model.Metrics{TotalEvents: 100, TotalUniqueEventsForUser: 0},
+		)
+		if comparison.Delta.TotalEvents != 50 || comparison.Delta.TotalEventsPct == nil || *comparison.Delta.TotalEventsPct != 50 {
+			t.Errorf("unexpected delta %+v", comparison.Delta)
+		}
+		if comparison.Delta.TotalUniqueEventsForUserPct != nil {
+			t.Error("expected nil percentage when the comparison value is zero")
+		}
+	})
+
+	t.Run("time buckets are paired by position", func(t *testing.T) {
+		day := func(d int) time.Time { return time.Date(2026, 3, d, 0, 0, 0, 0, time.UTC) }
+		current := model.Metrics{GroupBreakdown: []model.MetricsTimeGroupQueryResult{
+			{Bucket: day(8), TotalEvents: 5},
+			{Bucket: day(9), TotalEvents: 7},
+		}}
+		previous := model.Metrics{GroupBreakdown: []model.MetricsTimeGroupQueryResult{
+			{Bucket: day(1), TotalEvents: 4},
+		}}
+
+		results := compareMetrics(ComparePreviousPeriod, "", current, previous).GroupBreakdown.([]model.MetricsTimeComparisonResult)
+		if len(results) != 2 {
+			t.Fatalf("expected 2 rows, got %d", len(results))
+		}
+		if !results[0].ComparisonBucket.Equal(day(1)) || results[0].Delta.TotalEvents != 1 {
+			t.Errorf("unexpected first row %+v", results[0])
+		}
+		if results[1].TotalEvents != 0 || results[1].Delta.TotalEvents != 7 {
+			t.Errorf("unexpected second row %+v", results[1])
+		}
+	})
+
+	t.Run("channels are paired by name", func(t *testing.T) {
+		current := model.Metrics{GroupBreakdown: []model.MetricsChannelGroupQueryResult{
+			{Channel: "web", TotalEvents: 10},
+		}}
+		previous := model.Metrics{GroupBreakdown: []model.MetricsChannelGroupQueryResult{
+			{Channel: "mobile", TotalEvents: 3},
+			{Channel: "web", TotalEvents: 8},
+		}}
+
+		results := compareMetrics(ComparePreviousPeriod, "", current, previous).GroupBreakdown.([]model.MetricsChannelComparisonResult)
+		if len(results) != 2 {
+			t.Fatalf("expected 2 rows, got %d", len(results))
+		}
+		if results[0].Channel != "mobile" || results[0].Delta.TotalEvents != -3 {
+			t.Errorf("unexpected mobile row %+v", results[0])
+		}
+		if results[1].Channel != "web" || results[1].Delta.TotalEvents != 2 {
+			t.Errorf("unexpected web row %+v", results[1])
+		}
+	})
+}
+
+func TestCompareDimensionBreakdowns(t *testing.T) {
+	current := []model.MetricsDimensionGroupQueryResult{
+		{Dimension: "spring", TotalEvents: 10},
+		{Dimension: "summer", TotalEvents: 4},
+		{Dimension: OtherDimension, TotalEvents: 2, Other: true},
+	}
+	previous := []model.MetricsDimensionGroupQueryResult{
+		{Dimension: "winter", TotalEvents: 6},
+		{Dimension: "summer", TotalEvents: 1},
+		// A group named like the other row is paired as a group of its own
+		{Dimension: OtherDimension, TotalEvents: 1},
+	}
+
+	results := compareDimensionBreakdowns(current, previous, OrderByCount)
+	if len(results) != 5 {
+		t.Fatalf("expected 5 rows, got %d", len(results))
+	}
+
+	expected := []struct {
+		dimension string
+		other     bool
+		delta     int64
+	}{
+		{"spring", false, 10},
+		{"summer", false, 3},
+		{OtherDimension, false, -1},
+		{"winter", false, -6},
+		{OtherDimension, true, 2},
+	}
+	for i, e := range expected {
+		if results[i].Dimension != e.dimension || results[i].Other != e.other || results[i].Delta.TotalEvents != e.delta {
+			t.Errorf("row %d: expected %s (other %v) with delta %d, got %+v", i, e.dimension, e.other, e.delta, results[i])
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/model"
+)
+
+// Supported values for the order_by option of /metrics.
+const (
+	OrderByCount       = "count"
+	OrderByUniqueUsers = "unique_users"
+)
+
+// OtherDimension is the value of the row that collects every group beyond the requested limit.
+// The row is flagged with Other, so it stays apart from a group whose value is __other__.
+const OtherDimension = "__other__"
+
+// dimensionKey identifies a dimension row. Values can't hold a NUL byte, so the other row never collides with a group.
+func dimensionKey(dimension string, other bool) string {
+	if other {
+		return "\x00" + OtherDimension
+	}
+	return dimension
+}
+
+// metadataDimensionPrefix selects a metadata key as the grouping, eg: metadata.plan
+const metadataDimensionPrefix = "metadata."
+
+// IsDimensionGroupBy reports whether group_by breaks metrics down by a dimension
+// rather than by time: channel, campaign_id or metadata.<key>.
+func IsDimensionGroupBy(groupBy string) bool {
+	switch groupBy {
+	case "channel", "campaign_id":
+		return true
+	}
+	key, ok := strings.CutPrefix(groupBy, metadataDimensionPrefix)
+	return ok && key != ""
+}
+
+// dimensionSQL returns the SQL expression of a dimension grouping, adding its parameters to args.
+// Missing campaign ids and metadata keys are grouped under the empty string.
+func dimensionSQL(groupBy string, args *queryArgs) string {
+	if key, ok := strings.CutPrefix(groupBy, metadataDimensionPrefix); ok {
+		return "COALESCE(metadata->>" + args.add(key) + ", '')"
+	}
+	if groupBy == "campaign_id" {
+		return "COALESCE(campaign_id, '')"
+	}
+	return "channel"
+}
+
+// dimensionOrderSQL returns the ORDER BY clause matching order_by. Groups are sorted by name by default.
+func dimensionOrderSQL(orderBy string) string {
+	switch orderBy {
+	case OrderByCount:
+		return "total_count DESC, dimension"
+	case OrderByUniqueUsers:
+		return "total_unique_event_for_user_count DESC, dimension"
+	}
+	return "dimension"
+}
+
+// getDimensionGroupQuery breaks [from, to) down by a dimension. With a limit, only the top groups are
+// returned and the remaining ones are aggregated into a single OtherDimension row. TopDimensions picks
+// the top groups instead of the limit.
+func (p *PostgresStore) getDimensionGroupQuery(ctx context.Context, metricsDTO api.MetricsRequestDTO) ([]model.MetricsDimensionGroupQueryResult, error) {
+	from := NormalizeTimestamp(metricsDTO.From)
+	to := NormalizeTimestamp(metricsDTO.To)
+
+	// A page resumes after the last value of the previous one, see pagedInQuery
+	cursor, paged, err := pageCursor(metricsDTO)
+	if err != nil {
+		return nil, err
+	}
+
+	args := queryArgs{}
+	groupQuery := `SELECT
+` + dimensionSQL(metricsDTO.GroupBy, &args) + ` AS dimension,
+COUNT(*) AS total_count,
+` + estimatedEventsSQL + ` AS estimated_count,
+COUNT(DISTINCT user_id) AS total_unique_event_for_user_count
+FROM events
+WHERE event_name = ANY(` + args.add(metricsDTO.EventNames) + `)
+AND ts >= ` + args.add(from) + ` AND ts < ` + args.add(to) + `
+`
+	if paged {
+		groupQuery += `AND ` + dimensionSQL(metricsDTO.GroupBy, &args) + ` > ` + args.add(cursor.Key) + `
+`
+	}
+	if len(metricsDTO.TopDimensions) > 0 {
+		groupQuery += `AND ` + dimensionSQL(metricsDTO.GroupBy, &args) + ` = ANY(` + args.add(metricsDTO.TopDimensions) + `)
+`
+	}
+	groupQuery += `GROUP BY dimension
+ORDER BY ` + dimensionOrderSQL(metricsDTO.OrderBy)
+	switch {
+	case len(metricsDTO.TopDimensions) > 0:
+		// Every top group is kept, the rest is collapsed below
+	case metricsDTO.Limit > 0:
+		// One extra row tells whether anything is left for the other bucket
+		groupQuery += `
+LIMIT ` + args.add(metricsDTO.Limit+1)
+	case metricsDTO.PageSize > 0:
+		// One extra row tells whether another page follows
+		groupQuery += `
+LIMIT ` + args.add(metricsDTO.PageSize+1)
+	}
+
+	rows, err := p.pool.Query(ctx, groupQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []model.MetricsDimensionGroupQueryResult
+	for rows.Next() {
+		var r model.MetricsDimensionGroupQueryResult
+		if err := rows.Scan(&r.Dimension, &r.TotalEvents, &r.EstimatedTotalEvents, &r.TotalUniqueEventsForUser); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(metricsDTO.TopDimensions) > 0 {
+		other, err := p.getOtherDimensionQuery(ctx, metricsDTO, metricsDTO.TopDimensions, from, to)
+		if err != nil {
+			return nil, err
+		}
+		if other.TotalEvents > 0 {
+			results = append(results, other)
+		}
+		return results, nil
+	}
+
+	if metricsDTO.Limit <= 0 || len(results) <= metricsDTO.Limit {
+		return results, nil
+	}
+
+	results = results[:metricsDTO.Limit]
+	other, err := p.getOtherDimensionQuery(ctx, metricsDTO, dimensionValues(results), from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(results, other), nil
+}
+
+// getOtherDimensionQuery aggregates every group of [from, to) whose value is not in keys into a single row.
+// Users are counted once across the collapsed groups, so the row stays exact.
+func (p *PostgresStore) getOtherDimensionQuery(ctx context.Context, metricsDTO api.MetricsRequestDTO, keys []string, from, to time.Time) (model.MetricsDimensionGroupQueryResult, error) {
+	args := queryArgs{}
+	otherQuery := `SELECT
+COUNT(*) AS total_count,
+` + estimatedEventsSQL + ` AS estimated_count,
+COUNT(DISTINCT user_id) AS total_unique_event_for_user_count
+FROM events
+WHERE event_name = ANY(` + args.add(metricsDTO.EventNames) + `)
+AND ts >= ` + args.add(from) + ` AND ts < ` + args.add(to) + `
+AND ` + dimensionSQL(metricsDTO.GroupBy, &args) + ` <> ALL(` + args.add(keys) + `);`
+
+	other := model.MetricsDimensionGroupQueryResult{Dimension: OtherDimension, Other: true}
+	err := p.pool.QueryRow(ctx, otherQuery, args...).Scan(&other.TotalEvents, &other.EstimatedTotalEvents, &other.TotalUniqueEventsForUser)
+	return other, err
+}
+
+// dimensionValues returns the values of the rows, the other row left out.
+func dimensionValues(rows []model.MetricsDimensionGroupQueryResult) []string {
+	values := make([]string, 0, len(rows))
+	for _, r := range rows {
+		if !r.Other {
+			values = append(values, r.Dimension)
+		}
+	}
+	return values
+}
+
+// sortDimensionRows orders rows by order_by (descending, ties by name) or by name by default.
+// The OtherDimension row always comes last.
+func sortDimensionRows(rows []model.MetricsDimensionGroupQueryResult, orderBy string) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Other != b.Other {
+			return b.Other
+		}
+		switch {
+		case orderBy == OrderByCount && a.TotalEvents != b.TotalEvents:
+			return a.TotalEvents > b.TotalEvents
+		case orderBy == OrderByUniqueUsers && a.TotalUniqueEventsForUser != b.TotalUniqueEventsForUser:
+			return a.TotalUniqueEventsForUser > b.TotalUniqueEventsForUser
+		}
+		return a.Dimension < b.Dimension
+	})
+}
+
+// dimensionBreakdown shapes dimension rows for the response. Channel breakdowns keep their channel field.
+func dimensionBreakdown(groupBy string, rows []model.MetricsDimensionGroupQueryResult) any {
+	if groupBy != "channel" {
+		return rows
+	}
+
+	results := make([]model.MetricsChannelGroupQueryResult, 0, len(rows))
+	for _, r := range rows {
+		results = append(results, model.MetricsChannelGroupQueryResult{
+			Channel:                  r.Dimension,
+			TotalEvents:              r.TotalEvents,
+			EstimatedTotalEvents:     r.EstimatedTotalEvents,
+			TotalUniqueEventsForUser: r.TotalUniqueEventsForUser,
+			Other:                    r.Other,
+		})
+	}
+	return results
+}
+
+// channelDimensionRows is the inverse of dimensionBreakdown for channel breakdowns.
+func channelDimensionRows(rows []model.MetricsChannelGroupQueryResult) []model.MetricsDimensionGroupQueryResult {
+	results := make([]model.MetricsDimensionGroupQueryResult, 0, len(rows))
+	for _, r := range rows {
+		results = append(results, model.MetricsDimensionGroupQueryResult{
+			Dimension:                r.Channel,
+			TotalEvents:              r.TotalEvents,
+			EstimatedTotalEvents:     r.EstimatedTotalEvents,
+			TotalUniqueEventsForUser: r.TotalUniqueEventsForUser,
+			Other:                    r.Other,
+		})
+	}
+	return results
+}
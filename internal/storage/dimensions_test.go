@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"testing"
+
+	"fast-ingest/internal/model"
+)
+
+func TestIsDimensionGroupBy(t *testing.T) {
+	for _, groupBy := range []string{"channel", "campaign_id", "metadata.plan", "metadata.utm.source"} {
+		if !IsDimensionGroupBy(groupBy) {
+			t.Errorf("expected %q to be a dimension", groupBy)
+		}
+	}
+	for _, groupBy := range []string{"", "hour", "metadata.", "metadata", "user_id"} {
+		if IsDimensionGroupBy(groupBy) {
+			t.Errorf("expected %q not to be a dimension", groupBy)
+		}
+	}
+}
+
+func TestDimensionSQL(t *testing.T) {
+	t.Run("metadata key is passed as a parameter", func(t *testing.T) {
+		args := queryArgs{}
+		sql := dimensionSQL("metadata.plan'; DROP TABLE events; --", &args)
+		if sql != "COALESCE(metadata->>$1, '')" {
+			t.Errorf("unexpected sql %q", sql)
+		}
+		if len(args) != 1 || args[0] != "plan'; DROP TABLE events; --" {
+			t.Errorf("unexpected args %v", args)
+		}
+	})
+
+	t.Run("campaign_id groups missing ids as empty", func(t *testing.T) {
+		args := queryArgs{}
+		if sql := dimensionSQL("campaign_id", &args); sql != "COALESCE(campaign_id, '')" || len(args) != 0 {
+			t.Errorf("unexpected sql %q with args %v", sql, args)
+		}
+	})
+}
+
+func TestSortDimensionRows(t *testing.T) {
+	rows := func() []model.MetricsDimensionGroupQueryResult {
+		return []model.MetricsDimensionGroupQueryResult{
+			{Dimension: OtherDimension, TotalEvents: 100, TotalUniqueEventsForUser: 50, Other: true},
+			{Dimension: "b", TotalEvents: 5, TotalUniqueEventsForUser: 4},
+			{Dimension: "c", TotalEvents: 9, TotalUniqueEventsForUser: 1},
+			{Dimension: "a", TotalEvents: 5, TotalUniqueEventsForUser: 2},
+		}
+	}
+	order := func(rows []model.MetricsDimensionGroupQueryResult) []string {
+		var dimensions []string
+		for _, r := range rows {
+			dimensions = append(dimensions, r.Dimension)
+		}
+		return dimensions
+	}
+
+	tests := []struct {
+		orderBy string
+		want    []string
+	}{
+		{"", []string{"a", "b", "c", OtherDimension}},
+		{OrderByCount, []string{"c", "a", "b", OtherDimension}},
+		{OrderByUniqueUsers, []string{"b", "a", "c", OtherDimension}},
+	}
+
+	for _, tt := range tests {
+		t.Run("order_by="+tt.orderBy, func(t *testing.T) {
+			r := rows()
+			sortDimensionRows(r, tt.orderBy)
+			if got := order(r); len(got) != len(tt.want) || got[0] != tt.want[0] || got[1] != tt.want[1] || got[2] != tt.want[2] || got[3] != tt.want[3] {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
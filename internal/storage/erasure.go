@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/ingest"
+	"fast-ingest/internal/lists"
+	"fast-ingest/internal/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Supported erasure modes.
+const (
+	ErasureDelete    = "delete"
+	ErasureAnonymize = "anonymize"
+)
+
+// Status values of the erasure audit log.
+const (
+	erasureStatusDone   = "done"
+	erasureStatusFailed = "failed"
+)
+
+// ErasureConfig configures how users are anonymized.
+type ErasureConfig struct {
+	// Salt keys the hash that replaces user_id. Without it every erasure uses a random salt,
+	// so anonymized ids can't be linked across requests.
+	Salt []byte
+
+	// MetadataKeys are removed from every anonymized event.
+	MetadataKeys []string
+}
+
+// DefaultErasureMetadataKeys are the metadata keys scrubbed when ERASURE_METADATA_KEYS is not set.
+var DefaultErasureMetadataKeys = []string{"email", "phone", "name", "ip"}
+
+// ErasureConfigFromEnv reads ERASURE_SALT and ERASURE_METADATA_KEYS (comma separated).
+func ErasureConfigFromEnv() ErasureConfig {
+	config := ErasureConfig{
+		Salt:         []byte(os.Getenv("ERASURE_SALT")),
+		MetadataKeys: DefaultErasureMetadataKeys,
+	}
+
+	if keys, ok := os.LookupEnv("ERASURE_METADATA_KEYS"); ok {
+		config.MetadataKeys = lists.Split(keys)
+	}
+
+	return config
+}
+
+// EraseUser deletes or anonymizes every event of a user, rebuilds the rollup buckets those events
+// contributed to and records the request in erasure_audit_log, all in one transaction.
+// Events of the user still in the ingest queue or written while it runs are not covered.
+func (p *PostgresStore) EraseUser(ctx context.Context, erasureDTO api.ErasureRequestDTO) (model.ErasureResult, error) {
+	result, err := p.eraseUser(ctx, erasureDTO)
+	if err != nil {
+		// Failed attempts are audited too, outside of the rolled back transaction
+		_, auditErr := p.pool.Exec(ctx, `
+			INSERT INTO erasure_audit_log (job_id, user_id_hash, mode, requested_by, status, error)
+			VALUES ($1,$2,$3,$4,$5,$6);
+		`, NullIfEmpty(erasureDTO.JobID), p.auditUserID(erasureDTO.UserID), erasureDTO.Mode, erasureDTO.RequestedBy, erasureStatusFailed, err.Error())
+		if auditErr != nil {
+			log.Printf("Failed to audit failed erasure: %v", auditErr)
+		}
+		return model.ErasureResult{}, err
+	}
+
+	return result, nil
+}
+
+func (p *PostgresStore) eraseUser(ctx context.Context, erasureDTO api.ErasureRequestDTO) (model.ErasureResult, error) {
+	result := model.ErasureResult{Mode: erasureDTO.Mode}
+
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return model.ErasureResult{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Keep the writer and the compactor off the rollups until the rebuilt buckets are committed,
+	// they would otherwise fold their changes into rows about to be replaced
+	if _, err := tx.Exec(ctx, `LOCK TABLE events_hourly_rollup, events_daily_rollup IN SHARE ROW EXCLUSIVE MODE;`); err != nil {
+		return model.ErasureResult{}, err
+	}
+
+	type affectedQuery struct {
+		query string
+		args  []any
+	}
+	var affectedQueries []affectedQuery
+	switch erasureDTO.Mode {
+	case ErasureDelete:
+		affectedQueries = []affectedQuery{{`DELETE FROM events WHERE user_id = $1 RETURNING ts`, []any{erasureDTO.UserID}}}
+	case ErasureAnonymize:
+		result.ScrubbedKeys = p.erasureMetadataKeys(erasureDTO.MetadataKeys)
+		anonID := p.anonymizeUserID(erasureDTO.UserID)
+		ids, keys, err := anonymizedDedupeKeys(ctx, tx, erasureDTO.UserID, anonID)
+		if err != nil {
+			return model.ErasureResult{}, err
+		}
+		affectedQueries = []affectedQuery{
+			// Events already stored under the anonymized key, eg: retries ingested after an earlier erasure, are
+			// duplicates and would violate the unique key
+			{`DELETE FROM events e USING unnest($1::bigint[], $2::text[]) AS k(id, dedupe_key)
+WHERE e.id = k.id AND EXISTS (SELECT 1 FROM events a WHERE a.dedupe_key = k.dedupe_key AND a.id <> e.id) RETURNING e.ts`, []any{ids, keys}},
+			// The dedupe key is recomputed, the original one could be derived from the user id to look the events up
+			{`UPDATE events e SET user_id = $3, dedupe_key = k.dedupe_key, metadata = e.metadata - $4::text[]
+FROM unnest($1::bigint[], $2::text[]) AS k(id, dedupe_key) WHERE e.id = k.id RETURNING e.ts`,
+				[]any{ids, keys, anonID, result.ScrubbedKeys}},
+		}
+	default:
+		return model.ErasureResult{}, fmt.Errorf("invalid erasure mode %q", erasureDTO.Mode)
+	}
+
+	var hours, days []time.Time
+	for _, affected := range affectedQueries {
+		rows, err := tx.Query(ctx, `
+			WITH affected AS (`+affected.query+`)
+			SELECT DATE_TRUNC('hour', ts, 'UTC') AS hour, COUNT(*)
+			FROM affected
+			GROUP BY hour
+			ORDER BY hour;
+		`, affected.args...)
+		if err != nil {
+			return model.ErasureResult{}, err
+		}
+
+		for rows.Next() {
+			var hour time.Time
+			var count int64
+			if err := rows.Scan(&hour, &count); err != nil {
+				rows.Close()
+				return model.ErasureResult{}, err
+			}
+			result.EventsAffected += count
+			if !slices.ContainsFunc(hours, hour.Equal) {
+				hours = append(hours, hour)
+			}
+			if day := hour.UTC().Truncate(24 * time.Hour); !slices.ContainsFunc(days, day.Equal) {
+				days = append(days, day)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return model.ErasureResult{}, err
+		}
+	}
+
+	// Hours before the hourly rollups started were never rolled up
+	var coveredFrom time.Time
+	if err := tx.QueryRow(ctx, `SELECT covered_from FROM rollup_state WHERE name = 'hourly';`).Scan(&coveredFrom); err != nil {
+		return model.ErasureResult{}, err
+	}
+	for _, hour := range hours {
+		if hour.Before(coveredFrom) {
+			continue
+		}
+		if _, err := rebuildRollupBucket(ctx, tx, hourlyRollupTable, hour, time.Hour); err != nil {
+			return model.ErasureResult{}, err
+		}
+		result.HourlyBucketsRebuilt++
+	}
+
+	for _, day := range days {
+		if _, err := rebuildRollupBucket(ctx, tx, dailyRollupTable, day, 24*time.Hour); err != nil {
+			return model.ErasureResult{}, err
+		}
+		result.DailyBucketsRebuilt++
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO erasure_audit_log (job_id, user_id_hash, mode, scrubbed_keys, requested_by, status, events_affected)
+		VALUES ($1,$2,$3,COALESCE($4::text[], '{}'),$5,$6,$7);
+	`, NullIfEmpty(erasureDTO.JobID), p.auditUserID(erasureDTO.UserID), erasureDTO.Mode, result.ScrubbedKeys, erasureDTO.RequestedBy, erasureStatusDone, result.EventsAffected)
+	if err != nil {
+		return model.ErasureResult{}, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return model.ErasureResult{}, err
+	}
+
+	log.Printf("Erased user %s (%s, %d events)", p.auditUserID(erasureDTO.UserID)[:12], erasureDTO.Mode, result.EventsAffected)
+
+	return result, nil
+}
+
+// anonymizedDedupeKeys locks the events of a user and returns their ids with the dedupe keys they get once
+// their user id is replaced by anonID.
+func anonymizedDedupeKeys(ctx context.Context, tx pgx.Tx, userID, anonID string) ([]int64, []string, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, dedupe_key, event_name, COALESCE(channel, ''), COALESCE(campaign_id, ''), ts, COALESCE(message_id, '')
+		FROM events
+		WHERE user_id = $1
+		FOR UPDATE;
+	`, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var keys []string
+	for rows.Next() {
+		var id int64
+		var key string
+		var ts time.Time
+		e := model.Event{UserID: userID}
+		if err := rows.Scan(&id, &key, &e.EventName, &e.Channel, &e.CampaignID, &ts, &e.MessageID); err != nil {
+			return nil, nil, err
+		}
+		e.Timestamp = ts.Unix()
+		ids = append(ids, id)
+		keys = append(keys, anonymizedDedupeKey(key, e, anonID))
+	}
+	return ids, keys, rows.Err()
+}
+
+// anonymizedDedupeKey returns the dedupe key of a stored event once its user id is replaced by anonID.
+// Events keyed by their message id keep their key, it doesn't depend on the user.
+func anonymizedDedupeKey(key string, e model.Event, anonID string) string {
+	if e.MessageID == "" && key != DedupeKey(e) {
+		// Keyed by a message id stored before the message_id column existed
+		return key
+	}
+	e.UserID = anonID
+	return DedupeKey(e)
+}
+
+// erasureMetadataKeys merges the configured metadata keys with the ones of the request. Keys are top-level,
+// so the enriched fields are always dropped as a whole: they hold the client IP and location, nested out of reach.
+func (p *PostgresStore) erasureMetadataKeys(requested []string) []string {
+	keys := slices.Clone(p.erasure.MetadataKeys)
+	for _, key := range append(requested, ingest.EnrichedKey) {
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// anonymizeUserID replaces a user id with a keyed hash, eg: anon_3f2a...
+func (p *PostgresStore) anonymizeUserID(userID string) string {
+	salt := p.erasure.Salt
+	if len(salt) == 0 {
+		salt = make([]byte, 32)
+		_, _ = rand.Read(salt)
+	}
+
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(userID))
+	return "anon_" + hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// auditKey keys the audit log hashes while ERASURE_SALT is not set, for the lifetime of the process.
+// Hashes of the server and of cmd/erase, or of two runs, don't match then, which is logged on first use.
+var auditKey = sync.OnceValue(func() []byte {
+	log.Println("ERASURE_SALT is not set, erasure audit entries use a random key and can only be matched to a user within this run")
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+})
+
+// auditUserID identifies a user in the audit log without storing the user id itself. It is keyed like
+// anonymizeUserID, as a plain hash of a short id is easily reversed, but differs from the anonymized id so
+// audit entries don't point at the anonymized events.
+func (p *PostgresStore) auditUserID(userID string) string {
+	salt := p.erasure.Salt
+	if len(salt) == 0 {
+		salt = auditKey()
+	}
+
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte("audit|" + userID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
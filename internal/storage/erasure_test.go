@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+
+	"fast-ingest/internal/model"
+)
+
+func TestErasureConfigFromEnv(t *testing.T) {
+	t.Run("defaults when unset", func(t *testing.T) {
+		config := ErasureConfigFromEnv()
+		if !slices.Equal(config.MetadataKeys, DefaultErasureMetadataKeys) {
+			t.Errorf("expected default keys, got %v", config.MetadataKeys)
+		}
+	})
+
+	t.Run("reads keys and salt from env", func(t *testing.T) {
+		t.Setenv("ERASURE_SALT", "secret")
+		t.Setenv("ERASURE_METADATA_KEYS", " email , address,,")
+		config := ErasureConfigFromEnv()
+		if string(config.Salt) != "secret" {
+			t.Errorf("unexpected salt %q", config.Salt)
+		}
+		if !slices.Equal(config.MetadataKeys, []string{"email", "address"}) {
+			t.Errorf("unexpected keys %v", config.MetadataKeys)
+		}
+	})
+
+	t.Run("empty value scrubs nothing", func(t *testing.T) {
+		t.Setenv("ERASURE_METADATA_KEYS", "")
+		if keys := ErasureConfigFromEnv().MetadataKeys; len(keys) != 0 {
+			t.Errorf("expected no keys, got %v", keys)
+		}
+	})
+}
+
+func TestErasureMetadataKeys(t *testing.T) {
+	p := &PostgresStore{erasure: ErasureConfig{MetadataKeys: []string{"email", "ip"}}}
+
+	keys := p.erasureMetadataKeys([]string{"ip", "address"})
+	if !slices.Equal(keys, []string{"email", "ip", "address", "_enriched"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	if !slices.Equal(p.erasure.MetadataKeys, []string{"email", "ip"}) {
+		t.Errorf("configured keys were modified: %v", p.erasure.MetadataKeys)
+	}
+
+	// Enriched fields hold the client IP and location, they are dropped even when no key is configured
+	empty := &PostgresStore{}
+	if keys := empty.erasureMetadataKeys(nil); !slices.Equal(keys, []string{"_enriched"}) {
+		t.Errorf("expected only the enriched fields, got %#v", keys)
+	}
+}
+
+func TestAnonymizeUserID(t *testing.T) {
+	salted := &PostgresStore{erasure: ErasureConfig{Salt: []byte("secret")}}
+
+	id := salted.anonymizeUserID("user-1")
+	if !strings.HasPrefix(id, "anon_") || len(id) != len("anon_")+32 {
+		t.Errorf("unexpected anonymized id %q", id)
+	}
+	if again := salted.anonymizeUserID("user-1"); again != id {
+		t.Errorf("expected a stable id with a salt, got %q and %q", id, again)
+	}
+	if other := salted.anonymizeUserID("user-2"); other == id {
+		t.Error("expected different users to get different ids")
+	}
+
+	unsalted := &PostgresStore{}
+	if unsalted.anonymizeUserID("user-1") == unsalted.anonymizeUserID("user-1") {
+		t.Error("expected a random salt without ERASURE_SALT")
+	}
+}
+
+func TestAuditUserID(t *testing.T) {
+	salted := &PostgresStore{erasure: ErasureConfig{Salt: []byte("secret")}}
+
+	hash := salted.auditUserID("12345")
+	if len(hash) != 64 || hash != salted.auditUserID("12345") {
+		t.Errorf("expected a stable hash, got %q", hash)
+	}
+	if hash == sha256Hex("12345") {
+		t.Error("expected the hash to be keyed")
+	}
+	if strings.HasPrefix(salted.anonymizeUserID("12345"), "anon_"+hash[:32]) {
+		t.Error("expected the audit hash to differ from the anonymized id")
+	}
+
+	// Without a salt the key is random but stays the same for the process, so entries of a user can be matched
+	unsalted := &PostgresStore{}
+	if unsalted.auditUserID("12345") != unsalted.auditUserID("12345") {
+		t.Error("expected a stable hash within the process")
+	}
+}
+
+func TestAnonymizedDedupeKey(t *testing.T) {
+	base := model.Event{EventName: "track", Channel: "web", UserID: "user-1", Timestamp: 1769904000}
+	first, second := base, base
+	first.MessageID = "msg-1"
+	second.MessageID = "msg-2"
+
+	// Two messages of the user in the same second must keep distinct keys
+	firstKey := anonymizedDedupeKey(DedupeKey(first), first, "anon_1")
+	secondKey := anonymizedDedupeKey(DedupeKey(second), second, "anon_1")
+	if firstKey == secondKey {
+		t.Fatal("expected messages in the same second to keep distinct keys")
+	}
+	if firstKey != DedupeKey(first) || secondKey != DedupeKey(second) {
+		t.Error("expected message keyed events to keep their key")
+	}
+
+	anonymized := base
+	anonymized.UserID = "anon_1"
+	if key := anonymizedDedupeKey(DedupeKey(base), base, "anon_1"); key != DedupeKey(anonymized) {
+		t.Errorf("expected the key of the anonymized event, got %q", key)
+	}
+
+	// Stored before message_id had a column, the key isn't derived from the user
+	legacy := DedupeKey(first)
+	if key := anonymizedDedupeKey(legacy, base, "anon_1"); key != legacy {
+		t.Errorf("expected a message key without message_id to be kept, got %q", key)
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/model"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrEventNotFound is returned when no stored event has the requested dedupe key.
+var ErrEventNotFound = errors.New("event not found")
+
+// eventRow is the scan target of an events query. ts and ingestedAt are converted into the StoredEvent format after scanning.
+type eventRow struct {
+	model.StoredEvent
+	ts         time.Time
+	ingestedAt time.Time
+}
+
+// eventField maps a selectable field onto its column and scan target.
+type eventField struct {
+	column string
+	target func(r *eventRow) any
+}
+
+// EventFields lists the fields GET /events can return, in response order.
+var EventFields = []string{"id", "dedupe_key", "event_name", "channel", "campaign_id", "user_id", "timestamp", "tags", "metadata", "ingested_at"}
+
+var eventFields = map[string]eventField{
+	"id":          {"id", func(r *eventRow) any { return &r.ID }},
+	"dedupe_key":  {"dedupe_key", func(r *eventRow) any { return &r.DedupeKey }},
+	"event_name":  {"event_name", func(r *eventRow) any { return &r.EventName }},
+	"channel":     {"channel", func(r *eventRow) any { return &r.Channel }},
+	"campaign_id": {"COALESCE(campaign_id, '')", func(r *eventRow) any { return &r.CampaignID }},
+	"user_id":     {"user_id", func(r *eventRow) any { return &r.UserID }},
+	"timestamp":   {"ts", func(r *eventRow) any { return &r.ts }},
+	"tags":        {"tags", func(r *eventRow) any { return &r.Tags }},
+	"metadata":    {"metadata", func(r *eventRow) any { return &r.Metadata }},
+	"ingested_at": {"ingested_at", func(r *eventRow) any { return &r.ingestedAt }},
+}
+
+// IsEventField reports whether name can be requested in the fields parameter of GET /events.
+func IsEventField(name string) bool {
+	_, ok := eventFields[name]
+	return ok
+}
+
+// eventCursor is the position of the last event of a page in the (ts, id) keyset order.
+type eventCursor struct {
+	TS int64 `json:"ts"`
+	ID int64 `json:"id"`
+}
+
+// decodeEventCursor checks an opaque events cursor.
+func decodeEventCursor(token string) (eventCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return eventCursor{}, ErrInvalidCursor
+	}
+
+	var cursor eventCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID <= 0 {
+		return eventCursor{}, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+func (c eventCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// SearchEvents returns the stored events matching the filters, newest first.
+// Pages are keyset paginated on (ts, id) so they stay consistent while new events are written.
+func (p *PostgresStore) SearchEvents(ctx context.Context, searchDTO api.EventSearchRequestDTO) (model.EventPage, error) {
+	fields := searchDTO.Fields
+	if len(fields) == 0 {
+		fields = EventFields
+	}
+
+	args := queryArgs{}
+	var conditions []string
+	addEquals := func(column, value string) {
+		if value != "" {
+			conditions = append(conditions, column+" = "+args.add(value))
+		}
+	}
+	addEquals("event_name", searchDTO.EventName)
+	addEquals("user_id", searchDTO.UserID)
+
+	if searchDTO.From != 0 {
+		conditions = append(conditions, "ts >= "+args.add(NormalizeTimestamp(searchDTO.From)))
+	}
+	if searchDTO.To != 0 {
+		conditions = append(conditions, "ts < "+args.add(NormalizeTimestamp(searchDTO.To)))
+	}
+
+	conditions = append(conditions, eventFilterConditions(&args, searchDTO.EventFilterDTO)...)
+
+	if searchDTO.Cursor != "" {
+		cursor, err := decodeEventCursor(searchDTO.Cursor)
+		if err != nil {
+			return model.EventPage{}, err
+		}
+		conditions = append(conditions, "(ts, id) < ("+args.add(time.UnixMicro(cursor.TS).UTC())+", "+args.add(cursor.ID)+")")
+	}
+
+	columns := []string{"ts", "id"}
+	for _, field := range fields {
+		columns = append(columns, eventFields[field].column)
+	}
+
+	searchQuery := `SELECT ` + strings.Join(columns, ", ") + `
+FROM events`
+	if len(conditions) > 0 {
+		searchQuery += `
+WHERE ` + strings.Join(conditions, "\nAND ")
+	}
+	// One extra row tells whether there is a next page
+	searchQuery += `
+ORDER BY ts DESC, id DESC
+LIMIT ` + args.add(searchDTO.PageSize+1) + `;`
+
+	rows, err := p.pool.Query(ctx, searchQuery, args...)
+	if err != nil {
+		return model.EventPage{}, err
+	}
+	defer rows.Close()
+
+	page := model.EventPage{Events: []model.StoredEvent{}}
+	var last eventCursor
+	for rows.Next() {
+		if len(page.Events) == searchDTO.PageSize {
+			page.NextCursor = last.encode()
+			break
+		}
+
+		var ts time.Time
+		var id int64
+		event, err := scanEventRow(rows, fields, &ts, &id)
+		if err != nil {
+			return model.EventPage{}, err
+		}
+		page.Events = append(page.Events, event)
+		last = eventCursor{TS: ts.UnixMicro(), ID: id}
+	}
+
+	if err := rows.Err(); err != nil {
+		return model.EventPage{}, err
+	}
+
+	return page, nil
+}
+
+// eventFilterConditions returns the WHERE conditions of the channel, campaign, tag and metadata filters.
+func eventFilterConditions(args *queryArgs, filters api.EventFilterDTO) []string {
+	var conditions []string
+	if filters.Channel != "" {
+		conditions = append(conditions, "channel = "+args.add(filters.Channel))
+	}
+	if filters.CampaignID != "" {
+		conditions = append(conditions, "campaign_id = "+args.add(filters.CampaignID))
+	}
+	if len(filters.Tags) > 0 {
+		tagsJSON, _ := json.Marshal(filters.Tags)
+		conditions = append(conditions, "tags @> "+args.add(string(tagsJSON))+"::jsonb")
+	}
+	for key, value := range filters.Metadata {
+		conditions = append(conditions, "metadata->>"+args.add(key)+" = "+args.add(value))
+	}
+	return conditions
+}
+
+// GetEvent returns the stored event with the given dedupe key, with every field.
+func (p *PostgresStore) GetEvent(ctx context.Context, dedupeKey string) (model.StoredEvent, error) {
+	columns := []string{"ts", "id"}
+	for _, field := range EventFields {
+		columns = append(columns, eventFields[field].column)
+	}
+
+	var ts time.Time
+	var id int64
+	row := p.pool.QueryRow(ctx, `SELECT `+strings.Join(columns, ", ")+` FROM events WHERE dedupe_key = $1;`, dedupeKey)
+	event, err := scanEventRow(row, EventFields, &ts, &id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return model.StoredEvent{}, ErrEventNotFound
+	}
+	return event, err
+}
+
+// scanEventRow scans a row made of ts, id and then the given fields.
+func scanEventRow(row pgx.Row, fields []string, ts *time.Time, id *int64) (model.StoredEvent, error) {
+	var r eventRow
+	targets := []any{ts, id}
+	for _, field := range fields {
+		targets = append(targets, eventFields[field].target(&r))
+	}
+
+	if err := row.Scan(targets...); err != nil {
+		return model.StoredEvent{}, err
+	}
+
+	if !r.ts.IsZero() {
+		r.Timestamp = r.ts.Unix()
+	}
+	if !r.ingestedAt.IsZero() {
+		r.IngestedAt = r.ingestedAt.UTC().Format(time.RFC3339)
+	}
+
+	return r.StoredEvent, nil
+}
+
+// exportFetchSize is how many rows each FETCH of an export cursor reads, bounding the memory of an export.
+const exportFetchSize = 1000
+
+// ExportEvents streams every event of the export range to fn in (ts, id) order through a server-side cursor.
+func (p *PostgresStore) ExportEvents(ctx context.Context, exportDTO api.ExportRequestDTO, fn func(model.StoredEvent) error) error {
+	args := queryArgs{}
+	conditions := []string{
+		"ts >= " + args.add(NormalizeTimestamp(exportDTO.From)),
+		"ts < " + args.add(NormalizeTimestamp(exportDTO.To)),
+	}
+	if len(exportDTO.EventNames) > 0 {
+		conditions = append(conditions, "event_name = ANY("+args.add(exportDTO.EventNames)+")")
+	}
+	conditions = append(conditions, eventFilterConditions(&args, exportDTO.EventFilterDTO)...)
+
+	columns := []string{"ts", "id"}
+	for _, field := range EventFields {
+		columns = append(columns, eventFields[field].column)
+	}
+
+	// Cursors only live within a transaction, a read only one also gives the export a consistent snapshot
+	tx, err := p.pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly, IsoLevel: pgx.RepeatableRead})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `DECLARE export_cursor NO SCROLL CURSOR FOR
+SELECT `+strings.Join(columns, ", ")+`
+FROM events
+WHERE `+strings.Join(conditions, "\nAND ")+`
+ORDER BY ts, id;`, args...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rows, err := tx.Query(ctx, `FETCH FORWARD `+strconv.Itoa(exportFetchSize)+` FROM export_cursor;`)
+		if err != nil {
+			return err
+		}
+
+		fetched := 0
+		for rows.Next() {
+			fetched++
+
+			var ts time.Time
+			var id int64
+			event, err := scanEventRow(rows, EventFields, &ts, &id)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			if err := fn(event); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if fetched < exportFetchSize {
+			return nil
+		}
+	}
+}
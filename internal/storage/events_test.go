@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+)
+
+func TestEventCursor(t *testing.T) {
+	t.Run("round trips with microsecond precision", func(t *testing.T) {
+		ts := time.Date(2026, 2, 1, 13, 45, 0, 123456000, time.UTC)
+		cursor := eventCursor{TS: ts.UnixMicro(), ID: 42}
+
+		decoded, err := decodeEventCursor(cursor.encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != cursor || !time.UnixMicro(decoded.TS).Equal(ts) {
+			t.Errorf("expected %+v, got %+v", cursor, decoded)
+		}
+	})
+
+	t.Run("malformed cursor is rejected", func(t *testing.T) {
+		for _, token := range []string{"", "not base64!", "e30"} {
+			if _, err := decodeEventCursor(token); err != ErrInvalidCursor {
+				t.Errorf("expected ErrInvalidCursor for %q, got %v", token, err)
+			}
+		}
+	})
+}
+
+func TestEventFields(t *testing.T) {
+	for _, field := range EventFields {
+		if !IsEventField(field) {
+			t.Errorf("expected %q to be selectable", field)
+		}
+	}
+	if IsEventField("ts; DROP TABLE events") {
+		t.Error("expected unknown field to be rejected")
+	}
+}
+
+func TestEventFilterConditions(t *testing.T) {
+	args := queryArgs{"purchase"}
+	conditions := eventFilterConditions(&args, api.EventFilterDTO{
+		Channel:    "email",
+		CampaignID: "spring",
+		Tags:       []string{"vip"},
+		Metadata:   map[string]string{"plan": "pro"},
+	})
+
+	want := []string{
+		"channel = $2",
+		"campaign_id = $3",
+		"tags @> $4::jsonb",
+		"metadata->>$5 = $6",
+	}
+	if !slices.Equal(conditions, want) {
+		t.Errorf("expected %q, got %q", want, conditions)
+	}
+	if !slices.Equal(args, queryArgs{"purchase", "email", "spring", `["vip"]`, "plan", "pro"}) {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	if conditions := eventFilterConditions(&args, api.EventFilterDTO{}); len(conditions) != 0 {
+		t.Errorf("expected no condition without filters, got %q", conditions)
+	}
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/formula"
+	"fast-ingest/internal/model"
+)
+
+// getMultiEventMetrics computes the combined metrics of every requested event name,
+// the metrics of each event name on its own, and the formulas declared over them.
+func (p *PostgresStore) getMultiEventMetrics(ctx context.Context, metricsDTO api.MetricsRequestDTO) (model.Metrics, error) {
+	combinedDTO := metricsDTO
+	combinedDTO.Formulas = nil
+
+	metrics, err := p.getComparableMetrics(ctx, combinedDTO)
+	if err != nil {
+		return model.Metrics{}, err
+	}
+
+	if len(metricsDTO.EventNames) == 1 {
+		metrics.Events = []model.Metrics{metrics}
+	} else {
+		// Each event is broken down by the top groups of the combined events, so its rows line up with the
+		// other events' rows and its other row collapses the same groups
+		topDimensions := breakdownDimensions(metrics.GroupBreakdown)
+		for _, eventName := range metricsDTO.EventNames {
+			eventDTO := combinedDTO
+			eventDTO.EventNames = []string{eventName}
+			if metricsDTO.Limit > 0 {
+				eventDTO.TopDimensions = topDimensions
+			}
+
+			eventMetrics, err := p.getComparableMetrics(ctx, eventDTO)
+			if err != nil {
+				return model.Metrics{}, err
+			}
+			metrics.Events = append(metrics.Events, eventMetrics)
+		}
+	}
+
+	metrics.Formulas, err = evaluateFormulas(metricsDTO.Formulas, metrics.Events)
+	if err != nil {
+		return model.Metrics{}, err
+	}
+
+	return metrics, nil
+}
+
+// breakdownDimensions returns the values of a dimension breakdown, the other row left out.
+func breakdownDimensions(breakdown any) []string {
+	switch rows := breakdown.(type) {
+	case []model.MetricsChannelGroupQueryResult:
+		return dimensionValues(channelDimensionRows(rows))
+	case []model.MetricsDimensionGroupQueryResult:
+		return dimensionValues(rows)
+	}
+	return nil
+}
+
+// formulaGroup holds the values a formula can reference within a single breakdown row.
+type formulaGroup struct {
+	bucket    time.Time
+	dimension string
+	other     bool
+	values    map[formula.Ref]float64
+}
+
+// Kinds of breakdown formulas are evaluated over.
+const (
+	formulaTimeGroups = iota + 1
+	formulaChannelGroups
+	formulaDimensionGroups
+)
+
+// evaluateFormulas evaluates each formula over the totals and, row by row, over the breakdowns of events.
+// Rows are matched by bucket or dimension value; an event without a given row contributes zero.
+func evaluateFormulas(formulas []api.FormulaDTO, events []model.Metrics) ([]model.FormulaMetrics, error) {
+	byEvent := make(map[string]model.Metrics, len(events))
+	for _, e := range events {
+		byEvent[e.EventName] = e
+	}
+
+	var results []model.FormulaMetrics
+	for _, f := range formulas {
+		expr, err := formula.Parse(f.Expression)
+		if err != nil {
+			return nil, err
+		}
+
+		totals := func(ref formula.Ref) float64 {
+			if ref.Unique {
+				return float64(byEvent[ref.Event].TotalUniqueEventsForUser)
+			}
+			return float64(byEvent[ref.Event].TotalEvents)
+		}
+
+		result := model.FormulaMetrics{
+			Name:       f.Name,
+			Expression: f.Expression,
+			Value:      evalFormula(expr, totals),
+		}
+
+		kind, groups := formulaGroups(expr.Events(), byEvent)
+		switch kind {
+		case formulaTimeGroups:
+			breakdown := make([]model.FormulaTimeGroupResult, 0, len(groups))
+			for _, g := range groups {
+				breakdown = append(breakdown, model.FormulaTimeGroupResult{Bucket: g.bucket, Value: evalFormula(expr, groupLookup(g))})
+			}
+			result.GroupBreakdown = breakdown
+		case formulaChannelGroups:
+			breakdown := make([]model.FormulaChannelGroupResult, 0, len(groups))
+			for _, g := range groups {
+				breakdown = append(breakdown, model.FormulaChannelGroupResult{Channel: g.dimension, Value: evalFormula(expr, groupLookup(g)), Other: g.other})
+			}
+			result.GroupBreakdown = breakdown
+		case formulaDimensionGroups:
+			breakdown := make([]model.FormulaDimensionGroupResult, 0, len(groups))
+			for _, g := range groups {
+				breakdown = append(breakdown, model.FormulaDimensionGroupResult{Dimension: g.dimension, Value: evalFormula(expr, groupLookup(g)), Other: g.other})
+			}
+			result.GroupBreakdown = breakdown
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// formulaGroups merges the breakdown rows of the given events into sorted groups and reports their kind,
+// zero when the events have no breakdown. Dimension groups are sorted by value with OtherDimension last.
+func formulaGroups(eventNames []string, byEvent map[string]model.Metrics) (int, []*formulaGroup) {
+	kind := 0
+	groups := make(map[any]*formulaGroup)
+	group := func(key any) *formulaGroup {
+		g, ok := groups[key]
+		if !ok {
+			g = &formulaGroup{values: make(map[formula.Ref]float64)}
+			groups[key] = g
+		}
+		return g
+	}
+
+	for _, eventName := range eventNames {
+		switch breakdown := byEvent[eventName].GroupBreakdown.(type) {
+		case []model.MetricsTimeGroupQueryResult:
+			kind = formulaTimeGroups
+			for _, row := range breakdown {
+				g := group(row.Bucket.Unix())
+				g.bucket = row.Bucket
+				g.values[formula.Ref{Event: eventName}] = float64(row.TotalEvents)
+				g.values[formula.Ref{Event: eventName, Unique: true}] = float64(row.TotalUniqueEventsForUser)
+			}
+		case []model.MetricsChannelGroupQueryResult:
+			kind = formulaChannelGroups
+			for _, row := range breakdown {
+				g := group(dimensionKey(row.Channel, row.Other))
+				g.dimension, g.other = row.Channel, row.Other
+				g.values[formula.Ref{Event: eventName}] = float64(row.TotalEvents)
+				g.values[formula.Ref{Event: eventName, Unique: true}] = float64(row.TotalUniqueEventsForUser)
+			}
+		case []model.MetricsDimensionGroupQueryResult:
+			kind = formulaDimensionGroups
+			for _, row := range breakdown {
+				g := group(dimensionKey(row.Dimension, row.Other))
+				g.dimension, g.other = row.Dimension, row.Other
+				g.values[formula.Ref{Event: eventName}] = float64(row.TotalEvents)
+				g.values[formula.Ref{Event: eventName, Unique: true}] = float64(row.TotalUniqueEventsForUser)
+			}
+		}
+	}
+
+	sorted := make([]*formulaGroup, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if kind == formulaTimeGroups {
+			return a.bucket.Before(b.bucket)
+		}
+		if a.other != b.other {
+			return b.other
+		}
+		return a.dimension < b.dimension
+	})
+
+	return kind, sorted
+}
+
+func groupLookup(g *formulaGroup) func(formula.Ref) float64 {
+	return func(ref formula.Ref) float64 { return g.values[ref] }
+}
+
+// evalFormula returns nil when the result is undefined, eg: a ratio over a bucket without events.
+func evalFormula(expr *formula.Expr, lookup func(formula.Ref) float64) *float64 {
+	value, ok := expr.Eval(lookup)
+	if !ok {
+		return nil
+	}
+	return &value
+}
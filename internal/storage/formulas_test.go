@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/model"
+)
+
+func TestEvaluateFormulas(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 3, d, 0, 0, 0, 0, time.UTC) }
+
+	events := []model.Metrics{
+		{
+			EventName:   "purchase",
+			TotalEvents: 10,
+			GroupBreakdown: []model.MetricsTimeGroupQueryResult{
+				{Bucket: day(2), TotalEvents: 10},
+			},
+		},
+		{
+			EventName:   "page_view",
+			TotalEvents: 100,
+			GroupBreakdown: []model.MetricsTimeGroupQueryResult{
+				{Bucket: day(1), TotalEvents: 60},
+				{Bucket: day(2), TotalEvents: 40},
+			},
+		},
+	}
+
+	results, err := evaluateFormulas([]api.FormulaDTO{{Name: "conversion_rate", Expression: "purchase / page_view"}}, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 formula, got %d", len(results))
+	}
+
+	result := results[0]
+	if result.Value == nil || *result.Value != 0.1 {
+		t.Errorf("unexpected total value %v", result.Value)
+	}
+
+	breakdown := result.GroupBreakdown.([]model.FormulaTimeGroupResult)
+	if len(breakdown) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(breakdown))
+	}
+	if !breakdown[0].Bucket.Equal(day(1)) || breakdown[0].Value == nil || *breakdown[0].Value != 0 {
+		t.Errorf("bucket without purchases should be 0, got %+v", breakdown[0])
+	}
+	if breakdown[1].Value == nil || *breakdown[1].Value != 0.25 {
+		t.Errorf("unexpected second bucket %+v", breakdown[1])
+	}
+
+	t.Run("undefined values are nil", func(t *testing.T) {
+		results, err := evaluateFormulas([]api.FormulaDTO{{Name: "inverse", Expression: "page_view / purchase"}}, events)
+		if err != nil {
+			t.Fatal(err)
+		}
+		breakdown := results[0].GroupBreakdown.([]model.FormulaTimeGroupResult)
+		if breakdown[0].Value != nil {
+			t.Errorf("expected nil for division by zero, got %v", *breakdown[0].Value)
+		}
+	})
+}
+
+func TestFormulaSharedTopDimensions(t *testing.T) {
+	// purchase alone would keep spring and autumn, page_view alone summer and spring
+	counts := map[string]map[string]int{
+		"purchase":  {"spring": 4, "summer": 1, "autumn": 3},
+		"page_view": {"spring": 10, "summer": 20, "autumn": 2, "winter": 8},
+	}
+	partialsOf := func(eventNames ...string) metricsPartials {
+		partials := make(metricsPartials)
+		for _, eventName := range eventNames {
+			for campaign, count := range counts[eventName] {
+				p := &metricsPartial{users: newUserSketch()}
+				for i := range count {
+					p.users.Insert(fmt.Appendf(nil, "%s_%d", eventName, i))
+					p.totalEvents++
+					p.estimatedEvents++
+				}
+				if err := partials.add(partialKey{dimension: campaign}, p.totalEvents, p.estimatedEvents, p.users); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return partials
+	}
+
+	combined := partialsOf("purchase", "page_view").dimensionBreakdown(OrderByCount, 2, nil)
+	top := breakdownDimensions(dimensionBreakdown("campaign_id", combined))
+	if !slices.Equal(top, []string{"summer", "spring"}) {
+		t.Fatalf("unexpected combined top %v", top)
+	}
+
+	var events []model.Metrics
+	for _, eventName := range []string{"purchase", "page_view"} {
+		rows := partialsOf(eventName).dimensionBreakdown(OrderByCount, 2, top)
+		events = append(events, model.Metrics{EventName: eventName, GroupBreakdown: dimensionBreakdown("campaign_id", rows)})
+	}
+
+	results, err := evaluateFormulas([]api.FormulaDTO{{Name: "conversion_rate", Expression: "purchase / page_view"}}, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	breakdown := results[0].GroupBreakdown.([]model.FormulaDimensionGroupResult)
+
+	want := map[string]float64{"spring": 0.4, "summer": 0.05, OtherDimension: 0.3}
+	if len(breakdown) != len(want) {
+		t.Fatalf("expected %d rows, got %+v", len(want), breakdown)
+	}
+	for _, row := range breakdown {
+		if row.Value == nil || math.Abs(*row.Value-want[row.Dimension]) > 1e-9 {
+			t.Errorf("%s: expected %v, got %v", row.Dimension, want[row.Dimension], row.Value)
+		}
+	}
+	if last := breakdown[len(breakdown)-1]; !last.Other {
+		t.Errorf("expected the other row last, got %+v", last)
+	}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaxTimeBuckets caps how many time buckets a single grouped query may produce.
+const MaxTimeBuckets = 10000
+
+// TimeGranularity describes how events are bucketed in time.
+// Calendar units are bucketed with DATE_TRUNC in the requested timezone, fixed-size buckets with DATE_BIN.
+type TimeGranularity struct {
+	// Unit is a DATE_TRUNC field (minute, hour, day, week or month), empty for fixed-size buckets.
+	Unit string
+	// Stride is the size of fixed-size buckets.
+	Stride time.Duration
+}
+
+// namedGranularities are the group_by values with a fixed meaning. Any other duration (eg: 10m, 2h) is a custom interval.
+var namedGranularities = map[string]TimeGranularity{
+	"minute": {Unit: "minute"},
+	"5m":     {Stride: 5 * time.Minute},
+	"15m":    {Stride: 15 * time.Minute},
+	"hour":   {Unit: "hour"},
+	"day":    {Unit: "day"},
+	"week":   {Unit: "week"},
+	"month":  {Unit: "month"},
+}
+
+// ParseTimeGranularity maps a group_by value onto a time granularity.
+// Returns false if the value is not a time grouping or a custom interval below one minute.
+func ParseTimeGranularity(groupBy string) (TimeGranularity, bool) {
+	if g, ok := namedGranularities[groupBy]; ok {
+		return g, true
+	}
+
+	stride, err := time.ParseDuration(groupBy)
+	if err != nil || stride < time.Minute || stride%time.Second != 0 {
+		return TimeGranularity{}, false
+	}
+
+	return TimeGranularity{Stride: stride}, true
+}
+
+// Approx returns the (average) length of a bucket, used to estimate how many buckets a range produces.
+func (g TimeGranularity) Approx() time.Duration {
+	switch g.Unit {
+	case "minute":
+		return time.Minute
+	case "hour":
+		return time.Hour
+	case "day":
+		return 24 * time.Hour
+	case "week":
+		return 7 * 24 * time.Hour
+	case "month":
+		return 30 * 24 * time.Hour
+	}
+	return g.Stride
+}
+
+// sqlBucket returns the SQL expression that maps column onto its bucket start, adding its parameters to args.
+func (g TimeGranularity) sqlBucket(column string, args *queryArgs, tz string) string {
+	if g.Unit != "" {
+		return fmt.Sprintf("DATE_TRUNC(%s, %s, %s)", args.add(g.Unit), column, args.add(tz))
+	}
+	return fmt.Sprintf("DATE_BIN(%s::interval, %s, %s)", args.add(g.Stride), column, args.add(binOrigin(tz)))
+}
+
+// sqlSeries returns a generate_series expression producing every bucket start in [from, to).
+func (g TimeGranularity) sqlSeries(from, to time.Time, args *queryArgs, tz string) string {
+	last := args.add(to.Add(-time.Microsecond))
+	first := g.sqlBucket(args.add(from)+"::timestamptz", args, tz)
+
+	if g.Unit != "" {
+		// Calendar steps are added in the requested timezone so buckets stay on local midnight across DST
+		return fmt.Sprintf("generate_series(%s, %s::timestamptz, %s::interval, %s)", first, last, args.add("1 "+g.Unit), args.add(tz))
+	}
+	return fmt.Sprintf("generate_series(%s, %s::timestamptz, %s::interval)", first, last, args.add(g.Stride))
+}
+
+// binOrigin anchors fixed-size buckets to local midnight in tz.
+func binOrigin(tz string) time.Time {
+	return time.Date(2001, 1, 1, 0, 0, 0, 0, loadLocation(tz))
+}
+
+// queryArgs collects positional query parameters while a query is being built.
+type queryArgs []any
+
+// add appends a parameter and returns its placeholder.
+func (a *queryArgs) add(value any) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("$%d", len(*a))
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeGranularity(t *testing.T) {
+	tests := []struct {
+		groupBy string
+		wantOK  bool
+		want    TimeGranularity
+	}{
+		{"minute", true, TimeGranularity{Unit: "minute"}},
+		{"5m", true, TimeGranularity{Stride: 5 * time.Minute}},
+		{"15m", true, TimeGranularity{Stride: 15 * time.Minute}},
+		{"hour", true, TimeGranularity{Unit: "hour"}},
+		{"day", true, TimeGranularity{Unit: "day"}},
+		{"week", true, TimeGranularity{Unit: "week"}},
+		{"month", true, TimeGranularity{Unit: "month"}},
+		{"10m", true, TimeGranularity{Stride: 10 * time.Minute}},
+		{"2h", true, TimeGranularity{Stride: 2 * time.Hour}},
+		{"30s", false, TimeGranularity{}},
+		{"1m500ms", false, TimeGranularity{}},
+		{"channel", false, TimeGranularity{}},
+		{"", false, TimeGranularity{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			got, ok := ParseTimeGranularity(tt.groupBy)
+			if ok != tt.wantOK {
+				t.Fatalf("expected ok=%v, got %v", tt.wantOK, ok)
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTimeGranularitySQL(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	t.Run("calendar unit truncates in timezone", func(t *testing.T) {
+		args := queryArgs{}
+		expr := TimeGranularity{Unit: "day"}.sqlBucket("ts", &args, "Europe/Istanbul")
+		if expr != "DATE_TRUNC($1, ts, $2)" {
+			t.Errorf("unexpected expression %q", expr)
+		}
+		if len(args) != 2 || args[0] != "day" || args[1] != "Europe/Istanbul" {
+			t.Errorf("unexpected args %v", args)
+		}
+	})
+
+	t.Run("fixed stride bins from local midnight", func(t *testing.T) {
+		args := queryArgs{}
+		expr := TimeGranularity{Stride: 15 * time.Minute}.sqlBucket("ts", &args, "UTC")
+		if expr != "DATE_BIN($1::interval, ts, $2)" {
+			t.Errorf("unexpected expression %q", expr)
+		}
+		if args[0] != 15*time.Minute {
+			t.Errorf("unexpected stride %v", args[0])
+		}
+	})
+
+	t.Run("calendar series steps in timezone", func(t *testing.T) {
+		args := queryArgs{"page_view"}
+		expr := TimeGranularity{Unit: "month"}.sqlSeries(from, to, &args, "America/New_York")
+		if !strings.HasPrefix(expr, "generate_series(DATE_TRUNC($4, $3::timestamptz, $5), $2::timestamptz, $6::interval, $7)") {
+			t.Errorf("unexpected expression %q", expr)
+		}
+		if args[5] != "1 month" {
+			t.Errorf("unexpected step %v", args[5])
+		}
+	})
+
+	t.Run("series stops before to", func(t *testing.T) {
+		args := queryArgs{}
+		TimeGranularity{Stride: time.Hour}.sqlSeries(from, to, &args, "UTC")
+		last := args[0].(time.Time)
+		if !last.Before(to) {
+			t.Errorf("expected last bucket bound before %v, got %v", to, last)
+		}
+	})
+}
@@ -0,0 +1,275 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/model"
+)
+
+// ErrInvalidCursor is returned for malformed cursors and cursors issued for a different query.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// MetricsCursor points right after the last breakdown row of the previous page.
+type MetricsCursor struct {
+	// Fingerprint identifies the query the cursor was issued for.
+	Fingerprint string `json:"f"`
+	// Key is the bucket or dimension value of the last row returned.
+	Key string `json:"k"`
+	// Offset is the position after that row, used if the row no longer exists.
+	Offset int `json:"o"`
+	// To is the end of the range, so following pages keep the range of a request that defaulted to now.
+	To int64 `json:"t"`
+}
+
+// MetricsFingerprint hashes every option of a metrics query so a cursor can't be replayed with different filters.
+func MetricsFingerprint(metricsDTO api.MetricsRequestDTO) string {
+	data, _ := json.Marshal(metricsDTO)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// DecodeMetricsCursor decodes an opaque cursor. An empty token is the first page.
+func DecodeMetricsCursor(token string) (MetricsCursor, error) {
+	if token == "" {
+		return MetricsCursor{}, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return MetricsCursor{}, ErrInvalidCursor
+	}
+
+	var cursor MetricsCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Fingerprint == "" || cursor.Offset < 0 {
+		return MetricsCursor{}, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+// Matches reports whether the cursor was issued for this query. The first page matches any query.
+func (c MetricsCursor) Matches(metricsDTO api.MetricsRequestDTO) bool {
+	return c.Fingerprint == "" || c.Fingerprint == MetricsFingerprint(metricsDTO)
+}
+
+func (c MetricsCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// PageMetrics keeps pageSize breakdown rows of a breakdown computed in full starting after cursor,
+// and sets NextCursor if more rows follow.
+// The breakdowns of each event, the formulas and the comparison are cut to the same buckets or values.
+// Metrics without a breakdown are returned as is.
+func PageMetrics(metrics model.Metrics, metricsDTO api.MetricsRequestDTO, cursor MetricsCursor, pageSize int) model.Metrics {
+	keys := breakdownKeys(metrics.GroupBreakdown)
+	if keys == nil {
+		return metrics
+	}
+
+	// Resume after the last row returned. Keys are unique within a breakdown so this holds even if rows
+	// were added or removed in between, the offset is only a fallback for a row that disappeared.
+	start := 0
+	if cursor.Fingerprint != "" {
+		start = min(cursor.Offset, len(keys))
+		for i, key := range keys {
+			if key == cursor.Key {
+				start = i + 1
+				break
+			}
+		}
+	}
+	end := min(start+pageSize, len(keys))
+
+	page := make(map[string]bool, end-start)
+	for _, key := range keys[start:end] {
+		page[key] = true
+	}
+
+	metrics = pageBreakdowns(metrics, page)
+	if end < len(keys) {
+		metrics.NextCursor = MetricsCursor{
+			Fingerprint: MetricsFingerprint(metricsDTO),
+			Key:         keys[end-1],
+			Offset:      end,
+			To:          metricsDTO.To,
+		}.encode()
+	}
+
+	return metrics
+}
+
+// pagedInQuery reports whether the breakdown of metricsDTO is paged by its query, with the cursor in the WHERE
+// clause and a LIMIT of one row more than the page. That takes a single event whose breakdown is ordered by its
+// bucket or value, breakdowns with formulas, a comparison or top groups are computed in full and paged in memory.
+func pagedInQuery(metricsDTO api.MetricsRequestDTO) bool {
+	return metricsDTO.PageSize > 0 &&
+		len(metricsDTO.EventNames) == 1 &&
+		len(metricsDTO.Formulas) == 0 &&
+		metricsDTO.Compare == "" &&
+		metricsDTO.Limit == 0 &&
+		metricsDTO.OrderBy == ""
+}
+
+// pageCursor decodes the cursor of a query paged by pagedInQuery. Returns false on the first page.
+func pageCursor(metricsDTO api.MetricsRequestDTO) (MetricsCursor, bool, error) {
+	if metricsDTO.PageSize <= 0 {
+		return MetricsCursor{}, false, nil
+	}
+	cursor, err := DecodeMetricsCursor(metricsDTO.Cursor)
+	if err != nil {
+		return MetricsCursor{}, false, err
+	}
+	return cursor, cursor.Fingerprint != "", nil
+}
+
+// pageAfterBucket returns the last bucket of the previous page of a time breakdown paged by pagedInQuery.
+// Returns false on the first page.
+func pageAfterBucket(metricsDTO api.MetricsRequestDTO) (time.Time, bool, error) {
+	cursor, paged, err := pageCursor(metricsDTO)
+	if err != nil || !paged {
+		return time.Time{}, false, err
+	}
+	after, err := time.Parse(time.RFC3339, cursor.Key)
+	if err != nil {
+		return time.Time{}, false, ErrInvalidCursor
+	}
+	return after, true, nil
+}
+
+// nextPage cuts a breakdown read by pagedInQuery, with one row more than the page when another page follows,
+// to the page and sets NextCursor after its last row.
+func nextPage(metrics model.Metrics, metricsDTO api.MetricsRequestDTO) (model.Metrics, error) {
+	cursor, _, err := pageCursor(metricsDTO)
+	if err != nil {
+		return model.Metrics{}, err
+	}
+
+	keys := breakdownKeys(metrics.GroupBreakdown)
+	if len(keys) <= metricsDTO.PageSize {
+		return metrics, nil
+	}
+
+	keys = keys[:metricsDTO.PageSize]
+	page := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		page[key] = true
+	}
+
+	metrics = pageBreakdowns(metrics, page)
+	metrics.NextCursor = MetricsCursor{
+		Fingerprint: MetricsFingerprint(metricsDTO),
+		Key:         keys[len(keys)-1],
+		Offset:      cursor.Offset + len(keys),
+		To:          metricsDTO.To,
+	}.encode()
+	return metrics, nil
+}
+
+// pageMetrics pages a breakdown computed in full in memory, after the cursor of metricsDTO.
+func pageMetrics(metrics model.Metrics, metricsDTO api.MetricsRequestDTO) (model.Metrics, error) {
+	if metricsDTO.PageSize <= 0 {
+		return metrics, nil
+	}
+	cursor, err := DecodeMetricsCursor(metricsDTO.Cursor)
+	if err != nil {
+		return model.Metrics{}, err
+	}
+	return PageMetrics(metrics, metricsDTO, cursor, metricsDTO.PageSize), nil
+}
+
+// pageBreakdowns drops every breakdown row whose key is not in page.
+func pageBreakdowns(metrics model.Metrics, page map[string]bool) model.Metrics {
+	metrics.GroupBreakdown = pageBreakdown(metrics.GroupBreakdown, page)
+
+	if metrics.Comparison != nil {
+		comparison := *metrics.Comparison
+		comparison.GroupBreakdown = pageBreakdown(comparison.GroupBreakdown, page)
+		metrics.Comparison = &comparison
+	}
+
+	if metrics.Events != nil {
+		events := make([]model.Metrics, 0, len(metrics.Events))
+		for _, e := range metrics.Events {
+			events = append(events, pageBreakdowns(e, page))
+		}
+		metrics.Events = events
+	}
+
+	if metrics.Formulas != nil {
+		formulas := make([]model.FormulaMetrics, 0, len(metrics.Formulas))
+		for _, f := range metrics.Formulas {
+			f.GroupBreakdown = pageBreakdown(f.GroupBreakdown, page)
+			formulas = append(formulas, f)
+		}
+		metrics.Formulas = formulas
+	}
+
+	return metrics
+}
+
+// breakdownKeys returns the key of every row of a breakdown in order, or nil if there is no breakdown.
+func breakdownKeys(breakdown any) []string {
+	switch rows := breakdown.(type) {
+	case []model.MetricsTimeGroupQueryResult:
+		return rowKeys(rows, func(r model.MetricsTimeGroupQueryResult) string { return bucketKey(r.Bucket) })
+	case []model.MetricsChannelGroupQueryResult:
+		return rowKeys(rows, func(r model.MetricsChannelGroupQueryResult) string { return dimensionKey(r.Channel, r.Other) })
+	case []model.MetricsDimensionGroupQueryResult:
+		return rowKeys(rows, func(r model.MetricsDimensionGroupQueryResult) string { return dimensionKey(r.Dimension, r.Other) })
+	}
+	return nil
+}
+
+func pageBreakdown(breakdown any, page map[string]bool) any {
+	switch rows := breakdown.(type) {
+	case []model.MetricsTimeGroupQueryResult:
+		return pageRows(rows, page, func(r model.MetricsTimeGroupQueryResult) string { return bucketKey(r.Bucket) })
+	case []model.MetricsChannelGroupQueryResult:
+		return pageRows(rows, page, func(r model.MetricsChannelGroupQueryResult) string { return dimensionKey(r.Channel, r.Other) })
+	case []model.MetricsDimensionGroupQueryResult:
+		return pageRows(rows, page, func(r model.MetricsDimensionGroupQueryResult) string { return dimensionKey(r.Dimension, r.Other) })
+	case []model.MetricsTimeComparisonResult:
+		return pageRows(rows, page, func(r model.MetricsTimeComparisonResult) string { return bucketKey(r.Bucket) })
+	case []model.MetricsChannelComparisonResult:
+		return pageRows(rows, page, func(r model.MetricsChannelComparisonResult) string { return dimensionKey(r.Channel, r.Other) })
+	case []model.MetricsDimensionComparisonResult:
+		return pageRows(rows, page, func(r model.MetricsDimensionComparisonResult) string { return dimensionKey(r.Dimension, r.Other) })
+	case []model.FormulaTimeGroupResult:
+		return pageRows(rows, page, func(r model.FormulaTimeGroupResult) string { return bucketKey(r.Bucket) })
+	case []model.FormulaChannelGroupResult:
+		return pageRows(rows, page, func(r model.FormulaChannelGroupResult) string { return dimensionKey(r.Channel, r.Other) })
+	case []model.FormulaDimensionGroupResult:
+		return pageRows(rows, page, func(r model.FormulaDimensionGroupResult) string { return dimensionKey(r.Dimension, r.Other) })
+	}
+	return breakdown
+}
+
+func rowKeys[T any](rows []T, key func(T) string) []string {
+	keys := make([]string, 0, len(rows))
+	for _, r := range rows {
+		keys = append(keys, key(r))
+	}
+	return keys
+}
+
+func pageRows[T any](rows []T, page map[string]bool, key func(T) string) []T {
+	results := make([]T, 0, len(page))
+	for _, r := range rows {
+		if page[key(r)] {
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// bucketKey identifies a time bucket independently of the timezone it is labelled in.
+func bucketKey(bucket time.Time) string {
+	return bucket.UTC().Format(time.RFC3339)
+}
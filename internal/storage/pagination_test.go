@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/model"
+)
+
+func TestPageMetrics(t *testing.T) {
+	metricsDTO := api.MetricsRequestDTO{EventNames: []string{"page_view"}, From: 1769904000, To: 1769990400, GroupBy: "hour"}
+	start := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	var breakdown []model.MetricsTimeGroupQueryResult
+	var formulaBreakdown []model.FormulaTimeGroupResult
+	for h := 0; h < 5; h++ {
+		bucket := start.Add(time.Duration(h) * time.Hour)
+		breakdown = append(breakdown, model.MetricsTimeGroupQueryResult{Bucket: bucket, TotalEvents: int64(h)})
+		formulaBreakdown = append(formulaBreakdown, model.FormulaTimeGroupResult{Bucket: bucket})
+	}
+	metrics := model.Metrics{
+		GroupBreakdown: breakdown,
+		Formulas:       []model.FormulaMetrics{{Name: "rate", GroupBreakdown: formulaBreakdown}},
+	}
+
+	t.Run("pages through every row once", func(t *testing.T) {
+		var seen []int64
+		cursor := MetricsCursor{}
+		for pages := 0; pages < 10; pages++ {
+			page := PageMetrics(metrics, metricsDTO, cursor, 2)
+			for _, r := range page.GroupBreakdown.([]model.MetricsTimeGroupQueryResult) {
+				seen = append(seen, r.TotalEvents)
+			}
+			if formulas := page.Formulas[0].GroupBreakdown.([]model.FormulaTimeGroupResult); len(formulas) != len(page.GroupBreakdown.([]model.MetricsTimeGroupQueryResult)) {
+				t.Errorf("formula breakdown not cut to the page: %v", formulas)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+
+			var err error
+			cursor, err = DecodeMetricsCursor(page.NextCursor)
+			if err != nil || !cursor.Matches(metricsDTO) {
+				t.Fatalf("unexpected cursor %q: %v", page.NextCursor, err)
+			}
+		}
+
+		if len(seen) != 5 {
+			t.Fatalf("expected 5 rows, got %v", seen)
+		}
+		for i, v := range seen {
+			if v != int64(i) {
+				t.Errorf("unexpected order %v", seen)
+			}
+		}
+	})
+
+	t.Run("resumes after the last key even if rows were added before it", func(t *testing.T) {
+		first := PageMetrics(metrics, metricsDTO, MetricsCursor{}, 2)
+		cursor, _ := DecodeMetricsCursor(first.NextCursor)
+
+		grown := metrics
+		grown.GroupBreakdown = append([]model.MetricsTimeGroupQueryResult{{Bucket: start.Add(-time.Hour), TotalEvents: -1}}, breakdown...)
+
+		rows := PageMetrics(grown, metricsDTO, cursor, 2).GroupBreakdown.([]model.MetricsTimeGroupQueryResult)
+		if len(rows) != 2 || rows[0].TotalEvents != 2 {
+			t.Errorf("unexpected page %v", rows)
+		}
+	})
+
+	t.Run("cursor is tied to the query", func(t *testing.T) {
+		page := PageMetrics(metrics, metricsDTO, MetricsCursor{}, 2)
+		cursor, err := DecodeMetricsCursor(page.NextCursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		other := metricsDTO
+		other.EventNames = []string{"purchase"}
+		if cursor.Matches(other) {
+			t.Error("expected cursor not to match a different query")
+		}
+		if cursor.To != metricsDTO.To {
+			t.Errorf("expected cursor to pin to=%d, got %d", metricsDTO.To, cursor.To)
+		}
+	})
+
+	t.Run("malformed cursor is rejected", func(t *testing.T) {
+		for _, token := range []string{"not base64!", "e30"} {
+			if _, err := DecodeMetricsCursor(token); err != ErrInvalidCursor {
+				t.Errorf("expected ErrInvalidCursor for %q, got %v", token, err)
+			}
+		}
+	})
+
+	t.Run("metrics without breakdown are unchanged", func(t *testing.T) {
+		page := PageMetrics(model.Metrics{TotalEvents: 3}, metricsDTO, MetricsCursor{}, 2)
+		if page.TotalEvents != 3 || page.NextCursor != "" {
+			t.Errorf("unexpected metrics %+v", page)
+		}
+	})
+}
+
+func TestNextPage(t *testing.T) {
+	metricsDTO := api.MetricsRequestDTO{EventNames: []string{"page_view"}, From: 1769904000, To: 1769990400, GroupBy: "channel", PageSize: 2}
+
+	// The query returns one row more than the page when another page follows
+	rows := []model.MetricsChannelGroupQueryResult{{Channel: "app"}, {Channel: "email"}, {Channel: "web"}}
+	page, err := nextPage(model.Metrics{GroupBreakdown: rows}, metricsDTO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := page.GroupBreakdown.([]model.MetricsChannelGroupQueryResult); len(got) != 2 || got[1].Channel != "email" {
+		t.Fatalf("unexpected page %v", got)
+	}
+
+	cursor, err := DecodeMetricsCursor(page.NextCursor)
+	if err != nil || cursor.Key != "email" || cursor.Offset != 2 {
+		t.Fatalf("unexpected cursor %+v: %v", cursor, err)
+	}
+	// The page size and cursor aren't part of the query
+	metricsDTO.Cursor = page.NextCursor
+	if !cursor.Matches(metricsDTO) {
+		t.Error("expected cursor to match the next page query")
+	}
+
+	last, err := nextPage(model.Metrics{GroupBreakdown: rows[2:]}, metricsDTO)
+	if err != nil || last.NextCursor != "" {
+		t.Errorf("expected last page without cursor, got %q: %v", last.NextCursor, err)
+	}
+}
+
+func TestPagedInQuery(t *testing.T) {
+	base := api.MetricsRequestDTO{EventNames: []string{"page_view"}, GroupBy: "day", PageSize: 10}
+
+	tests := []struct {
+		name  string
+		apply func(*api.MetricsRequestDTO)
+		want  bool
+	}{
+		{"single event", func(*api.MetricsRequestDTO) {}, true},
+		{"no page size", func(d *api.MetricsRequestDTO) { d.PageSize = 0 }, false},
+		{"several events", func(d *api.MetricsRequestDTO) { d.EventNames = append(d.EventNames, "purchase") }, false},
+		{"formulas", func(d *api.MetricsRequestDTO) { d.Formulas = []api.FormulaDTO{{Name: "x", Expression: "page_view"}} }, false},
+		{"comparison", func(d *api.MetricsRequestDTO) { d.Compare = "previous_period" }, false},
+		{"top groups", func(d *api.MetricsRequestDTO) { d.Limit = 5 }, false},
+		{"ordered by count", func(d *api.MetricsRequestDTO) { d.OrderBy = OrderByCount }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metricsDTO := base
+			tt.apply(&metricsDTO)
+			if got := pagedInQuery(metricsDTO); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPageAfterBucket(t *testing.T) {
+	metricsDTO := api.MetricsRequestDTO{EventNames: []string{"page_view"}, GroupBy: "hour", PageSize: 2}
+	if _, paged, err := pageAfterBucket(metricsDTO); paged || err != nil {
+		t.Fatalf("expected first page, got %v, %v", paged, err)
+	}
+
+	bucket := time.Date(2026, 2, 1, 3, 0, 0, 0, time.UTC)
+	metricsDTO.Cursor = MetricsCursor{Fingerprint: MetricsFingerprint(metricsDTO), Key: bucketKey(bucket), Offset: 2}.encode()
+	after, paged, err := pageAfterBucket(metricsDTO)
+	if err != nil || !paged || !after.Equal(bucket) {
+		t.Errorf("expected %s, got %s, %v, %v", bucket, after, paged, err)
+	}
+
+	metricsDTO.Cursor = MetricsCursor{Fingerprint: "f", Key: "web"}.encode()
+	if _, _, err := pageAfterBucket(metricsDTO); err != ErrInvalidCursor {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	api "fast-ingest/internal/api/dto"
@@ -20,17 +22,26 @@ import (
 
 type PostgresStore struct {
 	pool *pgxpool.Pool
+
+	// useRollups allows GetMetrics to serve eligible queries from pre-aggregated rollup tables.
+	useRollups bool
+
+	// tierLimits decides which resolution serves each part of a metrics query.
+	tierLimits TierLimits
+
+	// erasure configures how EraseUser anonymizes users.
+	erasure ErasureConfig
 }
 
 func (p *PostgresStore) Ping(ctx context.Context) error { return p.pool.Ping(ctx) }
 
-func (p *PostgresStore) InsertEvents(ctx context.Context, events []model.Event) error {
+func (p *PostgresStore) InsertEvents(ctx context.Context, events []model.Event) ([]model.Event, error) {
 	log.Printf("Inserting batch of %d events", len(events))
 
 	// Using a transaction with pgx.Batch to bulk insert events
 	tx, err := p.pool.Begin(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer tx.Rollback(ctx)
 
@@ -40,45 +51,53 @@ func (p *PostgresStore) InsertEvents(ctx context.Context, events []model.Event)
 		tagsJSON, _ := json.Marshal(e.Tags)
 		metaJSON, _ := json.Marshal(e.Metadata)
 
+		// RETURNING only yields a row for events that were actually inserted (not deduplicated),
+		// those are the ones folded into the rollups below.
 		batch.Queue(`
-			INSERT INTO events (dedupe_key, event_name, channel, campaign_id, user_id, ts, tags, metadata)
-			VALUES ($1,$2,$3,$4,$5,$6,$7::jsonb,$8::jsonb)
-			ON CONFLICT (dedupe_key) DO NOTHING;
-		`, DedupeKey(e), e.EventName, e.Channel, NullIfEmpty(e.CampaignID), e.UserID, t, tagsJSON, metaJSON)
+			INSERT INTO events (dedupe_key, event_name, channel, campaign_id, user_id, ts, tags, metadata, sample_rate, message_id)
+			VALUES ($1,$2,$3,$4,$5,$6,$7::jsonb,$8::jsonb,$9,$10)
+			ON CONFLICT (dedupe_key) DO NOTHING
+			RETURNING ts;
+		`, DedupeKey(e), e.EventName, e.Channel, NullIfEmpty(e.CampaignID), e.UserID, t, tagsJSON, metaJSON, sampleRate(e), NullIfEmpty(e.MessageID))
 	}
 
 	start := time.Now()
 
 	br := tx.SendBatch(ctx, batch)
+	inserted := make([]model.Event, 0, len(events))
+	for _, e := range events {
+		var ts time.Time
+		err := br.QueryRow().Scan(&ts)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			br.Close()
+			return nil, err
+		}
+		inserted = append(inserted, e)
+	}
 	if err := br.Close(); err != nil {
-		return err
+		return nil, err
+	}
+
+	if err := updateHourlyRollups(ctx, tx, inserted); err != nil {
+		return nil, err
 	}
 
 	err = tx.Commit(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	log.Printf("Batch insert for %d items took %d ms", len(events), time.Since(start).Milliseconds())
 
-	return nil
+	return inserted, nil
 }
 
+// InsertEvent persists a single event through the batch path so rollups stay in sync.
 func (p *PostgresStore) InsertEvent(ctx context.Context, e model.Event) error {
-
-	// Postgres expects timestamps in UTC, so we convert to UTC before inserting.
-	t := NormalizeTimestamp(e.Timestamp)
-
-	// Marshal tags and metadata to JSON for storage in jsonb columns.
-	tagsJSON, _ := json.Marshal(e.Tags)
-	metaJSON, _ := json.Marshal(e.Metadata)
-
-	_, err := p.pool.Exec(ctx, `
-		INSERT INTO events (dedupe_key, event_name, channel, campaign_id, user_id, ts, tags, metadata)
-VALUES ($1,$2,$3,$4,$5,$6,$7::jsonb,$8::jsonb)
-ON CONFLICT (dedupe_key) DO NOTHING;
-	`, DedupeKey(e), e.EventName, e.Channel, NullIfEmpty(e.CampaignID), e.UserID, t, tagsJSON, metaJSON)
-
+	_, err := p.InsertEvents(ctx, []model.Event{e})
 	return err
 }
 
@@ -115,18 +134,80 @@ func NewPostgres(ctx context.Context) (*PostgresStore, error) {
 		return nil, err
 	}
 
-	return &PostgresStore{pool: pool}, nil
+	// Rollups are used by default, METRICS_USE_ROLLUPS=false forces every query onto the raw events table
+	useRollups := os.Getenv("METRICS_USE_ROLLUPS") != "false"
+
+	tierLimits, err := TierLimitsFromEnv()
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	return &PostgresStore{pool: pool, useRollups: useRollups, tierLimits: tierLimits, erasure: ErasureConfigFromEnv()}, nil
 }
 
 func (p *PostgresStore) GetMetrics(ctx context.Context, metricsDTO api.MetricsRequestDTO) (model.Metrics, error) {
+	// Breakdowns that can't be paged by their query are computed in full and paged in memory
+	if metricsDTO.PageSize > 0 && !pagedInQuery(metricsDTO) {
+		unpaged := metricsDTO
+		unpaged.PageSize, unpaged.Cursor = 0, ""
+
+		metrics, err := p.GetMetrics(ctx, unpaged)
+		if err != nil {
+			return model.Metrics{}, err
+		}
+		return pageMetrics(metrics, metricsDTO)
+	}
+
+	if len(metricsDTO.EventNames) > 1 || len(metricsDTO.Formulas) > 0 {
+		return p.getMultiEventMetrics(ctx, metricsDTO)
+	}
+
+	return p.getComparableMetrics(ctx, metricsDTO)
+}
+
+// getComparableMetrics computes the metrics of a single query, with its comparison if one was requested.
+func (p *PostgresStore) getComparableMetrics(ctx context.Context, metricsDTO api.MetricsRequestDTO) (model.Metrics, error) {
+	if metricsDTO.Compare != "" {
+		return p.getComparedMetrics(ctx, metricsDTO)
+	}
+
+	return p.getMetrics(ctx, metricsDTO)
+}
+
+func (p *PostgresStore) getMetrics(ctx context.Context, metricsDTO api.MetricsRequestDTO) (model.Metrics, error) {
 	from := NormalizeTimestamp(metricsDTO.From)
 	to := NormalizeTimestamp(metricsDTO.To)
 
 	var metrics model.Metrics = model.Metrics{
-		EventName: metricsDTO.EventName,
+		EventName: strings.Join(metricsDTO.EventNames, ","),
 		From:      from.Format(time.RFC3339),
 		To:        to.Format(time.RFC3339),
 		GroupBy:   metricsDTO.GroupBy,
+		Timezone:  metricsDTO.Timezone,
+		Source:    sourceRaw,
+	}
+
+	// Rollups are bucketed in UTC, other timezones are always computed from raw events
+	if isUTC(metricsDTO.Timezone) {
+		// Ranges reaching past the raw retention window are stitched from the daily rollups and recent data
+		// Rollup breakdowns are merged in memory, so they are paged there too
+		if boundary, ok := p.dailyRollupBoundary(ctx, metricsDTO.GroupBy, from); ok {
+			metrics, err := p.getTieredMetrics(ctx, metricsDTO, metrics, boundary)
+			if err != nil {
+				return model.Metrics{}, err
+			}
+			return pageMetrics(metrics, metricsDTO)
+		}
+
+		// Serve from the hourly rollups when the range and grouping allow it
+		if rollupFrom, rollupTo, ok := p.hourlyRollupWindow(ctx, metricsDTO.GroupBy, from, to); ok {
+			metrics, err := p.getRollupMetrics(ctx, metricsDTO, metrics, rollupFrom, rollupTo)
+			if err != nil {
+				return model.Metrics{}, err
+			}
+			return pageMetrics(metrics, metricsDTO)
+		}
 	}
 
 	totalsQueryResult, err := p.getTotalsQuery(metricsDTO)
@@ -135,21 +216,26 @@ func (p *PostgresStore) GetMetrics(ctx context.Context, metricsDTO api.MetricsRe
 	}
 
 	metrics.TotalEvents = totalsQueryResult.TotalEvents
+	metrics.EstimatedTotalEvents = totalsQueryResult.EstimatedTotalEvents
 	metrics.TotalUniqueEventsForUser = totalsQueryResult.TotalUniqueEventsForUser
+	metrics.Segments = []model.MetricsSegment{newMetricsSegment(from, to, sourceRaw)}
 
 	// If group_by is specified, we need to run a separate query to get the breakdown by group.
-	if metricsDTO.GroupBy == "day" || metricsDTO.GroupBy == "hour" {
+	if _, ok := ParseTimeGranularity(metricsDTO.GroupBy); ok {
 		groupQueryResults, err := p.getTimeGroupQuery(metricsDTO)
 		if err == nil {
 			metrics.GroupBreakdown = groupQueryResults
 		}
-	} else if metricsDTO.GroupBy == "channel" {
-		channelGroupQueryResults, err := p.getChannelGroupQuery(metricsDTO)
+	} else if IsDimensionGroupBy(metricsDTO.GroupBy) {
+		dimensionGroupQueryResults, err := p.getDimensionGroupQuery(ctx, metricsDTO)
 		if err == nil {
-			metrics.GroupBreakdown = channelGroupQueryResults
+			metrics.GroupBreakdown = dimensionBreakdown(metricsDTO.GroupBy, dimensionGroupQueryResults)
 		}
 	}
 
+	if metricsDTO.PageSize > 0 {
+		return nextPage(metrics, metricsDTO)
+	}
 	return metrics, nil
 }
 
@@ -164,12 +250,13 @@ func (p *PostgresStore) getTotalsQuery(metricsDTO api.MetricsRequestDTO) (model.
 	var totalsQueryResult model.MetricsTotalsQueryResult
 	totalsQuery := `SELECT
 COUNT(*) AS total_events,
+` + estimatedEventsSQL + ` AS estimated_total_events,
 COUNT(DISTINCT user_id) AS total_unique_events_for_user
 FROM events
-WHERE event_name = $1
+WHERE event_name = ANY($1)
 AND ts >= $2 AND ts < $3;`
-	row := p.pool.QueryRow(context.Background(), totalsQuery, metricsDTO.EventName, from, to)
-	if err := row.Scan(&totalsQueryResult.TotalEvents, &totalsQueryResult.TotalUniqueEventsForUser); err != nil {
+	row := p.pool.QueryRow(context.Background(), totalsQuery, metricsDTO.EventNames, from, to)
+	if err := row.Scan(&totalsQueryResult.TotalEvents, &totalsQueryResult.EstimatedTotalEvents, &totalsQueryResult.TotalUniqueEventsForUser); err != nil {
 		return model.MetricsTotalsQueryResult{}, err
 	}
 
@@ -180,27 +267,77 @@ func (p *PostgresStore) getTimeGroupQuery(metricsDTO api.MetricsRequestDTO) ([]m
 	from := NormalizeTimestamp(metricsDTO.From)
 	to := NormalizeTimestamp(metricsDTO.To)
 
-	groupQuery := `SELECT
-DATE_TRUNC($1, ts) AS bucket,
+	granularity, _ := ParseTimeGranularity(metricsDTO.GroupBy)
+
+	// A page resumes after the last bucket of the previous one, see pagedInQuery
+	after, paged, err := pageAfterBucket(metricsDTO)
+	if err != nil {
+		return nil, err
+	}
+	if paged && after.After(from) {
+		from = after
+	}
+
+	args := queryArgs{}
+	groupQuery := `WITH grouped AS (
+SELECT
+` + granularity.sqlBucket("ts", &args, metricsDTO.Timezone) + ` AS bucket,
 COUNT(*) AS total_count,
+` + estimatedEventsSQL + ` AS estimated_count,
 COUNT(DISTINCT user_id) AS total_unique_event_for_user_count
 FROM events
-WHERE event_name = $2
-AND ts >= $3 AND ts < $4
+WHERE event_name = ANY(` + args.add(metricsDTO.EventNames) + `)
+AND ts >= ` + args.add(from) + ` AND ts < ` + args.add(to) + `
 GROUP BY bucket
-ORDER BY bucket;`
-	rows, err := p.pool.Query(context.Background(), groupQuery, metricsDTO.GroupBy, metricsDTO.EventName, from, to)
+)
+`
+	if metricsDTO.GapFill {
+		// Left join onto every bucket in [from, to) so empty buckets come back as zeros
+		groupQuery += `SELECT
+series.bucket,
+COALESCE(grouped.total_count, 0),
+COALESCE(grouped.estimated_count, 0),
+COALESCE(grouped.total_unique_event_for_user_count, 0)
+FROM ` + granularity.sqlSeries(from, to, &args, metricsDTO.Timezone) + ` AS series(bucket)
+LEFT JOIN grouped ON grouped.bucket = series.bucket
+`
+		if paged {
+			groupQuery += `WHERE series.bucket > ` + args.add(after) + `
+`
+		}
+		groupQuery += `ORDER BY series.bucket`
+	} else {
+		groupQuery += `SELECT bucket, total_count, estimated_count, total_unique_event_for_user_count
+FROM grouped
+`
+		if paged {
+			groupQuery += `WHERE bucket > ` + args.add(after) + `
+`
+		}
+		groupQuery += `ORDER BY bucket`
+	}
+	if metricsDTO.PageSize > 0 {
+		// One extra row tells whether another page follows
+		groupQuery += `
+LIMIT ` + args.add(metricsDTO.PageSize+1)
+	}
+
+	rows, err := p.pool.Query(context.Background(), groupQuery, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	loc := loadLocation(metricsDTO.Timezone)
+
 	var results []model.MetricsTimeGroupQueryResult
 	for rows.Next() {
 		var r model.MetricsTimeGroupQueryResult
-		if err := rows.Scan(&r.Bucket, &r.TotalEvents, &r.TotalUniqueEventsForUser); err != nil {
+		if err := rows.Scan(&r.Bucket, &r.TotalEvents, &r.EstimatedTotalEvents, &r.TotalUniqueEventsForUser); err != nil {
 			return nil, err
 		}
+		// Labels carry the offset of the requested timezone, eg: 2026-03-29T00:00:00+03:00
+		r.Bucket = r.Bucket.In(loc)
 		results = append(results, r)
 	}
 
@@ -211,42 +348,19 @@ ORDER BY bucket;`
 	return results, nil
 }
 
-func (p *PostgresStore) getChannelGroupQuery(metricsDTO api.MetricsRequestDTO) ([]model.MetricsChannelGroupQueryResult, error) {
-	from := NormalizeTimestamp(metricsDTO.From)
-	to := NormalizeTimestamp(metricsDTO.To)
-
-	groupQuery := `SELECT
-channel,
-COUNT(*) AS total_count,
-COUNT(DISTINCT user_id) AS total_unique_event_for_user_count
-FROM events
-WHERE event_name = $1
-AND ts >= $2 AND ts < $3
-GROUP BY channel
-ORDER BY channel;`
-	rows, err := p.pool.Query(context.Background(), groupQuery, metricsDTO.EventName, from, to)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// Helper functions
 
-	var results []model.MetricsChannelGroupQueryResult
-	for rows.Next() {
-		var r model.MetricsChannelGroupQueryResult
-		if err := rows.Scan(&r.Channel, &r.TotalEvents, &r.TotalUniqueEventsForUser); err != nil {
-			return nil, err
-		}
-		results = append(results, r)
-	}
+// estimatedEventsSQL scales sampled events back up, each event standing for 1/sample_rate events.
+const estimatedEventsSQL = `COALESCE(ROUND(SUM(1.0 / sample_rate)), 0)::bigint`
 
-	if err := rows.Err(); err != nil {
-		return nil, err
+// sampleRate is the sample rate an event is stored with, unsampled events have a rate of 1.
+func sampleRate(e model.Event) float64 {
+	if e.SampleRate <= 0 {
+		return 1
 	}
-
-	return results, nil
+	return e.SampleRate
 }
 
-// Helper functions
 func NullIfEmpty(s string) any {
 	if s == "" {
 		return nil
@@ -254,7 +368,13 @@ func NullIfEmpty(s string) any {
 	return s
 }
 
+// DedupeKey identifies an event across retries, by its message id when its source gave it one.
 func DedupeKey(e model.Event) string {
+	if e.MessageID != "" {
+		sum := sha256.Sum256([]byte("message_id|" + e.MessageID))
+		return hex.EncodeToString(sum[:])
+	}
+
 	ts := e.Timestamp
 	if ts > 1e12 {
 		ts /= 1000
@@ -264,6 +384,19 @@ func DedupeKey(e model.Event) string {
 	return hex.EncodeToString(sum[:])
 }
 
+// loadLocation resolves an IANA timezone name validated by the API layer, falling back to UTC.
+func loadLocation(tz string) *time.Location {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func isUTC(tz string) bool {
+	return tz == "" || tz == "UTC"
+}
+
 func NormalizeTimestamp(ts int64) time.Time {
 	if ts > 1e12 {
 		ts /= 1000
@@ -92,6 +92,23 @@ func TestDedupeKey(t *testing.T) {
 		}
 	})
 
+	t.Run("message id takes over the fields", func(t *testing.T) {
+		retry := base
+		retry.MessageID = "msg_1"
+		retry.Timestamp = base.Timestamp + 5
+		other := retry
+		other.MessageID = "msg_2"
+
+		first := base
+		first.MessageID = "msg_1"
+		if DedupeKey(first) != DedupeKey(retry) {
+			t.Error("expected same key for the same message id")
+		}
+		if DedupeKey(retry) == DedupeKey(other) || DedupeKey(first) == DedupeKey(base) {
+			t.Error("expected different keys for different message ids")
+		}
+	})
+
 	t.Run("millisecond timestamp normalized to same key as second timestamp", func(t *testing.T) {
 		msEvent := base
 		msEvent.Timestamp = base.Timestamp * 1000
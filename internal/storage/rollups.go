@@ -0,0 +1,541 @@
+package storage
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/model"
+
+	"github.com/axiomhq/hyperloglog"
+	"github.com/jackc/pgx/v5"
+)
+
+// Values reported in model.Metrics.Source and model.MetricsSegment.Resolution.
+const (
+	sourceRaw          = "raw"
+	sourceHourlyRollup = "hourly_rollup"
+	sourceDailyRollup  = "daily_rollup"
+)
+
+// resolutionRank orders the sources from finest to coarsest.
+var resolutionRank = map[string]int{
+	sourceRaw:          0,
+	sourceHourlyRollup: 1,
+	sourceDailyRollup:  2,
+}
+
+// Rollup tables, the only table names ever concatenated into queries.
+const (
+	hourlyRollupTable = "events_hourly_rollup"
+	dailyRollupTable  = "events_daily_rollup"
+)
+
+// rollupKey identifies a single row of a rollup table.
+type rollupKey struct {
+	EventName  string
+	Bucket     time.Time
+	Channel    string
+	CampaignID string
+}
+
+// metricsPartial holds a mergeable aggregate: an event count, its estimate before sampling and a sketch of the distinct users behind it.
+type metricsPartial struct {
+	totalEvents     int64
+	estimatedEvents float64
+	users           *hyperloglog.Sketch
+
+	// exactUsers is the distinct user count of a group read from a single SQL aggregate, with an empty users sketch.
+	// It is only set for groups no other source contributes to, eg: the time buckets of a raw segment.
+	exactUsers int64
+}
+
+// partialKey is the group a metricsPartial belongs to. Only the field matching the group_by is set.
+type partialKey struct {
+	bucket    int64
+	dimension string
+}
+
+// metricsPartials collects partial aggregates by group so results from several sources can be stitched together.
+type metricsPartials map[partialKey]*metricsPartial
+
+// updateHourlyRollups folds freshly inserted events into events_hourly_rollup within the insert transaction.
+// Rows are created up front and locked in a fixed order so concurrent writers merge sketches instead of overwriting them.
+func updateHourlyRollups(ctx context.Context, tx pgx.Tx, inserted []model.Event) error {
+	if len(inserted) == 0 {
+		return nil
+	}
+
+	aggs := make(map[rollupKey]*metricsPartial)
+	for _, e := range inserted {
+		key := rollupKey{
+			EventName:  e.EventName,
+			Bucket:     NormalizeTimestamp(e.Timestamp).Truncate(time.Hour),
+			Channel:    e.Channel,
+			CampaignID: e.CampaignID,
+		}
+		agg, ok := aggs[key]
+		if !ok {
+			agg = &metricsPartial{users: newUserSketch()}
+			aggs[key] = agg
+		}
+		agg.totalEvents++
+		agg.estimatedEvents += 1 / sampleRate(e)
+		agg.users.Insert([]byte(e.UserID))
+	}
+
+	keys := make([]rollupKey, 0, len(aggs))
+	for key := range aggs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.EventName != b.EventName {
+			return a.EventName < b.EventName
+		}
+		if !a.Bucket.Equal(b.Bucket) {
+			return a.Bucket.Before(b.Bucket)
+		}
+		if a.Channel != b.Channel {
+			return a.Channel < b.Channel
+		}
+		return a.CampaignID < b.CampaignID
+	})
+
+	// Make sure every row exists, then lock it and read the current sketch
+	lockBatch := &pgx.Batch{}
+	for _, key := range keys {
+		lockBatch.Queue(`
+			INSERT INTO events_hourly_rollup (bucket, event_name, channel, campaign_id)
+			VALUES ($1,$2,$3,$4)
+			ON CONFLICT DO NOTHING;
+		`, key.Bucket, key.EventName, key.Channel, key.CampaignID)
+		lockBatch.Queue(`
+			SELECT users_hll FROM events_hourly_rollup
+			WHERE event_name = $1 AND bucket = $2 AND channel = $3 AND campaign_id = $4
+			FOR UPDATE;
+		`, key.EventName, key.Bucket, key.Channel, key.CampaignID)
+	}
+
+	br := tx.SendBatch(ctx, lockBatch)
+	for _, key := range keys {
+		if _, err := br.Exec(); err != nil {
+			br.Close()
+			return err
+		}
+
+		var existing []byte
+		if err := br.QueryRow().Scan(&existing); err != nil {
+			br.Close()
+			return err
+		}
+
+		current, err := decodeUserSketch(existing)
+		if err != nil {
+			br.Close()
+			return err
+		}
+		if err := aggs[key].users.Merge(current); err != nil {
+			br.Close()
+			return err
+		}
+	}
+	if err := br.Close(); err != nil {
+		return err
+	}
+
+	updateBatch := &pgx.Batch{}
+	for _, key := range keys {
+		agg := aggs[key]
+		sketch, err := agg.users.MarshalBinary()
+		if err != nil {
+			return err
+		}
+
+		updateBatch.Queue(`
+			UPDATE events_hourly_rollup
+			SET total_events = total_events + $5, estimated_events = estimated_events + $6, users_hll = $7, updated_at = now()
+			WHERE event_name = $1 AND bucket = $2 AND channel = $3 AND campaign_id = $4;
+		`, key.EventName, key.Bucket, key.Channel, key.CampaignID, agg.totalEvents, agg.estimatedEvents, sketch)
+	}
+
+	return tx.SendBatch(ctx, updateBatch).Close()
+}
+
+// hourlyRollupWindow decides whether [from, to) can be served from the hourly rollups.
+// It returns the whole hours inside the range that the rollups cover; the partial hours at
+// either edge are read from the raw events table.
+func (p *PostgresStore) hourlyRollupWindow(ctx context.Context, groupBy string, from, to time.Time) (time.Time, time.Time, bool) {
+	if !p.useRollups || !IsRollupGroupBy(groupBy) {
+		return time.Time{}, time.Time{}, false
+	}
+
+	rollupFrom := from.Truncate(time.Hour)
+	if rollupFrom.Before(from) {
+		rollupFrom = rollupFrom.Add(time.Hour)
+	}
+	rollupTo := to.Truncate(time.Hour)
+
+	// Not worth it if the range doesn't contain a single full hour
+	if rollupTo.Sub(rollupFrom) < time.Hour {
+		return time.Time{}, time.Time{}, false
+	}
+
+	var coveredFrom time.Time
+	err := p.pool.QueryRow(ctx, `SELECT covered_from FROM rollup_state WHERE name = 'hourly';`).Scan(&coveredFrom)
+	if err != nil || rollupFrom.Before(coveredFrom) {
+		return time.Time{}, time.Time{}, false
+	}
+
+	return rollupFrom, rollupTo, true
+}
+
+// getRollupMetrics serves [rollupFrom, rollupTo) from the hourly rollups and stitches the raw edges on both sides.
+func (p *PostgresStore) getRollupMetrics(ctx context.Context, metricsDTO api.MetricsRequestDTO, metrics model.Metrics, rollupFrom, rollupTo time.Time) (model.Metrics, error) {
+	from := NormalizeTimestamp(metricsDTO.From)
+	to := NormalizeTimestamp(metricsDTO.To)
+
+	partials, segments, err := p.getHourlyPartials(ctx, metricsDTO.EventNames, metricsDTO.GroupBy, from, to, rollupFrom, rollupTo)
+	if err != nil {
+		return model.Metrics{}, err
+	}
+
+	if metricsDTO.GapFill {
+		partials.fillGaps(from, to, rollupStep(metricsDTO.GroupBy))
+	}
+
+	return partials.fill(metrics, metricsDTO, segments), nil
+}
+
+// getHourlyPartials reads [rollupFrom, rollupTo) from the hourly rollups and the edges of [from, to) outside of it from the raw table.
+func (p *PostgresStore) getHourlyPartials(ctx context.Context, eventNames []string, groupBy string, from, to, rollupFrom, rollupTo time.Time) (metricsPartials, []model.MetricsSegment, error) {
+	partials := make(metricsPartials)
+	var segments []model.MetricsSegment
+
+	if from.Before(rollupFrom) {
+		if err := p.addRawPartials(ctx, partials, eventNames, groupBy, from, rollupFrom); err != nil {
+			return nil, nil, err
+		}
+		segments = append(segments, newMetricsSegment(from, rollupFrom, sourceRaw))
+	}
+
+	if err := p.addRollupPartials(ctx, partials, hourlyRollupTable, eventNames, groupBy, rollupFrom, rollupTo); err != nil {
+		return nil, nil, err
+	}
+	segments = append(segments, newMetricsSegment(rollupFrom, rollupTo, sourceHourlyRollup))
+
+	if rollupTo.Before(to) {
+		if err := p.addRawPartials(ctx, partials, eventNames, groupBy, rollupTo, to); err != nil {
+			return nil, nil, err
+		}
+		segments = append(segments, newMetricsSegment(rollupTo, to, sourceRaw))
+	}
+
+	return partials, segments, nil
+}
+
+// addRollupPartials merges the rows of a rollup table with bucket in [from, to) into partials.
+// table is one of the rollup table constants, never user input.
+func (p *PostgresStore) addRollupPartials(ctx context.Context, partials metricsPartials, table string, eventNames []string, groupBy string, from, to time.Time) error {
+	rollupQuery := `SELECT
+bucket,
+channel,
+campaign_id,
+total_events,
+estimated_events,
+users_hll
+FROM ` + table + `
+WHERE event_name = ANY($1)
+AND bucket >= $2 AND bucket < $3;`
+	rows, err := p.pool.Query(ctx, rollupQuery, eventNames, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket time.Time
+		var channel, campaignID string
+		var totalEvents int64
+		var estimatedEvents float64
+		var sketch []byte
+		if err := rows.Scan(&bucket, &channel, &campaignID, &totalEvents, &estimatedEvents, &sketch); err != nil {
+			return err
+		}
+
+		users, err := decodeUserSketch(sketch)
+		if err != nil {
+			return err
+		}
+		if err := partials.add(groupKey(groupBy, bucket, channel, campaignID), totalEvents, estimatedEvents, users); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// addRawPartials reads [from, to) from the raw events table as mergeable partials.
+// It returns one row per hour, channel, campaign and user, so it is meant for short ranges such as the edges around rollups.
+func (p *PostgresStore) addRawPartials(ctx context.Context, partials metricsPartials, eventNames []string, groupBy string, from, to time.Time) error {
+	rawQuery := `SELECT
+DATE_TRUNC('hour', ts, 'UTC') AS bucket,
+channel,
+COALESCE(campaign_id, '') AS campaign_id,
+user_id,
+COUNT(*) AS total_count,
+SUM(1.0 / sample_rate) AS estimated_count
+FROM events
+WHERE event_name = ANY($1)
+AND ts >= $2 AND ts < $3
+GROUP BY bucket, channel, campaign_id, user_id;`
+	rows, err := p.pool.Query(ctx, rawQuery, eventNames, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket time.Time
+		var channel, campaignID, userID string
+		var totalEvents int64
+		var estimatedEvents float64
+		if err := rows.Scan(&bucket, &channel, &campaignID, &userID, &totalEvents, &estimatedEvents); err != nil {
+			return err
+		}
+
+		users := newUserSketch()
+		users.Insert([]byte(userID))
+		if err := partials.add(groupKey(groupBy, bucket, channel, campaignID), totalEvents, estimatedEvents, users); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// rollupStep returns the bucket size of a time group_by served from rollups, or 0 for non-time groupings.
+func rollupStep(groupBy string) time.Duration {
+	switch groupBy {
+	case "hour":
+		return time.Hour
+	case "day":
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+// IsRollupGroupBy reports whether metrics grouped by groupBy can be served from the rollups.
+// Other groupings, eg: week or metadata.<key>, are always computed from raw events.
+func IsRollupGroupBy(groupBy string) bool {
+	switch groupBy {
+	case "", "hour", "day", "channel", "campaign_id":
+		return true
+	}
+	return false
+}
+
+func newMetricsSegment(from, to time.Time, resolution string) model.MetricsSegment {
+	return model.MetricsSegment{
+		From:       from.Format(time.RFC3339),
+		To:         to.Format(time.RFC3339),
+		Resolution: resolution,
+	}
+}
+
+// groupKey maps a rollup row onto the group it contributes to for the requested group_by.
+func groupKey(groupBy string, bucket time.Time, channel, campaignID string) partialKey {
+	switch groupBy {
+	case "hour":
+		return partialKey{bucket: bucket.Truncate(time.Hour).Unix()}
+	case "day":
+		return partialKey{bucket: bucket.Truncate(24 * time.Hour).Unix()}
+	case "channel":
+		return partialKey{dimension: channel}
+	case "campaign_id":
+		return partialKey{dimension: campaignID}
+	}
+	return partialKey{}
+}
+
+func (m metricsPartials) add(key partialKey, totalEvents int64, estimatedEvents float64, users *hyperloglog.Sketch) error {
+	partial, ok := m[key]
+	if !ok {
+		partial = &metricsPartial{users: newUserSketch()}
+		m[key] = partial
+	}
+	partial.totalEvents += totalEvents
+	partial.estimatedEvents += estimatedEvents
+	return partial.users.Merge(users)
+}
+
+// addExact adds a group read with an exact user count, see metricsPartial.exactUsers.
+func (m metricsPartials) addExact(key partialKey, totalEvents int64, estimatedEvents float64, users int64) {
+	partial, ok := m[key]
+	if !ok {
+		partial = &metricsPartial{users: newUserSketch()}
+		m[key] = partial
+	}
+	partial.totalEvents += totalEvents
+	partial.estimatedEvents += estimatedEvents
+	partial.exactUsers += users
+}
+
+// merge folds every group of other into m.
+func (m metricsPartials) merge(other metricsPartials) error {
+	for key, partial := range other {
+		if err := m.add(key, partial.totalEvents, partial.estimatedEvents, partial.users); err != nil {
+			return err
+		}
+		m[key].exactUsers += partial.exactUsers
+	}
+	return nil
+}
+
+// fill writes the totals and breakdown into metrics and records the segments they were read from.
+// Source is set to the coarsest resolution that was used.
+func (m metricsPartials) fill(metrics model.Metrics, metricsDTO api.MetricsRequestDTO, segments []model.MetricsSegment) model.Metrics {
+	totals := m.totals()
+	metrics.TotalEvents = totals.totalEvents
+	metrics.EstimatedTotalEvents = totals.estimatedTotal()
+	metrics.TotalUniqueEventsForUser = int64(totals.users.Estimate())
+
+	switch metricsDTO.GroupBy {
+	case "day", "hour":
+		metrics.GroupBreakdown = m.timeBreakdown()
+	case "channel", "campaign_id":
+		metrics.GroupBreakdown = dimensionBreakdown(metricsDTO.GroupBy, m.dimensionBreakdown(metricsDTO.OrderBy, metricsDTO.Limit, metricsDTO.TopDimensions))
+	}
+
+	metrics.Segments = segments
+	metrics.Source = sourceRaw
+	for _, segment := range segments {
+		if resolutionRank[segment.Resolution] > resolutionRank[metrics.Source] {
+			metrics.Source = segment.Resolution
+		}
+	}
+
+	return metrics
+}
+
+// fillGaps adds an empty group for every bucket start in [from, to) so gap-filled breakdowns are continuous.
+// Does nothing for non-time groupings (step 0).
+func (m metricsPartials) fillGaps(from, to time.Time, step time.Duration) {
+	if step <= 0 {
+		return
+	}
+	for bucket := from.Truncate(step); bucket.Before(to); bucket = bucket.Add(step) {
+		key := partialKey{bucket: bucket.Unix()}
+		if _, ok := m[key]; !ok {
+			m[key] = &metricsPartial{users: newUserSketch()}
+		}
+	}
+}
+
+// totals merges every group into a single partial.
+func (m metricsPartials) totals() *metricsPartial {
+	totals := &metricsPartial{users: newUserSketch()}
+	for _, partial := range m {
+		totals.totalEvents += partial.totalEvents
+		totals.estimatedEvents += partial.estimatedEvents
+		_ = totals.users.Merge(partial.users)
+	}
+	return totals
+}
+
+// uniqueUsers returns the exact user count of a group read as one, the estimate of its sketch otherwise.
+func (p *metricsPartial) uniqueUsers() int64 {
+	if p.exactUsers > 0 {
+		return p.exactUsers
+	}
+	return int64(p.users.Estimate())
+}
+
+// estimatedTotal rounds the estimated event count.
+func (p *metricsPartial) estimatedTotal() int64 {
+	return int64(math.Round(p.estimatedEvents))
+}
+
+func (m metricsPartials) timeBreakdown() []model.MetricsTimeGroupQueryResult {
+	results := make([]model.MetricsTimeGroupQueryResult, 0, len(m))
+	for key, partial := range m {
+		results = append(results, model.MetricsTimeGroupQueryResult{
+			Bucket:                   time.Unix(key.bucket, 0).UTC(),
+			TotalEvents:              partial.totalEvents,
+			EstimatedTotalEvents:     partial.estimatedTotal(),
+			TotalUniqueEventsForUser: partial.uniqueUsers(),
+		})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Bucket.Before(results[j].Bucket) })
+	return results
+}
+
+// dimensionBreakdown returns one row per dimension value ordered by orderBy. With a limit, the groups
+// beyond it are merged into a single OtherDimension row so users shared between them are counted once.
+func (m metricsPartials) dimensionBreakdown(orderBy string, limit int, topDimensions []string) []model.MetricsDimensionGroupQueryResult {
+	results := make([]model.MetricsDimensionGroupQueryResult, 0, len(m))
+	for key, partial := range m {
+		results = append(results, model.MetricsDimensionGroupQueryResult{
+			Dimension:                key.dimension,
+			TotalEvents:              partial.totalEvents,
+			EstimatedTotalEvents:     partial.estimatedTotal(),
+			TotalUniqueEventsForUser: int64(partial.users.Estimate()),
+		})
+	}
+	sortDimensionRows(results, orderBy)
+
+	var top, rest []model.MetricsDimensionGroupQueryResult
+	switch {
+	case len(topDimensions) > 0:
+		for _, r := range results {
+			if slices.Contains(topDimensions, r.Dimension) {
+				top = append(top, r)
+			} else {
+				rest = append(rest, r)
+			}
+		}
+		if len(rest) == 0 {
+			return top
+		}
+	case limit <= 0 || len(results) <= limit:
+		return results
+	default:
+		top, rest = results[:limit], results[limit:]
+	}
+
+	other := &metricsPartial{users: newUserSketch()}
+	for _, r := range rest {
+		partial := m[partialKey{dimension: r.Dimension}]
+		other.totalEvents += partial.totalEvents
+		other.estimatedEvents += partial.estimatedEvents
+		_ = other.users.Merge(partial.users)
+	}
+
+	return append(top, model.MetricsDimensionGroupQueryResult{
+		Dimension:                OtherDimension,
+		Other:                    true,
+		TotalEvents:              other.totalEvents,
+		EstimatedTotalEvents:     other.estimatedTotal(),
+		TotalUniqueEventsForUser: int64(other.users.Estimate()),
+	})
+}
+
+// newUserSketch returns an empty distinct-user sketch. All sketches share the same precision so they can be merged.
+func newUserSketch() *hyperloglog.Sketch {
+	return hyperloglog.New14()
+}
+
+// decodeUserSketch deserializes a stored sketch, treating an empty value as an empty sketch.
+func decodeUserSketch(data []byte) (*hyperloglog.Sketch, error) {
+	sketch := newUserSketch()
+	if len(data) == 0 {
+		return sketch, nil
+	}
+	if err := sketch.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return sketch, nil
+}
@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGroupKey(t *testing.T) {
+	bucket := time.Date(2026, 2, 1, 13, 0, 0, 0, time.UTC)
+
+	t.Run("hour keeps the hour bucket", func(t *testing.T) {
+		key := groupKey("hour", bucket, "web", "")
+		if key.bucket != bucket.Unix() || key.dimension != "" {
+			t.Errorf("unexpected key %+v", key)
+		}
+	})
+
+	t.Run("day truncates to UTC midnight", func(t *testing.T) {
+		key := groupKey("day", bucket, "web", "")
+		expected := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC).Unix()
+		if key.bucket != expected {
+			t.Errorf("expected %d, got %d", expected, key.bucket)
+		}
+	})
+
+	t.Run("channel ignores the bucket", func(t *testing.T) {
+		key := groupKey("channel", bucket, "web", "")
+		if key.bucket != 0 || key.dimension != "web" {
+			t.Errorf("unexpected key %+v", key)
+		}
+	})
+
+	t.Run("no grouping collapses everything", func(t *testing.T) {
+		if groupKey("", bucket, "web", "") != (partialKey{}) {
+			t.Error("expected empty key")
+		}
+	})
+}
+
+func TestMetricsPartials(t *testing.T) {
+	sketchOf := func(users ...string) *metricsPartial {
+		p := &metricsPartial{users: newUserSketch()}
+		for _, u := range users {
+			p.users.Insert([]byte(u))
+			p.totalEvents++
+			p.estimatedEvents++
+		}
+		return p
+	}
+
+	t.Run("merging overlapping groups does not double count users", func(t *testing.T) {
+		partials := make(metricsPartials)
+		a := sketchOf("user_1", "user_2")
+		b := sketchOf("user_2", "user_3")
+		key := partialKey{dimension: "web"}
+		if err := partials.add(key, a.totalEvents, a.estimatedEvents, a.users); err != nil {
+			t.Fatal(err)
+		}
+		if err := partials.add(key, b.totalEvents, b.estimatedEvents, b.users); err != nil {
+			t.Fatal(err)
+		}
+
+		totals := partials.totals()
+		if totals.totalEvents != 4 {
+			t.Errorf("expected 4 events, got %d", totals.totalEvents)
+		}
+		if uniqueUsers := totals.users.Estimate(); uniqueUsers != 3 {
+			t.Errorf("expected 3 unique users, got %d", uniqueUsers)
+		}
+	})
+
+	t.Run("time breakdown is sorted by bucket", func(t *testing.T) {
+		partials := make(metricsPartials)
+		for _, h := range []int{5, 1, 3} {
+			p := sketchOf(fmt.Sprintf("user_%d", h))
+			key := partialKey{bucket: time.Date(2026, 2, 1, h, 0, 0, 0, time.UTC).Unix()}
+			if err := partials.add(key, p.totalEvents, p.estimatedEvents, p.users); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		results := partials.timeBreakdown()
+		if len(results) != 3 {
+			t.Fatalf("expected 3 buckets, got %d", len(results))
+		}
+		for i := 1; i < len(results); i++ {
+			if !results[i-1].Bucket.Before(results[i].Bucket) {
+				t.Errorf("buckets not sorted: %v", results)
+			}
+		}
+	})
+
+	t.Run("groups beyond the limit are collapsed into other", func(t *testing.T) {
+		partials := make(metricsPartials)
+		groups := map[string][]string{
+			"spring": {"user_1", "user_2", "user_3"},
+			"summer": {"user_1", "user_2"},
+			"autumn": {"user_4"},
+			"winter": {"user_4", "user_5"},
+		}
+		for campaign, users := range groups {
+			p := sketchOf(users...)
+			if err := partials.add(partialKey{dimension: campaign}, p.totalEvents, p.estimatedEvents, p.users); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		results := partials.dimensionBreakdown(OrderByCount, 2, nil)
+		if len(results) != 3 {
+			t.Fatalf("expected 3 rows, got %v", results)
+		}
+		if results[0].Dimension != "spring" || results[1].Dimension != "summer" {
+			t.Errorf("unexpected top rows %v", results)
+		}
+
+		other := results[2]
+		if other.Dimension != OtherDimension || !other.Other || other.TotalEvents != 3 {
+			t.Errorf("unexpected other row %+v", other)
+		}
+		// user_4 is in both collapsed groups
+		if other.TotalUniqueEventsForUser != 2 {
+			t.Errorf("expected 2 unique users in other, got %d", other.TotalUniqueEventsForUser)
+		}
+
+		var totalEvents int64
+		for _, r := range results {
+			totalEvents += r.TotalEvents
+		}
+		if all := partials.totals().totalEvents; totalEvents != all {
+			t.Errorf("breakdown adds up to %d, expected %d", totalEvents, all)
+		}
+	})
+
+	t.Run("time buckets read with exact counts keep them through merge", func(t *testing.T) {
+		raw := make(metricsPartials)
+		key := partialKey{bucket: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC).Unix()}
+		raw.addExact(key, 10, 10, 4)
+
+		partials := make(metricsPartials)
+		if err := partials.merge(raw); err != nil {
+			t.Fatal(err)
+		}
+
+		results := partials.timeBreakdown()
+		if len(results) != 1 {
+			t.Fatalf("expected 1 bucket, got %d", len(results))
+		}
+		if results[0].TotalEvents != 10 || results[0].TotalUniqueEventsForUser != 4 {
+			t.Errorf("unexpected bucket %+v", results[0])
+		}
+	})
+
+	t.Run("sampled events are scaled up", func(t *testing.T) {
+		partials := make(metricsPartials)
+		// 3 events kept at 10% and 1 unsampled event
+		if err := partials.add(partialKey{bucket: 1}, 3, 30, newUserSketch()); err != nil {
+			t.Fatal(err)
+		}
+		if err := partials.add(partialKey{bucket: 2}, 1, 1, newUserSketch()); err != nil {
+			t.Fatal(err)
+		}
+
+		totals := partials.totals()
+		if totals.totalEvents != 4 || totals.estimatedTotal() != 31 {
+			t.Errorf("expected 4 events estimated at 31, got %d and %d", totals.totalEvents, totals.estimatedTotal())
+		}
+		if results := partials.timeBreakdown(); results[0].EstimatedTotalEvents != 30 || results[1].EstimatedTotalEvents != 1 {
+			t.Errorf("unexpected breakdown %+v", results)
+		}
+	})
+
+	t.Run("stored sketch round trips", func(t *testing.T) {
+		p := sketchOf("user_1", "user_2")
+		data, err := p.users.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := decodeUserSketch(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Estimate() != 2 {
+			t.Errorf("expected 2, got %d", decoded.Estimate())
+		}
+	})
+
+	t.Run("empty stored sketch decodes to empty sketch", func(t *testing.T) {
+		decoded, err := decodeUserSketch(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Estimate() != 0 {
+			t.Errorf("expected 0, got %d", decoded.Estimate())
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/model"
+)
+
+// Session metric aggregates shared by the totals and grouped session queries.
+const sessionAggregates = `COUNT(*) AS total_sessions,
+COALESCE(AVG(EXTRACT(EPOCH FROM ended_at - started_at)), 0)::float8 AS avg_session_duration_seconds,
+COALESCE(AVG(event_count), 0)::float8 AS avg_events_per_session,
+COALESCE(AVG(CASE WHEN event_count = 1 THEN 1.0 ELSE 0.0 END), 0)::float8 AS bounce_rate`
+
+// GetSessionMetrics reconstructs sessions at query time. A user's events are ordered by ts and a new session
+// starts whenever the gap to the previous event exceeds the configured inactivity gap.
+// Sessions are attributed to the bucket and channel of their first event.
+func (p *PostgresStore) GetSessionMetrics(ctx context.Context, sessionsDTO api.SessionMetricsRequestDTO) (model.SessionMetrics, error) {
+	from := NormalizeTimestamp(sessionsDTO.From)
+	to := NormalizeTimestamp(sessionsDTO.To)
+
+	var sessions model.SessionMetrics = model.SessionMetrics{
+		EventName:         sessionsDTO.EventName,
+		From:              from.Format(time.RFC3339),
+		To:                to.Format(time.RFC3339),
+		SessionGapSeconds: int64(sessionsDTO.SessionGap.Seconds()),
+		GroupBy:           sessionsDTO.GroupBy,
+		Timezone:          sessionsDTO.Timezone,
+	}
+
+	cte, args := sessionsCTE(sessionsDTO)
+
+	row := p.pool.QueryRow(ctx, cte+"SELECT "+sessionAggregates+" FROM sessions;", args...)
+	if err := row.Scan(&sessions.TotalSessions, &sessions.AvgSessionDurationSeconds, &sessions.AvgEventsPerSession, &sessions.BounceRate); err != nil {
+		return model.SessionMetrics{}, err
+	}
+
+	if _, ok := ParseTimeGranularity(sessionsDTO.GroupBy); ok {
+		groupQueryResults, err := p.getSessionTimeGroupQuery(ctx, sessionsDTO)
+		if err == nil {
+			sessions.GroupBreakdown = groupQueryResults
+		}
+	} else if sessionsDTO.GroupBy == "channel" {
+		channelGroupQueryResults, err := p.getSessionChannelGroupQuery(ctx, sessionsDTO)
+		if err == nil {
+			sessions.GroupBreakdown = channelGroupQueryResults
+		}
+	}
+
+	return sessions, nil
+}
+
+func (p *PostgresStore) getSessionTimeGroupQuery(ctx context.Context, sessionsDTO api.SessionMetricsRequestDTO) ([]model.SessionMetricsTimeGroupQueryResult, error) {
+	cte, args := sessionsCTE(sessionsDTO)
+	granularity, _ := ParseTimeGranularity(sessionsDTO.GroupBy)
+
+	groupQuery := cte + `SELECT
+` + granularity.sqlBucket("started_at", &args, sessionsDTO.Timezone) + ` AS bucket,
+` + sessionAggregates + `
+FROM sessions
+GROUP BY bucket
+ORDER BY bucket;`
+	rows, err := p.pool.Query(ctx, groupQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loc := loadLocation(sessionsDTO.Timezone)
+
+	var results []model.SessionMetricsTimeGroupQueryResult
+	for rows.Next() {
+		var r model.SessionMetricsTimeGroupQueryResult
+		if err := rows.Scan(&r.Bucket, &r.TotalSessions, &r.AvgSessionDurationSeconds, &r.AvgEventsPerSession, &r.BounceRate); err != nil {
+			return nil, err
+		}
+		r.Bucket = r.Bucket.In(loc)
+		results = append(results, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (p *PostgresStore) getSessionChannelGroupQuery(ctx context.Context, sessionsDTO api.SessionMetricsRequestDTO) ([]model.SessionMetricsChannelGroupQueryResult, error) {
+	cte, args := sessionsCTE(sessionsDTO)
+
+	groupQuery := cte + `SELECT
+channel,
+` + sessionAggregates + `
+FROM sessions
+GROUP BY channel
+ORDER BY channel;`
+	rows, err := p.pool.Query(ctx, groupQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []model.SessionMetricsChannelGroupQueryResult
+	for rows.Next() {
+		var r model.SessionMetricsChannelGroupQueryResult
+		if err := rows.Scan(&r.Channel, &r.TotalSessions, &r.AvgSessionDurationSeconds, &r.AvgEventsPerSession, &r.BounceRate); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// sessionsCTE builds the common table expressions that turn raw events into one row per session.
+// The returned args are positional: $1 from, $2 to, $3 gap in seconds and optionally $4 event_name.
+func sessionsCTE(sessionsDTO api.SessionMetricsRequestDTO) (string, queryArgs) {
+	args := queryArgs{
+		NormalizeTimestamp(sessionsDTO.From),
+		NormalizeTimestamp(sessionsDTO.To),
+		sessionsDTO.SessionGap.Seconds(),
+	}
+
+	eventFilter := ""
+	if sessionsDTO.EventName != "" {
+		args = append(args, sessionsDTO.EventName)
+		eventFilter = "AND event_name = $4"
+	}
+
+	cte := `WITH ordered AS (
+SELECT user_id, channel, ts,
+LAG(ts) OVER (PARTITION BY user_id ORDER BY ts) AS prev_ts
+FROM events
+WHERE ts >= $1 AND ts < $2
+` + eventFilter + `
+),
+numbered AS (
+SELECT user_id, channel, ts,
+SUM(CASE WHEN prev_ts IS NULL OR EXTRACT(EPOCH FROM ts - prev_ts) > $3 THEN 1 ELSE 0 END)
+OVER (PARTITION BY user_id ORDER BY ts ROWS UNBOUNDED PRECEDING) AS session_idx
+FROM ordered
+),
+sessions AS (
+SELECT user_id, session_idx,
+MIN(ts) AS started_at,
+MAX(ts) AS ended_at,
+COUNT(*) AS event_count,
+(ARRAY_AGG(channel ORDER BY ts))[1] AS channel
+FROM numbered
+GROUP BY user_id, session_idx
+)
+`
+
+	return cte, args
+}
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+)
+
+func TestSessionsCTE(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		eventName  string
+		gap        time.Duration
+		wantFilter bool
+		wantArgs   int
+	}{
+		{"every event", "", 30 * time.Minute, false, 3},
+		{"narrowed to an event", "page_view", 30 * time.Minute, true, 4},
+		{"custom gap", "", 15 * time.Minute, false, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cte, args := sessionsCTE(api.SessionMetricsRequestDTO{
+				EventName:  tt.eventName,
+				From:       from.Unix(),
+				To:         to.Unix(),
+				SessionGap: tt.gap,
+			})
+
+			if len(args) != tt.wantArgs {
+				t.Fatalf("expected %d args, got %v", tt.wantArgs, args)
+			}
+			if args[0] != from || args[1] != to || args[2] != tt.gap.Seconds() {
+				t.Errorf("unexpected range or gap args %v", args)
+			}
+			if got := strings.Contains(cte, "AND event_name = $4"); got != tt.wantFilter {
+				t.Errorf("expected event filter %v, got %v", tt.wantFilter, got)
+			}
+			if tt.wantFilter && args[3] != tt.eventName {
+				t.Errorf("expected event_name arg %q, got %v", tt.eventName, args[3])
+			}
+			if !strings.Contains(cte, "EXTRACT(EPOCH FROM ts - prev_ts) > $3") {
+				t.Error("expected a new session past the gap")
+			}
+
+			// Placeholders appended by the grouped queries must follow the CTE ones
+			for _, match := range regexp.MustCompile(`\$(\d+)`).FindAllStringSubmatch(cte, -1) {
+				if n, _ := strconv.Atoi(match[1]); n > len(args) {
+					t.Errorf("placeholder $%d has no arg", n)
+				}
+			}
+			if bucket := (TimeGranularity{Unit: "day"}).sqlBucket("started_at", &args, "UTC"); !strings.HasPrefix(bucket, "DATE_TRUNC($"+strconv.Itoa(tt.wantArgs+1)+",") {
+				t.Errorf("unexpected bucket expression %q", bucket)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package storage
+
+import (
+	"context"
+
+	"fast-ingest/internal/model"
+)
+
+// Sink receives batches of events once they are stored, to publish them to downstream destinations.
+type Sink interface {
+	// Name identifies the destination in logs and delivery status.
+	Name() string
+
+	// Publish delivers a batch of stored events. A failed batch may be published again, so destinations
+	// should deduplicate by dedupe key.
+	Publish(ctx context.Context, events []model.Event) error
+}
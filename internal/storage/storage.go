@@ -15,12 +15,31 @@ type Store interface {
 	// InsertEvent persists a single raw event.
 	InsertEvent(ctx context.Context, e model.Event) error
 
-	// InsertEvents persists a batch of raw events (preferred path for ingestion).
-	InsertEvents(ctx context.Context, events []model.Event) error
+	// InsertEvents persists a batch of raw events (preferred path for ingestion) and returns the ones inserted,
+	// events already stored under their dedupe key are left out.
+	InsertEvents(ctx context.Context, events []model.Event) ([]model.Event, error)
 
 	// GetMetrics retrieves aggregated metrics based on the provided filters and grouping.
 	GetMetrics(ctx context.Context, metricsDTO api.MetricsRequestDTO) (model.Metrics, error)
 
+	// GetSessionMetrics reconstructs user sessions from raw events and returns aggregated session metrics.
+	GetSessionMetrics(ctx context.Context, sessionsDTO api.SessionMetricsRequestDTO) (model.SessionMetrics, error)
+
+	// SearchEvents returns a page of stored events matching the filters, newest first.
+	SearchEvents(ctx context.Context, searchDTO api.EventSearchRequestDTO) (model.EventPage, error)
+
+	// GetEvent returns a single stored event by dedupe key, or ErrEventNotFound.
+	GetEvent(ctx context.Context, dedupeKey string) (model.StoredEvent, error)
+
+	// ExportEvents streams the events of a range to fn, oldest first, without loading them all in memory.
+	ExportEvents(ctx context.Context, exportDTO api.ExportRequestDTO, fn func(model.StoredEvent) error) error
+
+	// EraseUser deletes or anonymizes every event of a user for privacy requests and records it in the audit log.
+	EraseUser(ctx context.Context, erasureDTO api.ErasureRequestDTO) (model.ErasureResult, error)
+
+	// CompactDailyRollups rebuilds the daily rollups for days that received events since the last run and returns how many were rebuilt.
+	CompactDailyRollups(ctx context.Context) (int, error)
+
 	// Close releases resources (db connections, file handles, etc.).
 	Close()
 }
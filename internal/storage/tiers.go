@@ -0,0 +1,359 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	api "fast-ingest/internal/api/dto"
+	"fast-ingest/internal/model"
+
+	"github.com/axiomhq/hyperloglog"
+	"github.com/jackc/pgx/v5"
+)
+
+// TierLimits defines how far back each metrics resolution can be queried.
+// Ranges older than Raw are served from the daily rollups, Daily is the overall limit.
+type TierLimits struct {
+	Raw   time.Duration
+	Daily time.Duration
+}
+
+// DefaultTierLimits keeps 30 days at full resolution and 3 years at daily resolution.
+var DefaultTierLimits = TierLimits{
+	Raw:   30 * 24 * time.Hour,
+	Daily: 3 * 365 * 24 * time.Hour,
+}
+
+// TierLimitsFromEnv reads METRICS_RAW_MAX_DAYS and METRICS_DAILY_MAX_DAYS, falling back to DefaultTierLimits.
+func TierLimitsFromEnv() (TierLimits, error) {
+	limits := DefaultTierLimits
+
+	for _, setting := range []struct {
+		env    string
+		target *time.Duration
+	}{
+		{"METRICS_RAW_MAX_DAYS", &limits.Raw},
+		{"METRICS_DAILY_MAX_DAYS", &limits.Daily},
+	} {
+		value := os.Getenv(setting.env)
+		if value == "" {
+			continue
+		}
+		days, err := strconv.Atoi(value)
+		if err != nil || days <= 0 {
+			return TierLimits{}, fmt.Errorf("invalid %s value %q", setting.env, value)
+		}
+		*setting.target = time.Duration(days) * 24 * time.Hour
+	}
+
+	if limits.Daily < limits.Raw {
+		return TierLimits{}, fmt.Errorf("METRICS_DAILY_MAX_DAYS must not be lower than METRICS_RAW_MAX_DAYS")
+	}
+
+	return limits, nil
+}
+
+// TierLimits returns the per-resolution query limits the store was configured with.
+func (p *PostgresStore) TierLimits() TierLimits {
+	return p.tierLimits
+}
+
+// rawTierBoundary returns the first midnight (UTC) inside the raw retention window.
+// Everything before it is served at daily resolution.
+func rawTierBoundary(now time.Time, limits TierLimits) time.Time {
+	return now.Add(-limits.Raw).Truncate(24 * time.Hour).Add(24 * time.Hour)
+}
+
+// dailyRollupBoundary decides whether a metrics query reaches past the raw retention window
+// and the daily rollups are ready to serve the older part.
+func (p *PostgresStore) dailyRollupBoundary(ctx context.Context, groupBy string, from time.Time) (time.Time, bool) {
+	if !p.useRollups || !IsRollupGroupBy(groupBy) {
+		return time.Time{}, false
+	}
+
+	boundary := rawTierBoundary(time.Now(), p.tierLimits)
+	if !from.Before(boundary) {
+		return time.Time{}, false
+	}
+
+	// The daily rollups are usable once the compactor finished its first pass over the events table
+	var ingestedThrough *time.Time
+	err := p.pool.QueryRow(ctx, `SELECT ingested_through FROM rollup_state WHERE name = 'daily';`).Scan(&ingestedThrough)
+	if err != nil || ingestedThrough == nil {
+		return time.Time{}, false
+	}
+
+	return boundary, true
+}
+
+// getTieredMetrics serves the part of the range before boundary from the daily rollups, widened to whole days,
+// and the rest from the hourly rollups or the raw table. Both parts are merged into a single result.
+func (p *PostgresStore) getTieredMetrics(ctx context.Context, metricsDTO api.MetricsRequestDTO, metrics model.Metrics, boundary time.Time) (model.Metrics, error) {
+	from := NormalizeTimestamp(metricsDTO.From)
+	to := NormalizeTimestamp(metricsDTO.To)
+
+	dailyFrom := from.Truncate(24 * time.Hour)
+	dailyTo := to.Truncate(24 * time.Hour)
+	if dailyTo.Before(to) {
+		dailyTo = dailyTo.Add(24 * time.Hour)
+	}
+	if dailyTo.After(boundary) {
+		dailyTo = boundary
+	}
+
+	partials := make(metricsPartials)
+	if err := p.addRollupPartials(ctx, partials, dailyRollupTable, metricsDTO.EventNames, metricsDTO.GroupBy, dailyFrom, dailyTo); err != nil {
+		return model.Metrics{}, err
+	}
+	segments := []model.MetricsSegment{newMetricsSegment(dailyFrom, dailyTo, sourceDailyRollup)}
+
+	// The daily part only has day buckets, even when grouping by hour
+	if metricsDTO.GapFill && rollupStep(metricsDTO.GroupBy) > 0 {
+		partials.fillGaps(dailyFrom, dailyTo, 24*time.Hour)
+	}
+
+	// Users of the recent part when its time buckets were read with exact counts, to count users across both parts
+	var recentUsers *hyperloglog.Sketch
+
+	if to.After(boundary) {
+		var recent metricsPartials
+		var recentSegments []model.MetricsSegment
+		var err error
+
+		if rollupFrom, rollupTo, ok := p.hourlyRollupWindow(ctx, metricsDTO.GroupBy, boundary, to); ok {
+			recent, recentSegments, err = p.getHourlyPartials(ctx, metricsDTO.EventNames, metricsDTO.GroupBy, boundary, to, rollupFrom, rollupTo)
+		} else {
+			// Up to the whole raw window, eg: rollups are disabled or still catching up after a deploy
+			recent = make(metricsPartials)
+			recentUsers, err = p.addRawAggregatePartials(ctx, recent, metricsDTO, boundary, to)
+			recentSegments = []model.MetricsSegment{newMetricsSegment(boundary, to, sourceRaw)}
+		}
+		if err != nil {
+			return model.Metrics{}, err
+		}
+
+		if metricsDTO.GapFill {
+			recent.fillGaps(boundary, to, rollupStep(metricsDTO.GroupBy))
+		}
+
+		if err := partials.merge(recent); err != nil {
+			return model.Metrics{}, err
+		}
+		segments = append(segments, recentSegments...)
+	}
+
+	metrics = partials.fill(metrics, metricsDTO, segments)
+	if recentUsers != nil {
+		users := partials.totals().users
+		if err := users.Merge(recentUsers); err != nil {
+			return model.Metrics{}, err
+		}
+		metrics.TotalUniqueEventsForUser = int64(users.Estimate())
+	}
+
+	return metrics, nil
+}
+
+// addRawAggregatePartials merges [from, to) of the raw events table into partials with SQL aggregates, for ranges
+// too long for addRawPartials. Time buckets don't overlap the daily part, so they are read with the time group
+// query and exact counts, and their users are returned as one sketch for the totals. Other groupings need a sketch
+// per group to merge with the daily part, they are read as one row per distinct user of each group.
+func (p *PostgresStore) addRawAggregatePartials(ctx context.Context, partials metricsPartials, metricsDTO api.MetricsRequestDTO, from, to time.Time) (*hyperloglog.Sketch, error) {
+	rawDTO := metricsDTO
+	rawDTO.From = from.Unix()
+	rawDTO.To = to.Unix()
+	rawDTO.GapFill = false
+	rawDTO.PageSize, rawDTO.Cursor = 0, ""
+
+	args := queryArgs{}
+	group := "''"
+	if _, ok := ParseTimeGranularity(metricsDTO.GroupBy); ok {
+		buckets, err := p.getTimeGroupQuery(rawDTO)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range buckets {
+			partials.addExact(partialKey{bucket: b.Bucket.Unix()}, b.TotalEvents, float64(b.EstimatedTotalEvents), b.TotalUniqueEventsForUser)
+		}
+	} else if IsDimensionGroupBy(metricsDTO.GroupBy) {
+		group = dimensionSQL(metricsDTO.GroupBy, &args)
+	}
+
+	usersQuery := `SELECT
+` + group + ` AS dimension,
+user_id,
+COUNT(*) AS total_count,
+SUM(1.0 / sample_rate) AS estimated_count
+FROM events
+WHERE event_name = ANY(` + args.add(metricsDTO.EventNames) + `)
+AND ts >= ` + args.add(from) + ` AND ts < ` + args.add(to) + `
+GROUP BY dimension, user_id;`
+	rows, err := p.pool.Query(ctx, usersQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	_, timeGrouped := ParseTimeGranularity(metricsDTO.GroupBy)
+	users := newUserSketch()
+	for rows.Next() {
+		var dimension, userID string
+		var totalEvents int64
+		var estimatedEvents float64
+		if err := rows.Scan(&dimension, &userID, &totalEvents, &estimatedEvents); err != nil {
+			return nil, err
+		}
+
+		if timeGrouped {
+			users.Insert([]byte(userID))
+			continue
+		}
+
+		groupUsers := newUserSketch()
+		groupUsers.Insert([]byte(userID))
+		if err := partials.add(partialKey{dimension: dimension}, totalEvents, estimatedEvents, groupUsers); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !timeGrouped {
+		return nil, nil
+	}
+	return users, nil
+}
+
+// CompactDailyRollups rebuilds the daily rollups for every day that received events since the last run.
+// Progress is tracked with an ingested_at watermark in rollup_state, so late events cause their day to be rebuilt.
+// Returns the number of days rebuilt.
+func (p *PostgresStore) CompactDailyRollups(ctx context.Context) (int, error) {
+	var ingestedThrough *time.Time
+	err := p.pool.QueryRow(ctx, `SELECT ingested_through FROM rollup_state WHERE name = 'daily';`).Scan(&ingestedThrough)
+	if err != nil {
+		return 0, err
+	}
+
+	since := time.Unix(0, 0).UTC()
+	if ingestedThrough != nil {
+		since = *ingestedThrough
+	}
+
+	// Stay a little behind now() so batches still in flight (ingested_at is their transaction start) are not skipped
+	until := time.Now().Add(-dailyCompactionLag)
+
+	rows, err := p.pool.Query(ctx, `
+		SELECT DISTINCT DATE_TRUNC('day', ts, 'UTC') AS day
+		FROM events
+		WHERE ingested_at >= $1 AND ingested_at < $2
+		ORDER BY day;
+	`, since, until)
+	if err != nil {
+		return 0, err
+	}
+	days, err := pgx.CollectRows(rows, pgx.RowTo[time.Time])
+	if err != nil {
+		return 0, err
+	}
+
+	for _, day := range days {
+		if err := p.rebuildDailyRollup(ctx, day); err != nil {
+			return 0, fmt.Errorf("rebuild daily rollup for %s: %w", day.Format(time.DateOnly), err)
+		}
+	}
+
+	_, err = p.pool.Exec(ctx, `UPDATE rollup_state SET ingested_through = $1 WHERE name = 'daily';`, until)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(days), nil
+}
+
+// dailyCompactionLag is how far behind now() the daily compaction watermark stays.
+const dailyCompactionLag = 5 * time.Minute
+
+// rebuildDailyRollup recomputes every events_daily_rollup row of a single day from the raw events table.
+func (p *PostgresStore) rebuildDailyRollup(ctx context.Context, day time.Time) error {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rebuilt, err := rebuildRollupBucket(ctx, tx, dailyRollupTable, day.UTC(), 24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	log.Printf("Rebuilt daily rollup for %s (%d rows)", day.UTC().Format(time.DateOnly), rebuilt)
+
+	return nil
+}
+
+// rebuildRollupBucket recomputes every row of a rollup table for the bucket [bucket, bucket+size) from the raw events table
+// and returns how many rows it wrote. table is one of the rollup table constants, never user input.
+func rebuildRollupBucket(ctx context.Context, tx pgx.Tx, table string, bucket time.Time, size time.Duration) (int, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT event_name, channel, COALESCE(campaign_id, ''), user_id, COUNT(*), SUM(1.0 / sample_rate)
+		FROM events
+		WHERE ts >= $1 AND ts < $2
+		GROUP BY 1, 2, 3, 4;
+	`, bucket, bucket.Add(size))
+	if err != nil {
+		return 0, err
+	}
+
+	aggs := make(map[rollupKey]*metricsPartial)
+	for rows.Next() {
+		var key rollupKey
+		var userID string
+		var totalEvents int64
+		var estimatedEvents float64
+		if err := rows.Scan(&key.EventName, &key.Channel, &key.CampaignID, &userID, &totalEvents, &estimatedEvents); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		key.Bucket = bucket
+
+		agg, ok := aggs[key]
+		if !ok {
+			agg = &metricsPartial{users: newUserSketch()}
+			aggs[key] = agg
+		}
+		agg.totalEvents += totalEvents
+		agg.estimatedEvents += estimatedEvents
+		agg.users.Insert([]byte(userID))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	batch := &pgx.Batch{}
+	batch.Queue(`DELETE FROM `+table+` WHERE bucket = $1;`, bucket)
+	for key, agg := range aggs {
+		sketch, err := agg.users.MarshalBinary()
+		if err != nil {
+			return 0, err
+		}
+		batch.Queue(`
+			INSERT INTO `+table+` (bucket, event_name, channel, campaign_id, total_events, estimated_events, users_hll)
+			VALUES ($1,$2,$3,$4,$5,$6,$7);
+		`, key.Bucket, key.EventName, key.Channel, key.CampaignID, agg.totalEvents, agg.estimatedEvents, sketch)
+	}
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return 0, err
+	}
+
+	return len(aggs), nil
+}
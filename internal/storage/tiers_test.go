@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTierLimitsFromEnv(t *testing.T) {
+	t.Run("defaults when unset", func(t *testing.T) {
+		t.Setenv("METRICS_RAW_MAX_DAYS", "")
+		t.Setenv("METRICS_DAILY_MAX_DAYS", "")
+		limits, err := TierLimitsFromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if limits != DefaultTierLimits {
+			t.Errorf("expected defaults, got %+v", limits)
+		}
+	})
+
+	t.Run("reads days from env", func(t *testing.T) {
+		t.Setenv("METRICS_RAW_MAX_DAYS", "7")
+		t.Setenv("METRICS_DAILY_MAX_DAYS", "730")
+		limits, err := TierLimitsFromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if limits.Raw != 7*24*time.Hour || limits.Daily != 730*24*time.Hour {
+			t.Errorf("unexpected limits %+v", limits)
+		}
+	})
+
+	t.Run("rejects invalid values", func(t *testing.T) {
+		t.Setenv("METRICS_RAW_MAX_DAYS", "abc")
+		if _, err := TierLimitsFromEnv(); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("rejects daily limit below raw limit", func(t *testing.T) {
+		t.Setenv("METRICS_RAW_MAX_DAYS", "60")
+		t.Setenv("METRICS_DAILY_MAX_DAYS", "30")
+		if _, err := TierLimitsFromEnv(); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestRawTierBoundary(t *testing.T) {
+	now := time.Date(2026, 3, 31, 15, 30, 0, 0, time.UTC)
+	limits := TierLimits{Raw: 30 * 24 * time.Hour, Daily: 365 * 24 * time.Hour}
+
+	boundary := rawTierBoundary(now, limits)
+	expected := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+	if !boundary.Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, boundary)
+	}
+	if boundary.Before(now.Add(-limits.Raw)) {
+		t.Error("boundary must lie inside the raw window")
+	}
+}
@@ -0,0 +1,179 @@
+// Package tail broadcasts stored events to live subscribers, such as the SSE tail of the API.
+// Publishing never waits on a subscriber: each one has a bounded buffer, and a subscriber that falls
+// behind is dropped rather than slowing the writer down.
+package tail
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"fast-ingest/internal/model"
+)
+
+// Defaults of NewHub.
+const (
+	DefaultBufferSize     = 256
+	DefaultMaxSubscribers = 100
+)
+
+var (
+	// ErrSlowSubscriber ends a subscription whose buffer filled up.
+	ErrSlowSubscriber = errors.New("subscriber too slow, events were dropped")
+
+	// ErrClosed ends the subscriptions of a closed hub.
+	ErrClosed = errors.New("tail closed")
+
+	// ErrTooManySubscribers is returned by Subscribe when the hub is full.
+	ErrTooManySubscribers = errors.New("too many subscribers")
+)
+
+// Filter selects the events of a subscription. Every field set must match, any of the listed values matches.
+type Filter struct {
+	EventName []string
+	Channel   []string
+	UserID    []string
+}
+
+func (f Filter) matches(e model.Event) bool {
+	if len(f.EventName) > 0 && !slices.Contains(f.EventName, e.EventName) {
+		return false
+	}
+	if len(f.Channel) > 0 && !slices.Contains(f.Channel, e.Channel) {
+		return false
+	}
+	if len(f.UserID) > 0 && !slices.Contains(f.UserID, e.UserID) {
+		return false
+	}
+	return true
+}
+
+// Subscription receives the events matching its filter until it is ended.
+type Subscription struct {
+	filter Filter
+	events chan model.Event
+	done   chan struct{}
+	err    error
+}
+
+// Events returns the matching events, in the order they were stored.
+func (s *Subscription) Events() <-chan model.Event {
+	return s.events
+}
+
+// Done is closed once the hub ends the subscription, Err then tells why.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns ErrSlowSubscriber or ErrClosed once Done is closed, nil before.
+func (s *Subscription) Err() error {
+	select {
+	case <-s.done:
+		return s.err
+	default:
+		return nil
+	}
+}
+
+// Hub fans stored batches out to its subscriptions. It implements storage.Sink and is safe for concurrent use.
+type Hub struct {
+	bufferSize     int
+	maxSubscribers int
+
+	mu            sync.Mutex
+	subscriptions map[*Subscription]struct{}
+	closed        bool
+}
+
+// NewHub returns a hub buffering up to bufferSize events per subscription, for up to maxSubscribers subscriptions.
+func NewHub(bufferSize, maxSubscribers int) *Hub {
+	return &Hub{
+		bufferSize:     bufferSize,
+		maxSubscribers: maxSubscribers,
+		subscriptions:  make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe starts a subscription to the events matching filter. Unsubscribe it once done.
+func (h *Hub) Subscribe(filter Filter) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return nil, ErrClosed
+	}
+	if len(h.subscriptions) >= h.maxSubscribers {
+		return nil, ErrTooManySubscribers
+	}
+
+	s := &Subscription{
+		filter: filter,
+		events: make(chan model.Event, h.bufferSize),
+		done:   make(chan struct{}),
+	}
+	h.subscriptions[s] = struct{}{}
+	return s, nil
+}
+
+// Unsubscribe stops delivering events to s. It is a no-op for ended subscriptions.
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscriptions, s)
+}
+
+// Subscribers returns how many subscriptions are active.
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscriptions)
+}
+
+// Name implements storage.Sink.
+func (h *Hub) Name() string {
+	return "tail"
+}
+
+// Publish hands events to the matching subscriptions without blocking. A subscription without room
+// for an event is ended with ErrSlowSubscriber.
+func (h *Hub) Publish(_ context.Context, events []model.Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.subscriptions {
+		for _, e := range events {
+			if !s.filter.matches(e) {
+				continue
+			}
+			select {
+			case s.events <- e:
+			default:
+				h.end(s, ErrSlowSubscriber)
+			}
+			if s.err != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Close ends every subscription with ErrClosed and refuses new ones, eg: so open streams don't hold up a shutdown.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for s := range h.subscriptions {
+		h.end(s, ErrClosed)
+	}
+}
+
+// end removes s and closes its done channel, h.mu must be held.
+func (h *Hub) end(s *Subscription, err error) {
+	delete(h.subscriptions, s)
+	s.err = err
+	close(s.done)
+}
@@ -0,0 +1,98 @@
+package tail
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"fast-ingest/internal/model"
+)
+
+func TestPublishFilters(t *testing.T) {
+	hub := NewHub(10, 10)
+	all, _ := hub.Subscribe(Filter{})
+	purchases, _ := hub.Subscribe(Filter{EventName: []string{"purchase"}, UserID: []string{"user_1", "user_2"}})
+
+	_ = hub.Publish(context.Background(), []model.Event{
+		{EventName: "purchase", UserID: "user_1"},
+		{EventName: "page_view", UserID: "user_1"},
+		{EventName: "purchase", UserID: "user_3"},
+	})
+
+	if len(all.Events()) != 3 {
+		t.Errorf("expected every event, got %d", len(all.Events()))
+	}
+	if len(purchases.Events()) != 1 {
+		t.Fatalf("expected 1 matching event, got %d", len(purchases.Events()))
+	}
+	if e := <-purchases.Events(); e.EventName != "purchase" || e.UserID != "user_1" {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestPublishDropsSlowSubscribers(t *testing.T) {
+	hub := NewHub(2, 10)
+	slow, _ := hub.Subscribe(Filter{})
+	filtered, _ := hub.Subscribe(Filter{EventName: []string{"refund"}})
+
+	done := make(chan struct{})
+	go func() {
+		// Nobody reads, publishing must not block
+		for range 5 {
+			_ = hub.Publish(context.Background(), []model.Event{{EventName: "purchase"}})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish blocked on a slow subscriber")
+	}
+
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("expected the slow subscriber to be dropped")
+	}
+	if !errors.Is(slow.Err(), ErrSlowSubscriber) || len(slow.Events()) != 2 {
+		t.Errorf("unexpected subscription state: %v, %d buffered", slow.Err(), len(slow.Events()))
+	}
+
+	// A subscriber whose filter matched nothing has nothing to keep up with
+	if filtered.Err() != nil || hub.Subscribers() != 1 {
+		t.Errorf("expected the filtered subscriber to stay, got %v, %d subscriber(s)", filtered.Err(), hub.Subscribers())
+	}
+}
+
+func TestSubscribeLimits(t *testing.T) {
+	hub := NewHub(1, 1)
+	s, err := hub.Subscribe(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hub.Subscribe(Filter{}); !errors.Is(err, ErrTooManySubscribers) {
+		t.Errorf("expected ErrTooManySubscribers, got %v", err)
+	}
+
+	hub.Unsubscribe(s)
+	if _, err := hub.Subscribe(Filter{}); err != nil {
+		t.Errorf("expected a free slot after unsubscribing, got %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	hub := NewHub(1, 10)
+	s, _ := hub.Subscribe(Filter{})
+
+	hub.Close()
+	if !errors.Is(s.Err(), ErrClosed) || hub.Subscribers() != 0 {
+		t.Errorf("expected the subscription to be closed, got %v", s.Err())
+	}
+	if _, err := hub.Subscribe(Filter{}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+
+	// Unsubscribing an ended subscription is a no-op
+	hub.Unsubscribe(s)
+}
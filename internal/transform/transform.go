@@ -0,0 +1,257 @@
+// Package transform rewrites ingested events with declarative rules before they are queued,
+// eg: to normalize event names sent as PageView, page_view and pv.
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"fast-ingest/internal/model"
+)
+
+// Rule rewrites the events matching When. Its actions run in the order drop, rename, channel mapping, derive.
+type Rule struct {
+	Name string `json:"name"`
+	When Match  `json:"when"`
+
+	// Drop discards matching events, the remaining rules don't run.
+	Drop bool `json:"drop,omitempty"`
+
+	// RenameEvent replaces the event name.
+	RenameEvent string `json:"rename_event,omitempty"`
+
+	// MapChannel replaces channel values, eg: {"Web": "web", "iOS": "ios"}.
+	MapChannel map[string]string `json:"map_channel,omitempty"`
+
+	// Derive copies metadata values into other fields.
+	Derive []Derivation `json:"derive,omitempty"`
+}
+
+// Match is the predicate of a rule. Every condition set must hold, an empty Match matches every event.
+type Match struct {
+	EventName []string `json:"event_name,omitempty"`
+	Channel   []string `json:"channel,omitempty"`
+
+	// Metadata holds key/value pairs compared against the text value of each metadata key.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Derivation copies the value at From, a metadata path like metadata.utm.campaign, into Field.
+// Field is event_name, channel, campaign_id, user_id or a metadata path. Missing or empty values are skipped.
+type Derivation struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+
+	// Overwrite replaces a value already set, otherwise only empty fields are filled.
+	Overwrite bool `json:"overwrite,omitempty"`
+}
+
+// Result describes what the rules did to an event.
+type Result struct {
+	// Dropped is the name of the rule that dropped the event, empty if it was kept.
+	Dropped string `json:"dropped,omitempty"`
+
+	// Applied are the names of the rules that matched, in order.
+	Applied []string `json:"applied"`
+}
+
+// topLevelFields are the event fields a derivation can write besides metadata.
+var topLevelFields = []string{"event_name", "channel", "campaign_id", "user_id"}
+
+// Transformer applies rules to events. It is safe for concurrent use.
+type Transformer struct {
+	rules []Rule
+}
+
+// New validates rules.
+func New(rules []Rule) (*Transformer, error) {
+	names := make(map[string]bool)
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d: name is required", i)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("rule %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if !rule.Drop && rule.RenameEvent == "" && len(rule.MapChannel) == 0 && len(rule.Derive) == 0 {
+			return nil, fmt.Errorf("rule %q: no action (expected drop, rename_event, map_channel or derive)", rule.Name)
+		}
+		for channel, mapped := range rule.MapChannel {
+			if mapped == "" {
+				return nil, fmt.Errorf("rule %q: channel %q is mapped to an empty value", rule.Name, channel)
+			}
+		}
+		for _, d := range rule.Derive {
+			if !strings.HasPrefix(d.From, "metadata.") {
+				return nil, fmt.Errorf("rule %q: invalid derive from %q (expected metadata.<key>)", rule.Name, d.From)
+			}
+			if !slices.Contains(topLevelFields, d.Field) && !strings.HasPrefix(d.Field, "metadata.") {
+				return nil, fmt.Errorf("rule %q: invalid derive field %q (expected %s or metadata.<key>)", rule.Name, d.Field, strings.Join(topLevelFields, ", "))
+			}
+		}
+	}
+
+	return &Transformer{rules: rules}, nil
+}
+
+// FromEnv loads the rules of the JSON file at TRANSFORM_RULES_FILE.
+// Returns a nil Transformer, which leaves events untouched, when no file is configured.
+func FromEnv() (*Transformer, error) {
+	path := os.Getenv("TRANSFORM_RULES_FILE")
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	return New(rules)
+}
+
+// Apply rewrites e in place. Rules run in order and each sees the event as rewritten by the previous ones.
+func (t *Transformer) Apply(e *model.Event) Result {
+	result := Result{Applied: []string{}}
+	if t == nil {
+		return result
+	}
+
+	for _, rule := range t.rules {
+		if !rule.When.matches(e) {
+			continue
+		}
+		result.Applied = append(result.Applied, rule.Name)
+
+		if rule.Drop {
+			result.Dropped = rule.Name
+			return result
+		}
+		if rule.RenameEvent != "" {
+			e.EventName = rule.RenameEvent
+		}
+		if mapped, ok := rule.MapChannel[e.Channel]; ok {
+			e.Channel = mapped
+		}
+		for _, d := range rule.Derive {
+			d.apply(e)
+		}
+	}
+
+	return result
+}
+
+func (m Match) matches(e *model.Event) bool {
+	if len(m.EventName) > 0 && !slices.Contains(m.EventName, e.EventName) {
+		return false
+	}
+	if len(m.Channel) > 0 && !slices.Contains(m.Channel, e.Channel) {
+		return false
+	}
+	for key, want := range m.Metadata {
+		value, ok := e.Metadata[key]
+		if !ok {
+			return false
+		}
+		if text, ok := textValue(value); !ok || text != want {
+			return false
+		}
+	}
+	return true
+}
+
+func (d Derivation) apply(e *model.Event) {
+	value, ok := lookup(e.Metadata, strings.Split(strings.TrimPrefix(d.From, "metadata."), "."))
+	if !ok {
+		return
+	}
+
+	if path, ok := strings.CutPrefix(d.Field, "metadata."); ok {
+		keys := strings.Split(path, ".")
+		if _, exists := lookup(e.Metadata, keys); exists && !d.Overwrite {
+			return
+		}
+		set(e, keys, value)
+		return
+	}
+
+	text, ok := textValue(value)
+	if !ok || text == "" {
+		return
+	}
+
+	var field *string
+	switch d.Field {
+	case "event_name":
+		field = &e.EventName
+	case "channel":
+		field = &e.Channel
+	case "campaign_id":
+		field = &e.CampaignID
+	case "user_id":
+		field = &e.UserID
+	}
+	if *field == "" || d.Overwrite {
+		*field = text
+	}
+}
+
+// lookup returns the value at a path of nested metadata objects.
+func lookup(m map[string]any, keys []string) (any, bool) {
+	value, ok := m[keys[0]]
+	if !ok || value == nil {
+		return nil, false
+	}
+	if len(keys) == 1 {
+		return value, true
+	}
+	nested, ok := value.(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	return lookup(nested, keys[1:])
+}
+
+// set writes value at a metadata path, creating the objects on the way.
+func set(e *model.Event, keys []string, value any) {
+	if e.Metadata == nil {
+		e.Metadata = make(map[string]any)
+	}
+
+	m := e.Metadata
+	for _, key := range keys[:len(keys)-1] {
+		nested, ok := m[key].(map[string]any)
+		if !ok {
+			nested = make(map[string]any)
+			m[key] = nested
+		}
+		m = nested
+	}
+	m[keys[len(keys)-1]] = value
+}
+
+// textValue formats scalar metadata values the way Postgres' ->> does.
+func textValue(value any) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case json.Number:
+		return v.String(), true
+	}
+	return "", false
+}
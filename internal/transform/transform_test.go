@@ -0,0 +1,164 @@
+package transform
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"fast-ingest/internal/model"
+)
+
+func TestNewValidatesRules(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"missing name", Rule{Drop: true}},
+		{"no action", Rule{Name: "r"}},
+		{"empty channel mapping", Rule{Name: "r", MapChannel: map[string]string{"Web": ""}}},
+		{"derive from outside metadata", Rule{Name: "r", Derive: []Derivation{{Field: "channel", From: "user_id"}}}},
+		{"derive into unknown field", Rule{Name: "r", Derive: []Derivation{{Field: "timestamp", From: "metadata.ts"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New([]Rule{tt.rule}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	if _, err := New([]Rule{{Name: "r", Drop: true}, {Name: "r", Drop: true}}); err == nil {
+		t.Error("expected error for duplicate names")
+	}
+}
+
+func TestApply(t *testing.T) {
+	tr, err := New([]Rule{
+		{Name: "drop test events", When: Match{Metadata: map[string]string{"test": "true"}}, Drop: true},
+		{Name: "page view", When: Match{EventName: []string{"PageView", "pv"}}, RenameEvent: "page_view"},
+		{Name: "channels", MapChannel: map[string]string{"Web": "web", "iOS": "ios"}},
+		{
+			Name: "utm",
+			When: Match{EventName: []string{"page_view"}, Channel: []string{"web"}},
+			Derive: []Derivation{
+				{Field: "campaign_id", From: "metadata.utm.campaign"},
+				{Field: "metadata.source", From: "metadata.utm.source"},
+				{Field: "metadata.plan", From: "metadata.utm.plan"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("rename, map and derive", func(t *testing.T) {
+		e := model.Event{
+			EventName: "pv",
+			Channel:   "Web",
+			Metadata: map[string]any{
+				"plan": "pro",
+				"utm":  map[string]any{"campaign": "spring", "source": "newsletter", "plan": "free"},
+			},
+		}
+		result := tr.Apply(&e)
+
+		if result.Dropped != "" || !reflect.DeepEqual(result.Applied, []string{"page view", "channels", "utm"}) {
+			t.Errorf("unexpected result %+v", result)
+		}
+		if e.EventName != "page_view" || e.Channel != "web" || e.CampaignID != "spring" {
+			t.Errorf("unexpected event %+v", e)
+		}
+		if e.Metadata["source"] != "newsletter" {
+			t.Errorf("expected derived source, got %v", e.Metadata["source"])
+		}
+		if e.Metadata["plan"] != "pro" {
+			t.Errorf("expected existing plan to be kept, got %v", e.Metadata["plan"])
+		}
+	})
+
+	t.Run("derive keeps set fields", func(t *testing.T) {
+		e := model.Event{EventName: "page_view", Channel: "web", CampaignID: "summer", Metadata: map[string]any{"utm": map[string]any{"campaign": "spring"}}}
+		tr.Apply(&e)
+		if e.CampaignID != "summer" {
+			t.Errorf("expected campaign_id to be kept, got %s", e.CampaignID)
+		}
+	})
+
+	t.Run("drop stops the rules", func(t *testing.T) {
+		e := model.Event{EventName: "pv", Channel: "Web", Metadata: map[string]any{"test": true}}
+		result := tr.Apply(&e)
+		if result.Dropped != "drop test events" || e.EventName != "pv" {
+			t.Errorf("unexpected result %+v for %+v", result, e)
+		}
+	})
+
+	t.Run("unmatched event", func(t *testing.T) {
+		e := model.Event{EventName: "purchase", Channel: "android"}
+		result := tr.Apply(&e)
+		if result.Dropped != "" || len(result.Applied) != 1 || e.EventName != "purchase" || e.Channel != "android" {
+			t.Errorf("unexpected result %+v for %+v", result, e)
+		}
+	})
+}
+
+func TestDeriveOverwrite(t *testing.T) {
+	tr, err := New([]Rule{{
+		Name: "user",
+		Derive: []Derivation{
+			{Field: "user_id", From: "metadata.account_id", Overwrite: true},
+			{Field: "metadata.ids.account", From: "metadata.account_id"},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := model.Event{UserID: "anonymous", Metadata: map[string]any{"account_id": float64(42)}}
+	tr.Apply(&e)
+
+	if e.UserID != "42" {
+		t.Errorf("expected user_id to be overwritten, got %s", e.UserID)
+	}
+	if ids, _ := e.Metadata["ids"].(map[string]any); ids["account"] != float64(42) {
+		t.Errorf("expected nested metadata to be created, got %v", e.Metadata["ids"])
+	}
+}
+
+func TestNilTransformer(t *testing.T) {
+	var tr *Transformer
+	e := model.Event{EventName: "pv"}
+	if result := tr.Apply(&e); result.Dropped != "" || len(result.Applied) != 0 || e.EventName != "pv" {
+		t.Errorf("expected event to be untouched, got %+v", result)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Run("disabled when unset", func(t *testing.T) {
+		t.Setenv("TRANSFORM_RULES_FILE", "")
+		tr, err := FromEnv()
+		if err != nil || tr != nil {
+			t.Errorf("expected nil transformer, got %v, %v", tr, err)
+		}
+	})
+
+	t.Run("loads rules from file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "transforms.json")
+		rules := `[{"name": "pv", "when": {"event_name": ["pv"]}, "rename_event": "page_view"}]`
+		if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("TRANSFORM_RULES_FILE", path)
+
+		tr, err := FromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := model.Event{EventName: "pv"}
+		tr.Apply(&e)
+		if e.EventName != "page_view" {
+			t.Errorf("expected rename, got %s", e.EventName)
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"fast-ingest/internal/storage"
+)
+
+type Compactor struct {
+	Store    storage.Store
+	Interval time.Duration
+}
+
+// Run periodically rebuilds the daily rollups that serve metrics older than the raw retention window.
+func (c *Compactor) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	compact := func() {
+		days, err := c.Store.CompactDailyRollups(ctx)
+		if err != nil {
+			log.Printf("Error compacting daily rollups: %v", err)
+			return
+		}
+		if days > 0 {
+			log.Printf("Compacted daily rollups for %d day(s)", days)
+		}
+	}
+
+	// Run once on startup so a fresh deployment doesn't wait a whole interval
+	compact()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			compact()
+		}
+	}
+}
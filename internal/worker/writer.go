@@ -14,6 +14,9 @@ type Writer struct {
 	In            <-chan model.Event
 	BatchSize     int
 	FlushInterval time.Duration
+
+	// Sinks receive the events of every batch once they are stored, duplicates of stored events are left out.
+	Sinks []storage.Sink
 }
 
 // Run starts the writer loop that listens for incoming events and flushes them to the storage layer in batches.
@@ -29,9 +32,16 @@ func (w *Writer) Run(ctx context.Context) {
 			return
 		}
 
-		err := w.Store.InsertEvents(ctx, batch)
+		inserted, err := w.Store.InsertEvents(ctx, batch)
 		if err != nil {
 			log.Printf("Error inserting event(s): %v", err)
+		} else if len(inserted) > 0 {
+			// Only stored events are published, so a retried event isn't delivered twice
+			for _, sink := range w.Sinks {
+				if err := sink.Publish(ctx, inserted); err != nil {
+					log.Printf("Error publishing event(s) to sink %s: %v", sink.Name(), err)
+				}
+			}
 		}
 
 		// Clear the batch after flushing
@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"fast-ingest/internal/model"
+	"fast-ingest/internal/storage"
+)
+
+// dedupeStore inserts events whose dedupe key it hasn't stored yet.
+type dedupeStore struct {
+	storage.Store
+	stored map[string]bool
+}
+
+func (s *dedupeStore) InsertEvents(_ context.Context, events []model.Event) ([]model.Event, error) {
+	var inserted []model.Event
+	for _, e := range events {
+		if key := storage.DedupeKey(e); !s.stored[key] {
+			s.stored[key] = true
+			inserted = append(inserted, e)
+		}
+	}
+	return inserted, nil
+}
+
+// recordingSink records the events published to it.
+type recordingSink struct {
+	mu     sync.Mutex
+	events []model.Event
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Publish(_ context.Context, events []model.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func TestWriterPublishesInsertedEvents(t *testing.T) {
+	purchase := model.Event{EventName: "purchase", UserID: "user_1", Timestamp: 1769904000}
+	refund := model.Event{EventName: "refund", UserID: "user_1", Timestamp: 1769904000}
+
+	in := make(chan model.Event, 3)
+	// The retried purchase is a duplicate of the stored one
+	in <- purchase
+	in <- purchase
+	in <- refund
+
+	sink := &recordingSink{}
+	w := &Writer{
+		Store:         &dedupeStore{stored: map[string]bool{}},
+		In:            in,
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+		Sinks:         []storage.Sink{sink},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	// Give the writer time to drain the queue, the batch is flushed on cancellation
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	if len(sink.events) != 2 || sink.events[0].EventName != "purchase" || sink.events[1].EventName != "refund" {
+		t.Errorf("expected each event published once, got %+v", sink.events)
+	}
+}
@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS events_hourly_rollup (
+  bucket        TIMESTAMPTZ NOT NULL,
+  event_name    TEXT        NOT NULL,
+  channel       TEXT        NOT NULL,
+  campaign_id   TEXT        NOT NULL DEFAULT '',
+
+  total_events  BIGINT      NOT NULL DEFAULT 0,
+  -- Serialized HyperLogLog sketch of user_id values, merged in the application.
+  users_hll     BYTEA       NULL,
+
+  updated_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  PRIMARY KEY (event_name, bucket, channel, campaign_id)
+);
+
+-- Tracks from which point on each rollup table is complete.
+-- The writer only maintains hourly rollups for batches committed after this migration,
+-- so coverage starts at the next full hour.
+CREATE TABLE IF NOT EXISTS rollup_state (
+  name          TEXT        PRIMARY KEY,
+  covered_from  TIMESTAMPTZ NOT NULL
+);
+
+INSERT INTO rollup_state (name, covered_from)
+VALUES ('hourly', date_trunc('hour', now()) + interval '1 hour')
+ON CONFLICT (name) DO NOTHING;
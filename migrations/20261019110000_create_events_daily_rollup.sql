@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS events_daily_rollup (
+  bucket        TIMESTAMPTZ NOT NULL,
+  event_name    TEXT        NOT NULL,
+  channel       TEXT        NOT NULL,
+  campaign_id   TEXT        NOT NULL DEFAULT '',
+
+  total_events  BIGINT      NOT NULL DEFAULT 0,
+  users_hll     BYTEA       NULL,
+
+  updated_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+  PRIMARY KEY (event_name, bucket, channel, campaign_id)
+);
+
+-- Daily rollups are rebuilt from the raw table by a background job that tracks an ingested_at watermark.
+-- ingested_through stays NULL until the first pass over the events table completes.
+ALTER TABLE rollup_state ADD COLUMN IF NOT EXISTS ingested_through TIMESTAMPTZ NULL;
+
+INSERT INTO rollup_state (name, covered_from)
+VALUES ('daily', 'epoch')
+ON CONFLICT (name) DO NOTHING;
+
+CREATE INDEX IF NOT EXISTS ix_events_ingested_at
+  ON events (ingested_at);
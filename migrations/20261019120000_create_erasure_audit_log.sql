@@ -0,0 +1,18 @@
+-- One row per erasure request, written in the same transaction as the erasure itself.
+-- The user is recorded as a SHA-256 hash so the audit log does not keep the identifier it erased.
+CREATE TABLE IF NOT EXISTS erasure_audit_log (
+  id               BIGSERIAL   PRIMARY KEY,
+  job_id           TEXT        NULL,
+  user_id_hash     TEXT        NOT NULL,
+  mode             TEXT        NOT NULL,
+  scrubbed_keys    TEXT[]      NOT NULL DEFAULT '{}',
+  requested_by     TEXT        NOT NULL DEFAULT '',
+  status           TEXT        NOT NULL,
+  events_affected  BIGINT      NOT NULL DEFAULT 0,
+  error            TEXT        NULL,
+
+  created_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS ix_erasure_audit_log_user
+  ON erasure_audit_log (user_id_hash, created_at DESC);
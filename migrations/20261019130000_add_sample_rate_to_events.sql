@@ -0,0 +1,12 @@
+-- Share of events kept by sampling at ingest, each stored event stands for 1/sample_rate events.
+ALTER TABLE events
+  ADD COLUMN IF NOT EXISTS sample_rate DOUBLE PRECISION NOT NULL DEFAULT 1
+  CHECK (sample_rate > 0 AND sample_rate <= 1);
+
+-- Sum of 1/sample_rate over the events of each rollup row, alongside the stored count.
+-- Rows written before sampling existed only hold unsampled events.
+ALTER TABLE events_hourly_rollup ADD COLUMN IF NOT EXISTS estimated_events DOUBLE PRECISION NOT NULL DEFAULT 0;
+UPDATE events_hourly_rollup SET estimated_events = total_events;
+
+ALTER TABLE events_daily_rollup ADD COLUMN IF NOT EXISTS estimated_events DOUBLE PRECISION NOT NULL DEFAULT 0;
+UPDATE events_daily_rollup SET estimated_events = total_events;
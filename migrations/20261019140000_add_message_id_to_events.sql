@@ -0,0 +1,3 @@
+-- Id a source gave the event, eg: the Segment messageId. Events with one are keyed by it, so erasure can
+-- recompute their dedupe_key without the fields the other events are keyed by.
+ALTER TABLE events ADD COLUMN IF NOT EXISTS message_id TEXT NULL;